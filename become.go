@@ -0,0 +1,136 @@
+package godexer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-extras/errors"
+)
+
+// Supported values of BecomeOptions.BecomeMethod.
+const (
+	BecomeSudo = "sudo"
+	BecomeSu   = "su"
+	BecomeDoas = "doas"
+)
+
+// BecomeOptions holds the privilege escalation fields shared by the exec and
+// file writing commands.
+type BecomeOptions struct {
+	// Become runs the command as BecomeUser.
+	Become bool
+	// BecomeUser is the (templated) target user (default root).
+	BecomeUser string
+	// BecomeMethod is sudo (the default), su or doas.
+	BecomeMethod string
+	// BecomePasswordVariable names the variable holding the password. It is
+	// never put on the command line: it is written to sudo's stdin, or over
+	// SSH on a pty, it answers sudo's prompt. Only sudo supports a password.
+	BecomePasswordVariable string
+}
+
+// Escalation is a rendered BecomeOptions.
+type Escalation struct {
+	Method   string
+	User     string
+	Password string
+	// Marker is the random password prompt given to sudo, which also ends the
+	// password on stdin. Wrap sets it if it is empty.
+	Marker string
+}
+
+// skipPassword runs "$@" after dropping the stdin lines up to the marker in
+// $0. The password comes before the marker, so the command never reads it,
+// whether sudo asked for it or not.
+const skipPassword = `while IFS= read -r l; do [ "$l" = "$0" ] && break; done; exec "$@"`
+
+// Escalation renders the become fields, returning nil if become is off.
+func (b *BecomeOptions) Escalation(r *BaseCommand, variables map[string]any) (*Escalation, error) {
+	if !b.Become {
+		if b.BecomeUser != "" || b.BecomeMethod != "" || b.BecomePasswordVariable != "" {
+			return nil, errors.New("becomeUser, becomeMethod and becomePasswordVariable require become: true")
+		}
+		return nil, nil
+	}
+
+	e := &Escalation{Method: stringDef(b.BecomeMethod, BecomeSudo)}
+	switch e.Method {
+	case BecomeSudo, BecomeSu, BecomeDoas:
+	default:
+		return nil, errors.Errorf("unsupported becomeMethod %q, must be one of sudo, su, doas", e.Method)
+	}
+
+	user, err := r.EvalString("becomeUser", stringDef(b.BecomeUser, "root"), variables)
+	if err != nil {
+		return nil, err
+	}
+	e.User = user
+
+	if b.BecomePasswordVariable != "" {
+		switch v := variables[b.BecomePasswordVariable].(type) {
+		case string:
+			e.Password = v
+		case fmt.Stringer:
+			e.Password = v.String()
+		case nil:
+			return nil, errors.Errorf("become password variable %q is not set", b.BecomePasswordVariable)
+		default:
+			return nil, errors.Errorf("become password variable %q must be a string, got %T", b.BecomePasswordVariable, v)
+		}
+	}
+
+	return e, nil
+}
+
+// Wrap returns argv run through the escalation method. Without a password
+// sudo and doas run non-interactively, so they fail instead of waiting for
+// a prompt. With a password, sudo prompts with Marker on the terminal, or
+// with passwordOnStdin reads the password from the stdin returned by
+// PasswordStdin.
+func (e *Escalation) Wrap(argv []string, passwordOnStdin bool) ([]string, error) {
+	if e.Password != "" && e.Method != BecomeSudo {
+		return nil, errors.Errorf("becomeMethod %s doesn't support a become password, use sudo", e.Method)
+	}
+
+	switch e.Method {
+	case BecomeSu:
+		return []string{"su", e.User, "-c", escapeArgs(argv)}, nil
+	case BecomeDoas:
+		result := []string{"doas", "-n", "-u", e.User, "--"}
+		return append(result, argv...), nil
+	default:
+		result := []string{"sudo"}
+		if e.Password != "" && e.Marker == "" {
+			marker := make([]byte, 16)
+			if _, err := rand.Read(marker); err != nil {
+				return nil, errors.Wrap(err, "can't generate a password prompt")
+			}
+			e.Marker = "godexer-become-" + hex.EncodeToString(marker)
+		}
+		switch {
+		case e.Password == "":
+			result = append(result, "-n", "-u", e.User, "--")
+		case passwordOnStdin:
+			result = append(result, "-S", "-p", "", "-u", e.User, "--", "sh", "-c", skipPassword, e.Marker)
+		default:
+			result = append(result, "-p", e.Marker, "-u", e.User, "--")
+		}
+		return append(result, argv...), nil
+	}
+}
+
+// PasswordStdin returns stdin with the password and the marker put in front
+// of it, as read by a command wrapped with passwordOnStdin. stdin may be nil.
+func (e *Escalation) PasswordStdin(stdin io.Reader) io.Reader {
+	if e.Password == "" {
+		return stdin
+	}
+	password := strings.NewReader(e.Password + "\n" + e.Marker + "\n")
+	if stdin == nil {
+		return password
+	}
+	return io.MultiReader(password, stdin)
+}
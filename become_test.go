@@ -0,0 +1,323 @@
+package godexer_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-extras/godexer"
+	"github.com/go-extras/godexer/internal/logger"
+	"github.com/go-extras/godexer/internal/testutils"
+)
+
+func TestEscalation(t *testing.T) {
+	escalation := func(c *qt.C, opts godexer.BecomeOptions, vars map[string]any) (*godexer.Escalation, error) {
+		return opts.Escalation(&godexer.BaseCommand{Ectx: &godexer.ExecutorContext{Logger: &logger.Logger{}}}, vars)
+	}
+
+	t.Run("wrap", func(t *testing.T) {
+		testcases := []struct {
+			name            string
+			escalation      godexer.Escalation
+			passwordOnStdin bool
+			want            []string
+		}{
+			{
+				name:       "sudo",
+				escalation: godexer.Escalation{Method: "sudo", User: "root"},
+				want:       []string{"sudo", "-n", "-u", "root", "--", "id", "-u"},
+			},
+			{
+				name:            "sudo_password_stdin",
+				escalation:      godexer.Escalation{Method: "sudo", User: "root", Password: "s3cret", Marker: "m"},
+				passwordOnStdin: true,
+				want: []string{"sudo", "-S", "-p", "", "-u", "root", "--",
+					"sh", "-c", `while IFS= read -r l; do [ "$l" = "$0" ] && break; done; exec "$@"`, "m", "id", "-u"},
+			},
+			{
+				name:       "sudo_password_prompt",
+				escalation: godexer.Escalation{Method: "sudo", User: "postgres", Password: "s3cret", Marker: "m"},
+				want:       []string{"sudo", "-p", "m", "-u", "postgres", "--", "id", "-u"},
+			},
+			{
+				name:       "su",
+				escalation: godexer.Escalation{Method: "su", User: "postgres"},
+				want:       []string{"su", "postgres", "-c", "id -u"},
+			},
+			{
+				name:       "doas",
+				escalation: godexer.Escalation{Method: "doas", User: "root"},
+				want:       []string{"doas", "-n", "-u", "root", "--", "id", "-u"},
+			},
+		}
+
+		for _, tc := range testcases {
+			t.Run(tc.name, func(t *testing.T) {
+				c := qt.New(t)
+				got, err := tc.escalation.Wrap([]string{"id", "-u"}, tc.passwordOnStdin)
+				c.Assert(err, qt.IsNil)
+				c.Assert(got, qt.DeepEquals, tc.want)
+			})
+		}
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		c := qt.New(t)
+		e, err := escalation(c, godexer.BecomeOptions{Become: true}, nil)
+		c.Assert(err, qt.IsNil)
+		c.Assert(e, qt.DeepEquals, &godexer.Escalation{Method: "sudo", User: "root"})
+
+		e, err = escalation(c, godexer.BecomeOptions{}, nil)
+		c.Assert(err, qt.IsNil)
+		c.Assert(e, qt.IsNil)
+	})
+
+	t.Run("password_variable", func(t *testing.T) {
+		c := qt.New(t)
+		e, err := escalation(c, godexer.BecomeOptions{
+			Become:                 true,
+			BecomeUser:             "{{ .db_user }}",
+			BecomePasswordVariable: "db_password",
+		}, map[string]any{"db_user": "postgres", "db_password": "s3cret"})
+		c.Assert(err, qt.IsNil)
+		c.Assert(e, qt.DeepEquals, &godexer.Escalation{Method: "sudo", User: "postgres", Password: "s3cret"})
+
+		_, err = e.Wrap([]string{"id"}, true)
+		c.Assert(err, qt.IsNil)
+		c.Assert(e.Marker, qt.Matches, "godexer-become-[0-9a-f]{32}")
+		stdin, err := io.ReadAll(e.PasswordStdin(bytes.NewBufferString("input")))
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(stdin), qt.Equals, "s3cret\n"+e.Marker+"\ninput")
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		c := qt.New(t)
+		_, err := escalation(c, godexer.BecomeOptions{Become: true, BecomeMethod: "pbrun"}, nil)
+		c.Assert(err, qt.ErrorMatches, `unsupported becomeMethod "pbrun", must be one of sudo, su, doas`)
+
+		_, err = escalation(c, godexer.BecomeOptions{BecomeUser: "root"}, nil)
+		c.Assert(err, qt.ErrorMatches, "becomeUser, becomeMethod and becomePasswordVariable require become: true")
+
+		_, err = escalation(c, godexer.BecomeOptions{Become: true, BecomePasswordVariable: "missing"}, nil)
+		c.Assert(err, qt.ErrorMatches, `become password variable "missing" is not set`)
+
+		for _, method := range []string{"su", "doas"} {
+			e := godexer.Escalation{Method: method, User: "root", Password: "s3cret"}
+			_, err = e.Wrap([]string{"id"}, false)
+			c.Assert(err, qt.ErrorMatches, "becomeMethod "+method+" doesn't support a become password, use sudo")
+		}
+	})
+}
+
+func TestExec_Become(t *testing.T) {
+	godexer.ExecCommandFn = fakeScriptCommand
+	defer func() { godexer.ExecCommandFn = exec.Command }()
+
+	t.Run("exec", func(t *testing.T) {
+		c := qt.New(t)
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: exec
+    stepName: migrate
+    cmd: ["psql", "-f", "schema.sql"]
+    env: {PGDATABASE: app}
+    stdin: "yes"
+    variable: result
+    parse: json
+    become: true
+    becomeUser: postgres
+    becomePasswordVariable: sudo_password
+`, godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(io.Discard))
+		c.Assert(err, qt.IsNil)
+
+		vars := map[string]any{"sudo_password": "s3cret"}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		result := vars["result"].(map[string]any)
+		argv := result["argv"].([]any)
+		c.Assert(argv[:10], qt.DeepEquals, []any{
+			"sudo", "-S", "-p", "", "-u", "postgres", "--", "sh", "-c", argv[9],
+		})
+		c.Assert(argv[10], qt.Matches, "godexer-become-[0-9a-f]{32}")
+		c.Assert(argv[11:], qt.DeepEquals, []any{"env", "PGDATABASE=app", "psql", "-f", "schema.sql"})
+		c.Assert(result["stdin"], qt.Equals, "s3cret\n"+argv[10].(string)+"\nyes")
+	})
+
+	t.Run("writefile", func(t *testing.T) {
+		c := qt.New(t)
+		runner := &installRunner{output: "changed\n"}
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: writefile
+    stepName: sudoers
+    file: /etc/sudoers.d/deploy
+    contents: "deploy ALL=(ALL) NOPASSWD: ALL\n"
+    permissions: "0440"
+    owner: root
+    mkdirs: true
+    become: true
+`, godexer.WithLogger(&logger.Logger{}), godexer.WithProcessRunner(runner))
+		c.Assert(err, qt.IsNil)
+
+		vars := map[string]any{}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["__step:sudoers:changed"], qt.IsTrue)
+
+		argv := append([]string{runner.spec.Path}, runner.spec.Args...)
+		c.Assert(argv[:8], qt.DeepEquals, []string{"sudo", "-n", "-u", "root", "--", "sh", "-c", argv[7]})
+		c.Assert(argv[8:11], qt.DeepEquals, []string{"sh", "-", "/etc/sudoers.d/deploy"})
+		c.Assert(argv[11], qt.Matches, `/etc/sudoers\.d/\.deploy\.godexer-[0-9a-f]{16}`)
+		c.Assert(argv[12:], qt.DeepEquals, []string{"0440", "", "root", "", "0755"})
+		stdin, err := io.ReadAll(runner.spec.Stdin)
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(stdin), qt.Equals, "deploy ALL=(ALL) NOPASSWD: ALL\n")
+
+		runner.output = "unchanged\n"
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["__step:sudoers:changed"], qt.IsFalse)
+	})
+}
+
+func TestExec_BecomeKeepsMode(t *testing.T) {
+	c := qt.New(t)
+	testutils.FakeSudo(t, "")
+	file := filepath.Join(t.TempDir(), "secrets.conf")
+	c.Assert(os.WriteFile(file, []byte("token=old\n"), 0o600), qt.IsNil)
+	current, err := user.Current()
+	c.Assert(err, qt.IsNil)
+	owner := current.Username
+	if os.Geteuid() == 0 {
+		c.Assert(os.Chown(file, 65534, -1), qt.IsNil)
+		owner = "nobody"
+	}
+
+	// without permissions, the file keeps its mode and owner
+	ex, err := godexer.NewWithScenario(`commands:
+  - type: writefile
+    stepName: secrets
+    file: '{{ .file }}'
+    contents: '{{ .contents }}'
+    become: true
+`, godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(io.Discard), godexer.WithStderr(io.Discard))
+	c.Assert(err, qt.IsNil)
+	for _, tc := range []struct {
+		contents string
+		changed  bool
+	}{{"token=new\n", true}, {"token=new\n", false}} {
+		vars := map[string]any{"file": file, "contents": tc.contents}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["__step:secrets:changed"], qt.Equals, tc.changed)
+		stat, err := exec.Command("stat", "-c", "%a %U", file).Output()
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(stat), qt.Equals, "600 "+owner+"\n")
+	}
+}
+
+// installRunner keeps the spec it is asked to start and prints output, like
+// the install script of a writefile step would.
+type installRunner struct {
+	spec   godexer.ProcessSpec
+	output string
+}
+
+func (r *installRunner) Start(spec *godexer.ProcessSpec) (godexer.Process, error) {
+	r.spec = *spec
+	_, err := io.WriteString(spec.Stdout, r.output)
+	return fakeProcess{}, err
+}
+
+func TestExec_BecomePasswordStdin(t *testing.T) {
+	run := func(c *qt.C, scenario string, vars map[string]any) {
+		ex, err := godexer.NewWithScenario(scenario, godexer.WithLogger(&logger.Logger{}),
+			godexer.WithStdout(io.Discard), godexer.WithStderr(io.Discard))
+		c.Assert(err, qt.IsNil)
+		c.Assert(ex.Execute(vars), qt.IsNil)
+	}
+
+	for _, sudoPassword := range []string{"s3cret", ""} {
+		t.Run(map[string]string{"s3cret": "prompted", "": "nopasswd"}[sudoPassword], func(t *testing.T) {
+			c := qt.New(t)
+			testutils.FakeSudo(t, sudoPassword)
+
+			// sudo without a prompt leaves the password on stdin, it must not
+			// reach the command
+			vars := map[string]any{"password": "s3cret"}
+			run(c, `commands:
+  - type: exec
+    stepName: read
+    cmd: ["cat"]
+    stdin: "line 1\nline 2\n"
+    variable: out
+    become: true
+    becomePasswordVariable: password
+`, vars)
+			c.Assert(vars["out"], qt.Equals, "line 1\nline 2\n")
+
+			file := filepath.Join(t.TempDir(), "app.conf")
+			vars = map[string]any{"password": "s3cret", "file": file}
+			run(c, `commands:
+  - type: writefile
+    stepName: config
+    file: '{{ .file }}'
+    contents: "listen 80;\n"
+    become: true
+    becomePasswordVariable: password
+`, vars)
+			data, err := os.ReadFile(file)
+			c.Assert(err, qt.IsNil)
+			c.Assert(string(data), qt.Equals, "listen 80;\n")
+		})
+	}
+
+	t.Run("wrong_password", func(t *testing.T) {
+		c := qt.New(t)
+		testutils.FakeSudo(t, "s3cret")
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: exec
+    stepName: read
+    cmd: ["cat"]
+    stdin: "data\n"
+    variable: out
+    become: true
+    becomePasswordVariable: password
+`, godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(io.Discard), godexer.WithStderr(io.Discard))
+		c.Assert(err, qt.IsNil)
+		c.Assert(ex.Execute(map[string]any{"password": "wrong"}), qt.ErrorMatches, ".*exit status 1.*")
+	})
+}
+
+func TestExec_BecomeScriptAsUser(t *testing.T) {
+	c := qt.New(t)
+	testutils.FakeSudoSwitch(t, "s3cret")
+
+	// the script runs from a file, which the become user has to be able to
+	// read and remove
+	ex, err := godexer.NewWithScenario(`commands:
+  - type: exec
+    stepName: script
+    script: |
+      id -un
+      echo "$1"
+      cat
+      echo "$0"
+    args: ["a b"]
+    stdin: "data\n"
+    variable: out
+    become: true
+    becomeUser: nobody
+    becomePasswordVariable: password
+`, godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(io.Discard), godexer.WithStderr(io.Discard))
+	c.Assert(err, qt.IsNil)
+
+	vars := map[string]any{"password": "s3cret"}
+	c.Assert(ex.Execute(vars), qt.IsNil)
+	lines := strings.Split(vars["out"].(string), "\n")
+	c.Assert(lines, qt.HasLen, 5)
+	c.Assert(lines[:3], qt.DeepEquals, []string{"nobody", "a b", "data"})
+	_, err = os.Stat(lines[3])
+	c.Assert(os.IsNotExist(err), qt.IsTrue, qt.Commentf("script file %s is left behind", lines[3]))
+}
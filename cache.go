@@ -0,0 +1,144 @@
+package godexer
+
+import (
+	"sync"
+	"sync/atomic"
+	"text/template"
+
+	"github.com/expr-lang/expr/vm"
+	"gopkg.in/Knetic/govaluate.v2"
+)
+
+// compileCacheLimit bounds the number of entries kept per cache. When the
+// limit is reached the cache is reset, which is good enough for scenarios
+// where the set of distinct sources is small and stable.
+const compileCacheLimit = 4096
+
+// funcSetVersionCounter hands out identifiers for function sets. Every change
+// to an executor's functions gets a new identifier, so compiled artifacts are
+// never reused with a different set of functions.
+var funcSetVersionCounter atomic.Uint64
+
+// valueFuncsGeneration changes whenever the global value functions change.
+var valueFuncsGeneration atomic.Uint64
+
+func nextFuncSetVersion() uint64 {
+	return funcSetVersionCounter.Add(1)
+}
+
+type boundedCache[K comparable, V any] struct {
+	mu    sync.RWMutex
+	items map[K]V
+	limit int
+}
+
+func newBoundedCache[K comparable, V any](limit int) *boundedCache[K, V] {
+	return &boundedCache[K, V]{
+		items: make(map[K]V),
+		limit: limit,
+	}
+}
+
+func (c *boundedCache[K, V]) get(key K) (V, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, ok := c.items[key]
+	return v, ok
+}
+
+func (c *boundedCache[K, V]) put(key K, val V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.limit <= 0 {
+		return
+	}
+	if len(c.items) >= c.limit {
+		c.items = make(map[K]V)
+	}
+	c.items[key] = val
+}
+
+type templateKey struct {
+	src         string
+	funcSet     uint64
+	globalFuncs uint64
+	strict      bool
+}
+
+type compiledTemplate struct {
+	tmpl *template.Template
+	err  error
+}
+
+type funcMapKey struct {
+	funcSet     uint64
+	globalFuncs uint64
+}
+
+type expressionKey struct {
+	src     string
+	funcSet uint64
+	engine  string
+}
+
+type compiledExpression struct {
+	govaluate *govaluate.EvaluableExpression
+	program   *vm.Program
+	err       error
+}
+
+// compileCache keeps parsed templates and compiled `requires` expressions.
+// It is shared between an executor and its child executors (foreach, include,
+// commands), so loops compile every source only once.
+type compileCache struct {
+	templates   *boundedCache[templateKey, compiledTemplate]
+	funcMaps    *boundedCache[funcMapKey, template.FuncMap]
+	expressions *boundedCache[expressionKey, compiledExpression]
+}
+
+func newCompileCache() *compileCache {
+	return newCompileCacheWithLimit(compileCacheLimit)
+}
+
+// newCompileCacheWithLimit returns a cache keeping up to limit entries of
+// each kind. A limit of 0 disables caching.
+func newCompileCacheWithLimit(limit int) *compileCache {
+	return &compileCache{
+		templates:   newBoundedCache[templateKey, compiledTemplate](limit),
+		funcMaps:    newBoundedCache[funcMapKey, template.FuncMap](limit),
+		expressions: newBoundedCache[expressionKey, compiledExpression](limit),
+	}
+}
+
+// globalTemplateCache backs MaybeEvalValue, which has no executor.
+var globalTemplateCache = newCompileCache()
+
+// template returns a parsed template for src, parsing it on the first use.
+func (c *compileCache) template(key templateKey, funcs func() template.FuncMap) (*template.Template, error) {
+	if cached, ok := c.templates.get(key); ok {
+		return cached.tmpl, cached.err
+	}
+
+	fkey := funcMapKey{funcSet: key.funcSet, globalFuncs: key.globalFuncs}
+	fnMap, ok := c.funcMaps.get(fkey)
+	if !ok {
+		fnMap = funcs()
+		c.funcMaps.put(fkey, fnMap)
+	}
+
+	tmpl, err := parseTemplate(key.src, fnMap, key.strict)
+	c.templates.put(key, compiledTemplate{tmpl: tmpl, err: err})
+	return tmpl, err
+}
+
+// expression returns a compiled expression for key, compiling it with
+// compile on the first use.
+func (c *compileCache) expression(key expressionKey, compile func() compiledExpression) compiledExpression {
+	if cached, ok := c.expressions.get(key); ok {
+		return cached
+	}
+
+	compiled := compile()
+	c.expressions.put(key, compiled)
+	return compiled
+}
@@ -0,0 +1,225 @@
+package godexer
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/go-extras/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported values of OutputCapture.Parse.
+const (
+	ParseJSON  = "json"
+	ParseYAML  = "yaml"
+	ParseLines = "lines"
+	ParseKV    = "kv"
+)
+
+// OutputCapture holds the output capture fields shared by the exec commands.
+type OutputCapture struct {
+	// StdoutVariable and StderrVariable receive the respective stream.
+	StdoutVariable string
+	StderrVariable string
+	// Trim removes leading and trailing whitespace from captured output.
+	Trim bool
+	// MaxCaptureBytes limits each captured value, keeping the tail. 0 means no limit.
+	MaxCaptureBytes int
+	// Parse turns the captured stdout into a structured value: json, yaml,
+	// lines or kv. The result is stored in StdoutVariable, or in the command's
+	// variable when StdoutVariable is not set.
+	Parse string
+}
+
+// Capture collects the output of a single run. Use Stdout and Stderr as the
+// process' output streams and call Store once it exits.
+type Capture struct {
+	Stdout io.Writer
+	Stderr io.Writer
+
+	opts     *OutputCapture
+	variable string
+	combined *tailBuffer
+	stdout   *tailBuffer
+	stderr   *tailBuffer
+}
+
+// StartCapture sets up capturing for a run. variable receives stdout and stderr
+// interleaved; the output is also passed through to stdout and stderr. With
+// keepStreams, both streams are captured even when no variable asks for them.
+func (o *OutputCapture) StartCapture(variable string, keepStreams bool, stdout, stderr io.Writer) (*Capture, error) {
+	switch o.Parse {
+	case "", ParseJSON, ParseYAML, ParseLines, ParseKV:
+	default:
+		return nil, errors.Errorf("unsupported parse format %q, must be one of json, yaml, lines, kv", o.Parse)
+	}
+	if o.MaxCaptureBytes < 0 {
+		return nil, errors.New("maxCaptureBytes must not be negative")
+	}
+
+	c := &Capture{opts: o, variable: variable}
+	stdoutWriters := []io.Writer{stdout}
+	stderrWriters := []io.Writer{stderr}
+	if variable != "" {
+		c.combined = newTailBuffer(o.MaxCaptureBytes)
+		stdoutWriters = append(stdoutWriters, c.combined)
+		stderrWriters = append(stderrWriters, c.combined)
+	}
+	if o.StdoutVariable != "" || (o.Parse != "" && variable != "") || keepStreams {
+		c.stdout = newTailBuffer(o.MaxCaptureBytes)
+		stdoutWriters = append(stdoutWriters, c.stdout)
+	}
+	if o.StderrVariable != "" || keepStreams {
+		c.stderr = newTailBuffer(o.MaxCaptureBytes)
+		stderrWriters = append(stderrWriters, c.stderr)
+	}
+	c.Stdout = NewCombinedWriter(stdoutWriters)
+	c.Stderr = NewCombinedWriter(stderrWriters)
+	return c, nil
+}
+
+// Store saves the captured output into variables.
+func (c *Capture) Store(variables map[string]any) error {
+	if c.variable != "" {
+		variables[c.variable] = c.text(c.combined)
+	}
+	if c.opts.StderrVariable != "" {
+		variables[c.opts.StderrVariable] = c.text(c.stderr)
+	}
+
+	target := c.opts.StdoutVariable
+	if target == "" && c.opts.Parse != "" {
+		target = c.variable
+	}
+	if target == "" {
+		return nil
+	}
+
+	stdout := c.text(c.stdout)
+	if c.opts.Parse == "" {
+		variables[target] = stdout
+		return nil
+	}
+	parsed, err := parseOutput(c.opts.Parse, stdout)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse output as %s", c.opts.Parse)
+	}
+	variables[target] = parsed
+	return nil
+}
+
+// StdoutText returns the captured stdout.
+func (c *Capture) StdoutText() string {
+	return c.text(c.stdout)
+}
+
+// StderrText returns the captured stderr.
+func (c *Capture) StderrText() string {
+	return c.text(c.stderr)
+}
+
+func (c *Capture) text(b *tailBuffer) string {
+	s := b.String()
+	if c.opts.Trim {
+		s = strings.TrimSpace(s)
+	}
+	return s
+}
+
+func parseOutput(format, s string) (any, error) {
+	switch format {
+	case ParseJSON:
+		var v any
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	case ParseYAML:
+		var v any
+		if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	case ParseLines:
+		lines := make([]any, 0)
+		for _, line := range strings.Split(s, "\n") {
+			line = strings.TrimRight(line, "\r")
+			if strings.TrimSpace(line) != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines, nil
+	case ParseKV:
+		return parseKV(s)
+	default:
+		return nil, errors.Errorf("unsupported parse format %q", format)
+	}
+}
+
+// parseKV parses `key=value` or `key: value` lines. Blank lines and lines
+// starting with # are ignored, and quoted values are unquoted.
+func parseKV(s string) (map[string]any, error) {
+	result := make(map[string]any)
+	for i, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sep := strings.IndexAny(line, "=:")
+		if sep <= 0 {
+			return nil, errors.Errorf("line %d: expected key=value or key: value", i+1)
+		}
+		key := strings.TrimSpace(line[:sep])
+		value := strings.TrimSpace(line[sep+1:])
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// tailBuffer keeps at most limit bytes, dropping the oldest ones and any
+// part of a UTF-8 character left at the start. It is safe for concurrent
+// writes from the stdout and stderr copiers.
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func newTailBuffer(limit int) *tailBuffer {
+	return &tailBuffer{limit: limit}
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf = append(b.buf, p...)
+	if b.limit > 0 && len(b.buf) > b.limit {
+		start := len(b.buf) - b.limit
+		// skip continuation bytes, but no more than a character has, in case
+		// the output isn't UTF-8
+		for i := 0; i < utf8.UTFMax-1 && start < len(b.buf) && !utf8.RuneStart(b.buf[start]); i++ {
+			start++
+		}
+		b.buf = append(b.buf[:0], b.buf[start:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
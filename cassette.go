@@ -0,0 +1,203 @@
+package godexer
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-extras/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Invocation types.
+const (
+	InvocationExec    = "exec"
+	InvocationSSHExec = "ssh_exec"
+)
+
+// ErrNotRecorded is returned when replaying a command that is not in the
+// cassette.
+var ErrNotRecorded = errors.New("command is not in the cassette")
+
+// Invocation identifies a command run by an exec or ssh_exec step.
+type Invocation struct {
+	Type string `yaml:"type"`
+	// Host is the remote address of ssh_exec steps.
+	Host string `yaml:"host,omitempty"`
+	// Argv is the rendered command, or the interpreter and its arguments for
+	// scripts.
+	Argv     []string `yaml:"argv"`
+	Script   string   `yaml:"script,omitempty"`
+	Env      []string `yaml:"env,omitempty"`
+	ClearEnv bool     `yaml:"clearEnv,omitempty"`
+	Dir      string   `yaml:"dir,omitempty"`
+	Stdin    string   `yaml:"stdin,omitempty"`
+}
+
+func (inv *Invocation) matches(other *Invocation) bool {
+	// replayed ssh_exec steps may have no client to tell the host
+	hostMatches := inv.Host == other.Host || inv.Host == "" || other.Host == ""
+	return inv.Type == other.Type &&
+		hostMatches &&
+		slices.Equal(inv.Argv, other.Argv) &&
+		inv.Script == other.Script &&
+		slices.Equal(inv.Env, other.Env) &&
+		inv.ClearEnv == other.ClearEnv &&
+		inv.Dir == other.Dir &&
+		inv.Stdin == other.Stdin
+}
+
+// Interaction is a recorded invocation and its outcome.
+type Interaction struct {
+	Invocation `yaml:",inline"`
+	Stdout     string        `yaml:"stdout,omitempty"`
+	Stderr     string        `yaml:"stderr,omitempty"`
+	ExitCode   int           `yaml:"exitCode"`
+	Duration   time.Duration `yaml:"duration"`
+}
+
+// Cassette holds recorded interactions. Record into it with WithRecording
+// and serve them back with WithReplay, which plays each interaction once, in
+// the order recorded for identical invocations.
+type Cassette struct {
+	mu           sync.Mutex
+	Interactions []Interaction `yaml:"interactions"`
+	played       []bool
+}
+
+// NewCassette returns an empty cassette.
+func NewCassette() *Cassette {
+	return &Cassette{}
+}
+
+// LoadCassette reads a cassette saved with Save.
+func LoadCassette(r io.Reader) (*Cassette, error) {
+	c := &Cassette{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.Wrap(err, "failed to parse cassette")
+	}
+	return c, nil
+}
+
+// Save writes the cassette as YAML.
+func (c *Cassette) Save(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return errors.Wrap(err, "failed to write cassette")
+	}
+	return enc.Close()
+}
+
+func (c *Cassette) add(i Interaction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interactions = append(c.Interactions, i)
+}
+
+// take returns the first interaction matching inv that was not played yet.
+func (c *Cassette) take(inv *Invocation) (*Interaction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.played) < len(c.Interactions) {
+		c.played = append(c.played, make([]bool, len(c.Interactions)-len(c.played))...)
+	}
+	for i := range c.Interactions {
+		if !c.played[i] && c.Interactions[i].matches(inv) {
+			c.played[i] = true
+			return &c.Interactions[i], true
+		}
+	}
+	return nil, false
+}
+
+// ExitStatusError is returned for replayed non-zero exit codes.
+type ExitStatusError int
+
+func (e ExitStatusError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func (e ExitStatusError) ExitCode() int {
+	return int(e)
+}
+
+// RunInvocation runs inv by calling run with stdin and the output writers.
+// When replaying a cassette, run is not called: the recorded output is
+// written instead and a non-zero recorded exit code is returned as an
+// ExitStatusError. When recording, the run is added to the cassette unless
+// it failed without an exit status.
+func (ectx *ExecutorContext) RunInvocation(inv Invocation, stdin io.Reader, stdout, stderr io.Writer, run func(stdin io.Reader, stdout, stderr io.Writer) error) error {
+	if ectx.Replay == nil && ectx.Record == nil {
+		return run(stdin, stdout, stderr)
+	}
+
+	if stdin != nil {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return errors.Wrap(err, "failed to read stdin")
+		}
+		inv.Stdin = string(data)
+		stdin = strings.NewReader(inv.Stdin)
+	}
+
+	if ectx.Replay != nil {
+		return ectx.replay(&inv, stdout, stderr)
+	}
+
+	var outBuf, errBuf strings.Builder
+	start := ectx.Now()
+	err := run(stdin, io.MultiWriter(stdout, &outBuf), io.MultiWriter(stderr, &errBuf))
+	code, exited := exitCode(err)
+	if err == nil || exited {
+		ectx.Record.add(Interaction{
+			Invocation: inv,
+			Stdout:     outBuf.String(),
+			Stderr:     errBuf.String(),
+			ExitCode:   code,
+			Duration:   ectx.Now().Sub(start),
+		})
+	}
+	return err
+}
+
+func (ectx *ExecutorContext) replay(inv *Invocation, stdout, stderr io.Writer) error {
+	i, ok := ectx.Replay.take(inv)
+	if !ok {
+		return errors.Wrapf(ErrNotRecorded, "%q", strings.Join(inv.Argv, " "))
+	}
+	ectx.Logger.Infof("Replaying: %s", strings.Join(inv.Argv, " "))
+
+	if _, err := io.WriteString(stdout, i.Stdout); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(stderr, i.Stderr); err != nil {
+		return err
+	}
+	if i.ExitCode != 0 {
+		return ExitStatusError(i.ExitCode)
+	}
+	return nil
+}
+
+// WithRecording records the commands of exec and ssh_exec steps into c.
+func WithRecording(c *Cassette) func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.ectx.Record = c
+	}
+}
+
+// WithReplay makes exec and ssh_exec steps serve their results from c instead
+// of running commands. Commands missing from c fail with ErrNotRecorded.
+func WithReplay(c *Cassette) func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.ectx.Replay = c
+	}
+}
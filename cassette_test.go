@@ -0,0 +1,173 @@
+package godexer_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-extras/godexer"
+	"github.com/go-extras/godexer/internal/logger"
+)
+
+// slowRunner takes a second of the clock to run each command.
+type slowRunner struct {
+	fakeRunner
+	clock *fakeClock
+}
+
+func (r *slowRunner) Start(spec *godexer.ProcessSpec) (godexer.Process, error) {
+	r.clock.Sleep(time.Second)
+	return r.fakeRunner.Start(spec)
+}
+
+// failingRunner fails the test if a command is started.
+type failingRunner struct {
+	t *testing.T
+}
+
+func (r failingRunner) Start(spec *godexer.ProcessSpec) (godexer.Process, error) {
+	r.t.Errorf("unexpected run of %s", spec.Path)
+	return nil, io.ErrUnexpectedEOF
+}
+
+const cassetteScenario = `commands:
+  - type: exec
+    stepName: build
+    cmd: ["make", "{{ .target }}"]
+    env: {CC: clang}
+    clearEnv: true
+    dir: /src
+    stdin: input
+    variable: build
+    attempts: 2
+  - type: exec
+    stepName: check
+    script: echo ok
+    variable: check
+`
+
+const recordedCassette = `interactions:
+  - type: exec
+    argv:
+      - make
+      - all
+    env:
+      - CC=clang
+    clearEnv: true
+    dir: /src
+    stdin: input
+    stdout: make allinput
+    exitCode: 3
+    duration: 1s
+  - type: exec
+    argv:
+      - make
+      - all
+    env:
+      - CC=clang
+    clearEnv: true
+    dir: /src
+    stdin: input
+    stdout: make allinput
+    exitCode: 0
+    duration: 1s
+  - type: exec
+    argv:
+      - /bin/sh
+      - -e
+    script: echo ok
+    stdout: /bin/sh -eecho ok
+    exitCode: 0
+    duration: 1s
+`
+
+func TestCassette(t *testing.T) {
+	t.Run("record", func(t *testing.T) {
+		c := qt.New(t)
+		clock := &fakeClock{}
+		runner := &slowRunner{fakeRunner: fakeRunner{failures: 1}, clock: clock}
+		cassette := godexer.NewCassette()
+
+		ex, err := godexer.NewWithScenario(cassetteScenario,
+			godexer.WithRecording(cassette), godexer.WithProcessRunner(runner), godexer.WithClock(clock),
+			godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(io.Discard))
+		c.Assert(err, qt.IsNil)
+		vars := map[string]any{"target": "all"}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["build"], qt.Equals, "make allinput")
+
+		var buf bytes.Buffer
+		c.Assert(cassette.Save(&buf), qt.IsNil)
+		c.Assert(buf.String(), qt.Equals, recordedCassette)
+	})
+
+	replay := func(c *qt.C, t *testing.T, scenario string, vars map[string]any) (*bytes.Buffer, error) {
+		cassette, err := godexer.LoadCassette(strings.NewReader(recordedCassette))
+		c.Assert(err, qt.IsNil)
+		var stdout bytes.Buffer
+		ex, err := godexer.NewWithScenario(scenario,
+			godexer.WithReplay(cassette), godexer.WithProcessRunner(failingRunner{t: t}), godexer.WithClock(&fakeClock{}),
+			godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(&stdout))
+		c.Assert(err, qt.IsNil)
+		return &stdout, ex.Execute(vars)
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		c := qt.New(t)
+		vars := map[string]any{"target": "all"}
+		stdout, err := replay(c, t, cassetteScenario, vars)
+		c.Assert(err, qt.IsNil)
+		c.Assert(vars["build"], qt.Equals, "make allinput")
+		c.Assert(vars["check"], qt.Equals, "/bin/sh -eecho ok")
+		c.Assert(stdout.String(), qt.Equals, "make allinputmake allinput/bin/sh -eecho ok")
+	})
+
+	t.Run("replay_exit_code", func(t *testing.T) {
+		c := qt.New(t)
+		vars := map[string]any{"target": "all"}
+		_, err := replay(c, t, `commands:
+  - type: exec
+    stepName: build
+    cmd: ["make", "{{ .target }}"]
+    env: {CC: clang}
+    clearEnv: true
+    dir: /src
+    stdin: input
+    allowFail: true
+`, vars)
+		c.Assert(err, qt.IsNil)
+		c.Assert(vars["build_exit_status"], qt.Equals, 3)
+	})
+
+	t.Run("unexpected_command", func(t *testing.T) {
+		c := qt.New(t)
+		_, err := replay(c, t, cassetteScenario, map[string]any{"target": "install"})
+		c.Assert(err, qt.ErrorIs, godexer.ErrNotRecorded)
+		c.Assert(err, qt.ErrorMatches, `.*"make install": command is not in the cassette`)
+	})
+
+	t.Run("played_once", func(t *testing.T) {
+		c := qt.New(t)
+		_, err := replay(c, t, `commands:
+  - type: exec
+    script: echo ok
+  - type: exec
+    script: echo ok
+`, map[string]any{})
+		c.Assert(err, qt.ErrorIs, godexer.ErrNotRecorded)
+	})
+
+	t.Run("load_error", func(t *testing.T) {
+		c := qt.New(t)
+		_, err := godexer.LoadCassette(strings.NewReader("interactions:\n  - argv: [ls]\n    exit: 1\n"))
+		c.Assert(err, qt.ErrorMatches, `(?s)failed to parse cassette: .*field exit not found.*`)
+
+		cassette, err := godexer.LoadCassette(strings.NewReader(""))
+		c.Assert(err, qt.IsNil)
+		c.Assert(cassette.Interactions, qt.HasLen, 0)
+	})
+}
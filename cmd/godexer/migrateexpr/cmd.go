@@ -0,0 +1,121 @@
+// Package migrateexprcmd implements the `godexer migrate-expr` command.
+package migrateexprcmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/go-extras/godexer/cmd/godexer/shared"
+	"github.com/go-extras/godexer/internal/exprmigrate"
+)
+
+// Command implements `godexer migrate-expr`.
+type Command struct {
+	ctx *shared.Context
+	cmd *cobra.Command
+
+	write         bool
+	output        string
+	addExperiment bool
+}
+
+// New creates the migrate-expr command.
+func New(ctx *shared.Context) *Command {
+	c := &Command{ctx: ctx}
+	c.cmd = &cobra.Command{
+		Use:   "migrate-expr <scenario>",
+		Short: "Rewrite govaluate requires expressions to expr syntax",
+		Long: `Translate every 'requires' expression of a scenario from govaluate to
+expr syntax. The migrated scenario is printed to stdout unless --write or
+--output is given. Expressions that cannot be translated are reported on
+stderr and left unchanged; in that case the command exits with code 2.
+Use '-' as the scenario argument to read from stdin.`,
+		Args: cobra.ExactArgs(1),
+		RunE: c.run,
+	}
+
+	f := c.cmd.Flags()
+	f.BoolVarP(&c.write, "write", "w", false, "Overwrite the scenario file in place")
+	f.StringVarP(&c.output, "output", "o", "", "Write the migrated scenario to this file")
+	f.BoolVar(&c.addExperiment, "add-experiment", false, "Add 'expr' to meta.experiments when every expression was translated")
+
+	return c
+}
+
+// Cmd returns the cobra command.
+func (c *Command) Cmd() *cobra.Command { return c.cmd }
+
+func (c *Command) run(cmd *cobra.Command, args []string) error {
+	scenarioPath := args[0]
+	if c.write && c.output != "" {
+		return shared.NewExitErrorf(1, "--write and --output are mutually exclusive")
+	}
+	if c.write && scenarioPath == "-" {
+		return shared.NewExitErrorf(1, "--write cannot be used with stdin")
+	}
+
+	var (
+		content []byte
+		err     error
+	)
+
+	if scenarioPath == "-" {
+		content, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		content, err = os.ReadFile(scenarioPath)
+	}
+	if err != nil {
+		return shared.NewExitError(3, fmt.Errorf("failed to read scenario: %w", err))
+	}
+
+	res, err := exprmigrate.MigrateScenario(content, exprmigrate.Options{AddExperiment: c.addExperiment})
+	if err != nil {
+		return shared.NewExitError(2, fmt.Errorf("failed to parse scenario: %w", err))
+	}
+
+	report(cmd.ErrOrStderr(), res)
+
+	switch {
+	case c.write:
+		err = writeFile(scenarioPath, res.Output)
+	case c.output != "":
+		err = writeFile(c.output, res.Output)
+	default:
+		_, err = cmd.OutOrStdout().Write(res.Output)
+	}
+	if err != nil {
+		return shared.NewExitError(1, fmt.Errorf("failed to write scenario: %w", err))
+	}
+
+	if n := res.Untranslated(); n > 0 {
+		return shared.NewExitErrorf(2, "%d expression(s) could not be translated", n)
+	}
+	return nil
+}
+
+func report(w io.Writer, res *exprmigrate.Result) {
+	if res.AlreadyExpr {
+		fmt.Fprintln(w, "Scenario already uses the expr experiment, nothing to do.")
+		return
+	}
+
+	for _, f := range res.Findings {
+		for _, issue := range f.Issues {
+			fmt.Fprintf(w, "line %d (%s): %s\n", f.Line, f.Path, issue)
+		}
+	}
+	if res.ExperimentAdded {
+		fmt.Fprintln(w, "Added 'expr' to meta.experiments.")
+	}
+}
+
+func writeFile(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	return os.WriteFile(path, data, mode)
+}
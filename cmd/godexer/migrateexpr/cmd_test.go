@@ -0,0 +1,119 @@
+package migrateexprcmd_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	migrateexprcmd "github.com/go-extras/godexer/cmd/godexer/migrateexpr"
+	"github.com/go-extras/godexer/cmd/godexer/shared"
+)
+
+const govaluateScenario = `commands:
+  - type: message
+    description: hello
+    requires: os IN ('debian', 'ubuntu')
+`
+
+const migratedScenario = `meta:
+  experiments:
+    - expr
+commands:
+  - type: message
+    description: hello
+    requires: os in ["debian", "ubuntu"]
+`
+
+func TestMigrateExprCmd_Stdout(t *testing.T) {
+	c := qt.New(t)
+
+	f := writeTempFile(t, govaluateScenario)
+
+	cmd := migrateexprcmd.New(&shared.Context{})
+	var out, errOut bytes.Buffer
+	cmd.Cmd().SetOut(&out)
+	cmd.Cmd().SetErr(&errOut)
+	cmd.Cmd().SetArgs([]string{"--add-experiment", f})
+
+	err := cmd.Cmd().Execute()
+	c.Assert(err, qt.IsNil)
+	c.Assert(out.String(), qt.Equals, migratedScenario)
+	c.Assert(errOut.String(), qt.Equals, "Added 'expr' to meta.experiments.\n")
+
+	// the source file is left untouched
+	data, err := os.ReadFile(f)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, govaluateScenario)
+}
+
+func TestMigrateExprCmd_Write(t *testing.T) {
+	c := qt.New(t)
+
+	f := writeTempFile(t, govaluateScenario)
+
+	cmd := migrateexprcmd.New(&shared.Context{})
+	var out bytes.Buffer
+	cmd.Cmd().SetOut(&out)
+	cmd.Cmd().SetErr(&bytes.Buffer{})
+	cmd.Cmd().SetArgs([]string{"-w", "--add-experiment", f})
+
+	err := cmd.Cmd().Execute()
+	c.Assert(err, qt.IsNil)
+	c.Assert(out.String(), qt.Equals, "")
+
+	data, err := os.ReadFile(f)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, migratedScenario)
+}
+
+func TestMigrateExprCmd_Untranslatable(t *testing.T) {
+	c := qt.New(t)
+
+	f := writeTempFile(t, `commands:
+  - type: message
+    requires: flags & 4 == 4
+`)
+
+	cmd := migrateexprcmd.New(&shared.Context{})
+	var errOut bytes.Buffer
+	cmd.Cmd().SetOut(&bytes.Buffer{})
+	cmd.Cmd().SetErr(&errOut)
+	cmd.Cmd().SetArgs([]string{"--add-experiment", f})
+
+	err := cmd.Cmd().Execute()
+	c.Assert(err, qt.IsNotNil)
+
+	var exitErr *shared.ExitError
+	c.Assert(errors.As(err, &exitErr), qt.IsTrue)
+	c.Assert(exitErr.Code, qt.Equals, 2)
+	c.Assert(strings.Contains(errOut.String(), `line 3 (commands[0]): error: bitwise operator "&"`), qt.IsTrue)
+}
+
+func TestMigrateExprCmd_MissingFile(t *testing.T) {
+	c := qt.New(t)
+
+	cmd := migrateexprcmd.New(&shared.Context{})
+	cmd.Cmd().SetArgs([]string{"/nonexistent/path/scenario.yaml"})
+
+	err := cmd.Cmd().Execute()
+	c.Assert(err, qt.IsNotNil)
+
+	var exitErr *shared.ExitError
+	c.Assert(errors.As(err, &exitErr), qt.IsTrue)
+	c.Assert(exitErr.Code, qt.Equals, 3)
+}
+
+// writeTempFile creates a temporary file with the given content and returns its path.
+func writeTempFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "scenario.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	return path
+}
@@ -4,8 +4,10 @@ package rootcmd
 import (
 	"github.com/spf13/cobra"
 
+	migrateexprcmd "github.com/go-extras/godexer/cmd/godexer/migrateexpr"
 	runcmd "github.com/go-extras/godexer/cmd/godexer/run"
 	"github.com/go-extras/godexer/cmd/godexer/shared"
+	testcmd "github.com/go-extras/godexer/cmd/godexer/test"
 	validatecmd "github.com/go-extras/godexer/cmd/godexer/validate"
 	versioncmd "github.com/go-extras/godexer/cmd/godexer/version"
 )
@@ -17,7 +19,7 @@ func New() *cobra.Command {
 	root := &cobra.Command{
 		Use:           "godexer",
 		Short:         "A CLI tool for running godexer scenarios",
-		Long:          `godexer runs and validates declarative YAML/JSON scenarios.`,
+		Long:          `godexer runs, validates and tests declarative YAML/JSON scenarios.`,
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		RunE:          func(cmd *cobra.Command, _ []string) error { return cmd.Help() },
@@ -25,6 +27,8 @@ func New() *cobra.Command {
 
 	root.AddCommand(
 		runcmd.New(ctx).Cmd(),
+		migrateexprcmd.New(ctx).Cmd(),
+		testcmd.New(ctx).Cmd(),
 		validatecmd.New(ctx).Cmd(),
 		versioncmd.New(ctx).Cmd(),
 	)
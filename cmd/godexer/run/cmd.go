@@ -2,11 +2,11 @@
 package runcmd
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -35,6 +35,8 @@ type Command struct {
 	quiet           bool
 	verbose         bool
 	includeBasePath string
+	record          string
+	replay          string
 }
 
 // New creates the run command.
@@ -48,7 +50,10 @@ func New(ctx *shared.Context) *Command {
 	Use '-' as the scenario argument to read from stdin.
 
 	Use --log-level to choose trace, debug, info, warn (or warning), or error. When set,
-	--log-level overrides the legacy -q/--quiet and -v/--verbose flags.`,
+	--log-level overrides the legacy -q/--quiet and -v/--verbose flags.
+
+	Use --record to save every exec command with its output and exit code to a cassette,
+	and --replay to serve them from the cassette instead of running the commands.`,
 		Args: cobra.ExactArgs(1),
 		RunE: c.run,
 	}
@@ -64,6 +69,9 @@ func New(ctx *shared.Context) *Command {
 	f.BoolVarP(&c.verbose, "verbose", "v", false, "Verbose mode: show debug/trace output (wins over --quiet when both are set)")
 	f.StringVar(&c.includeBasePath, "include-base-path", "",
 		"Base path for include commands (default: directory of the scenario file)")
+	f.StringVar(&c.record, "record", "", "Record the exec commands and their results into a cassette file")
+	f.StringVar(&c.replay, "replay", "", "Replay exec command results from a cassette file instead of running them")
+	c.cmd.MarkFlagsMutuallyExclusive("record", "replay")
 
 	return c
 }
@@ -103,24 +111,46 @@ func (c *Command) run(cmd *cobra.Command, args []string) error {
 		return shared.NewExitError(3, err)
 	}
 
-	// Register all built-in commands plus include (rooted at baseDir).
+	// Register all built-in commands plus include; included scripts and
+	// writefile templates are read relative to baseDir.
 	cmds := godexer.GetRegisteredCommands()
-	cmds["include"] = godexer.NewIncludeCommandWithBasePath(newRootFS(), baseDir)
+	cmds["include"] = godexer.NewIncludeCommandWithBasePath(shared.NewRootFS(), baseDir)
+	cmds["writefile"] = godexer.NewWriterFileCommandWithStorage(shared.NewRootFS(), baseDir)
 
 	logger := newCLILogger(level, cmd.ErrOrStderr())
 
-	ex, err := godexer.NewWithScenario(
-		string(content),
+	opts := []godexer.Option{
 		godexer.WithCommandTypes(cmds),
 		godexer.WithDefaultEvaluatorFunctions(),
 		godexerversion.WithVersionFuncs(),
 		godexer.WithLogger(logger),
-	)
+	}
+
+	var cassette *godexer.Cassette
+	switch {
+	case c.record != "":
+		cassette = godexer.NewCassette()
+		opts = append(opts, godexer.WithRecording(cassette))
+	case c.replay != "":
+		replay, loadErr := loadCassette(c.replay)
+		if loadErr != nil {
+			return shared.NewExitError(3, fmt.Errorf("--replay: %w", loadErr))
+		}
+		opts = append(opts, godexer.WithReplay(replay))
+	}
+
+	ex, err := godexer.NewWithScenario(string(content), opts...)
 	if err != nil {
 		return shared.NewExitError(2, fmt.Errorf("failed to parse scenario: %w", err))
 	}
 
 	execErr := c.execute(cmd.Context(), ex, variables)
+	// a failed run is worth replaying too
+	if cassette != nil {
+		if err := saveCassette(c.record, cassette); err != nil {
+			return shared.NewExitError(3, fmt.Errorf("--record: %w", err))
+		}
+	}
 	if execErr != nil {
 		return shared.NewExitError(1, fmt.Errorf("execution failed: %w", execErr))
 	}
@@ -210,6 +240,25 @@ func (c *Command) loadVariables() (map[string]any, error) {
 	return variables, nil
 }
 
+func loadCassette(path string) (*godexer.Cassette, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return godexer.LoadCassette(f)
+}
+
+// saveCassette writes the cassette readable by the owner only, as it may hold
+// secrets passed on stdin or printed by the commands.
+func saveCassette(path string, cassette *godexer.Cassette) error {
+	var buf bytes.Buffer
+	if err := cassette.Save(&buf); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o600)
+}
+
 // mergeVarFile reads a YAML or JSON file and merges its top-level keys into vars.
 func mergeVarFile(path string, vars map[string]any) error {
 	data, err := os.ReadFile(path)
@@ -226,21 +275,6 @@ func mergeVarFile(path string, vars map[string]any) error {
 	return nil
 }
 
-// rootFS is a minimal fs.ReadFileFS rooted at the filesystem root ("/").
-// It is used to satisfy godexer.NewIncludeCommandWithBasePath, which receives
-// absolute paths via the basepath argument.
-type rootFS struct{}
-
-func newRootFS() fs.ReadFileFS { return &rootFS{} }
-
-func (*rootFS) Open(name string) (fs.File, error) {
-	return os.Open(string(os.PathSeparator) + name)
-}
-
-func (*rootFS) ReadFile(name string) ([]byte, error) {
-	return os.ReadFile(string(os.PathSeparator) + name)
-}
-
 // ---------------------------------------------------------------------------
 // cliLogger implements godexer.Logger for the CLI.
 // ---------------------------------------------------------------------------
@@ -368,3 +368,84 @@ func TestRunCmd_MultipleVars(t *testing.T) {
 	err := cmd.Cmd().Execute()
 	c.Assert(err, qt.IsNil)
 }
+
+func TestRunCmd_RecordReplay(t *testing.T) {
+	c := qt.New(t)
+
+	cassette := filepath.Join(t.TempDir(), "cassette.yaml")
+	cmd := newRunCmd()
+	cmd.Cmd().SetArgs([]string{"--quiet", "--record", cassette, writeTempFile(t, execScenario)})
+	c.Assert(cmd.Cmd().Execute(), qt.IsNil)
+
+	data, err := os.ReadFile(cassette)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Contains, "stdout: |\n      run-ok\n")
+
+	// the replayed command does not exist, so it must not run
+	missing := `commands:
+  - type: exec
+    cmd: ["godexer-no-such-command", "--flag"]
+    variable: out
+  - type: exec
+    cmd: ["godexer-no-such-command", "--other"]
+`
+	err = os.WriteFile(cassette, []byte(`interactions:
+  - type: exec
+    argv: [godexer-no-such-command, --flag]
+    stdout: replayed
+    exitCode: 0
+`), 0o600)
+	c.Assert(err, qt.IsNil)
+
+	cmd = newRunCmd()
+	cmd.Cmd().SetArgs([]string{"--quiet", "--replay", cassette, writeTempFile(t, missing)})
+	err = cmd.Cmd().Execute()
+	var exitErr *shared.ExitError
+	c.Assert(errors.As(err, &exitErr), qt.IsTrue)
+	c.Assert(exitErr.Code, qt.Equals, 1)
+	c.Assert(err, qt.ErrorMatches, `execution failed: .*"godexer-no-such-command --other": command is not in the cassette`)
+}
+
+func TestRunCmd_ReplayErrors(t *testing.T) {
+	c := qt.New(t)
+	f := writeTempFile(t, execScenario)
+
+	cmd := newRunCmd()
+	cmd.Cmd().SetArgs([]string{"--quiet", "--replay", "/nonexistent/cassette.yaml", f})
+	err := cmd.Cmd().Execute()
+	var exitErr *shared.ExitError
+	c.Assert(errors.As(err, &exitErr), qt.IsTrue)
+	c.Assert(exitErr.Code, qt.Equals, 3)
+
+	cmd = newRunCmd()
+	cmd.Cmd().SetArgs([]string{"--quiet", "--replay", "a.yaml", "--record", "b.yaml", f})
+	c.Assert(cmd.Cmd().Execute(), qt.ErrorMatches, `.*\[record replay\] were all set`)
+}
+
+func TestRunCmd_WriteFileTemplate(t *testing.T) {
+	c := qt.New(t)
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out", "app.conf")
+	c.Assert(os.MkdirAll(filepath.Join(dir, "templates", "partials"), 0o755), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "templates", "app.conf.tmpl"),
+		[]byte(`{{ template "header" . }}name = {{ .name }}`+"\n"), 0o600), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "templates", "partials", "header.tmpl"),
+		[]byte("# managed by godexer\n"), 0o600), qt.IsNil)
+	f := filepath.Join(dir, "scenario.yaml")
+	c.Assert(os.WriteFile(f, []byte(`commands:
+  - type: writefile
+    file: '{{ .out }}'
+    template: templates/app.conf.tmpl
+    partials: templates/partials
+    mkdirs: true
+`), 0o600), qt.IsNil)
+
+	cmd := newRunCmd()
+	cmd.Cmd().SetArgs([]string{"--quiet", "--var", "out=" + out, "--var", "name=web", f})
+	c.Assert(cmd.Cmd().Execute(), qt.IsNil)
+
+	data, err := os.ReadFile(out)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, "# managed by godexer\nname = web\n")
+}
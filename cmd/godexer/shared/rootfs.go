@@ -0,0 +1,23 @@
+package shared
+
+import (
+	"io/fs"
+	"os"
+)
+
+// rootFS is a minimal fs.ReadFileFS rooted at the filesystem root ("/").
+// It is used to satisfy godexer.NewIncludeCommandWithBasePath, which receives
+// absolute paths via the basepath argument.
+type rootFS struct{}
+
+// NewRootFS returns an fs.ReadFileFS rooted at the filesystem root, for
+// include commands with an absolute base path.
+func NewRootFS() fs.ReadFileFS { return &rootFS{} }
+
+func (*rootFS) Open(name string) (fs.File, error) {
+	return os.Open(string(os.PathSeparator) + name)
+}
+
+func (*rootFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(string(os.PathSeparator) + name)
+}
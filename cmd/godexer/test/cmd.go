@@ -0,0 +1,89 @@
+// Package testcmd implements the `godexer test` command.
+package testcmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/go-extras/godexer/cmd/godexer/shared"
+)
+
+// Command implements `godexer test`.
+type Command struct {
+	ctx *shared.Context
+	cmd *cobra.Command
+
+	format      string
+	idempotence bool
+}
+
+// New creates the test command.
+func New(ctx *shared.Context) *Command {
+	c := &Command{ctx: ctx}
+	c.cmd = &cobra.Command{
+		Use:   "test <test-file>...",
+		Short: "Run scenario test suites against mocked commands and files",
+		Long: `Run YAML test files against their scenarios without touching real hosts.
+
+Each test file names a scenario (relative to the test file) and lists cases with
+input variables, mocked command results matched by argv pattern, seeded files and
+expectations about the executed steps, final variables and written files.
+
+With --idempotence, each passing case is run a second time on the resulting files
+and fails if any step reports a change.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: c.run,
+	}
+
+	f := c.cmd.Flags()
+	f.StringVar(&c.format, "format", FormatTAP, "Report format (tap, junit)")
+	f.BoolVar(&c.idempotence, "idempotence", false, "Run each case twice and fail if the second run changes anything")
+
+	return c
+}
+
+// Cmd returns the cobra command.
+func (c *Command) Cmd() *cobra.Command { return c.cmd }
+
+func (c *Command) run(cmd *cobra.Command, args []string) error {
+	var write func(w io.Writer, results []Result) error
+	switch c.format {
+	case FormatTAP:
+		write = writeTAP
+	case FormatJUnit:
+		write = writeJUnit
+	default:
+		return shared.NewExitErrorf(3, "--format: unsupported format %q (expected tap or junit)", c.format)
+	}
+
+	suites := make([]*Suite, 0, len(args))
+	for _, path := range args {
+		s, err := LoadSuite(path)
+		if err != nil {
+			return shared.NewExitError(3, fmt.Errorf("failed to load test file: %w", err))
+		}
+		suites = append(suites, s)
+	}
+
+	var results []Result
+	for _, s := range suites {
+		results = append(results, s.Run(c.idempotence)...)
+	}
+
+	if err := write(cmd.OutOrStdout(), results); err != nil {
+		return shared.NewExitError(3, fmt.Errorf("failed to write report: %w", err))
+	}
+
+	failed := 0
+	for _, r := range results {
+		if !r.Passed() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return shared.NewExitErrorf(1, "%d of %d test cases failed", failed, len(results))
+	}
+	return nil
+}
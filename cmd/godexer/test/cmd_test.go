@@ -0,0 +1,246 @@
+package testcmd_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-extras/godexer/cmd/godexer/shared"
+	testcmd "github.com/go-extras/godexer/cmd/godexer/test"
+)
+
+const scenario = `commands:
+  - type: exec
+    stepName: rev
+    variable: rev
+    cmd: ["git", "rev-parse", "HEAD"]
+    changedWhen: 'false'
+  - type: include
+    stepName: config
+    file: config.yaml
+  - type: exec
+    stepName: notify
+    cmd: ["notify", "{{ .rev }}"]
+    requires: notify
+  - type: exec
+    stepName: restart
+    cmd: ["systemctl", "restart", "app"]
+    changedWhen: 'stdout == "restarted"'
+`
+
+const included = `commands:
+  - type: writefile
+    stepName: write
+    file: /etc/app.conf
+    contents: 'rev={{ .rev }}'
+`
+
+// mocks returns the mocks of a case whose restart prints stdout.
+func mocks(stdout string) string {
+	return `    mocks:
+      - match: '^git rev-parse'
+        stdout: abc123
+      - match: '^notify '
+      - match: '^systemctl restart'
+        stdout: '` + stdout + `'
+`
+}
+
+// writeSuite writes the scenario and the test file into a temp dir and
+// returns the test file path.
+func writeSuite(t *testing.T, suite string) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"deploy.yaml":      scenario,
+		"config.yaml":      included,
+		"deploy_test.yaml": suite,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return filepath.Join(dir, "deploy_test.yaml")
+}
+
+func runTest(args ...string) (string, error) {
+	cmd := testcmd.New(&shared.Context{})
+	cmd.Cmd().SilenceUsage = true
+	cmd.Cmd().SilenceErrors = true
+	var out bytes.Buffer
+	cmd.Cmd().SetOut(&out)
+	cmd.Cmd().SetArgs(args)
+	err := cmd.Cmd().Execute()
+	return out.String(), err
+}
+
+func exitCode(c *qt.C, err error) int {
+	var exitErr *shared.ExitError
+	c.Assert(errors.As(err, &exitErr), qt.IsTrue, qt.Commentf("%v", err))
+	return exitErr.Code
+}
+
+func TestTestCmd_Passing(t *testing.T) {
+	c := qt.New(t)
+
+	f := writeSuite(t, `scenario: deploy.yaml
+cases:
+  - name: notifies
+    vars: {notify: true}
+    files:
+      /etc/app.conf: rev=old
+`+mocks("restarted")+`    expect:
+      ran: [rev, write, notify, restart]
+      commands: ['^notify abc123$']
+      vars:
+        rev: abc123
+        notify: true
+      files:
+        /etc/app.conf: rev=abc123
+  - name: quiet
+    vars: {notify: false}
+`+mocks("")+`    expect:
+      ran: [restart]
+      skipped: [notify]
+      absentFiles: [/etc/other.conf]
+`)
+
+	out, err := runTest(f)
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, "TAP version 13\n1..2\nok 1 - "+f+": notifies\nok 2 - "+f+": quiet\n")
+}
+
+func TestTestCmd_Failing(t *testing.T) {
+	c := qt.New(t)
+
+	f := writeSuite(t, `scenario: deploy.yaml
+cases:
+  - name: wrong expectations
+    vars: {notify: false}
+`+mocks("")+`    expect:
+      ran: [notify]
+      vars: {rev: def456}
+      files:
+        /etc/app.conf: rev=def456
+  - name: unmocked command
+    vars: {notify: false}
+    expect:
+      error: 'unexpected command'
+  - name: unexpected failure
+    vars: {notify: false}
+`)
+
+	out, err := runTest(f)
+	c.Assert(exitCode(c, err), qt.Equals, 1)
+	c.Assert(err, qt.ErrorMatches, "2 of 3 test cases failed")
+	c.Assert(out, qt.Equals, `TAP version 13
+1..3
+not ok 1 - `+f+`: wrong expectations
+  ---
+  failures:
+    - "step \"notify\" was skipped, expected it to run"
+    - "variable \"rev\" = \"abc123\", want \"def456\""
+    - "file /etc/app.conf contents = \"rev=abc123\", want \"rev=def456\""
+  ...
+ok 2 - `+f+`: unmocked command
+not ok 3 - `+f+`: unexpected failure
+  ---
+  failures:
+    - "execution failed: command failed (stepName=rev, commandId=1, commandType=ExecCommand): \"git rev-parse HEAD\": unexpected command"
+  ...
+`)
+}
+
+func TestTestCmd_JUnit(t *testing.T) {
+	c := qt.New(t)
+
+	f := writeSuite(t, `scenario: deploy.yaml
+cases:
+  - name: passes
+    vars: {notify: false}
+`+mocks("")+`  - name: fails
+    vars: {notify: false}
+`+mocks("")+`    expect:
+      error: boom
+`)
+
+	out, err := runTest("--format", "junit", f)
+	c.Assert(exitCode(c, err), qt.Equals, 1)
+	c.Assert(out, qt.Equals, `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites tests="2" failures="1">
+  <testsuite name="`+f+`" tests="2" failures="1">
+    <testcase name="passes" classname="`+f+`"></testcase>
+    <testcase name="fails" classname="`+f+`">
+      <failure message="execution succeeded, want an error matching &#34;boom&#34;">execution succeeded, want an error matching &#34;boom&#34;</failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+`)
+}
+
+func TestTestCmd_Idempotence(t *testing.T) {
+	c := qt.New(t)
+
+	f := writeSuite(t, `scenario: deploy.yaml
+cases:
+  - name: converged
+    vars: {notify: false}
+`+mocks("")+`  - name: restarts every time
+    vars: {notify: false}
+`+mocks("restarted"))
+
+	out, err := runTest(f)
+	c.Assert(err, qt.IsNil)
+
+	out, err = runTest("--idempotence", f)
+	c.Assert(exitCode(c, err), qt.Equals, 1)
+	c.Assert(out, qt.Equals, `TAP version 13
+1..2
+ok 1 - `+f+`: converged
+not ok 2 - `+f+`: restarts every time
+  ---
+  failures:
+    - "step \"restart\" changed on the second run"
+  ...
+`)
+}
+
+func TestTestCmd_LoadErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		suite    string
+		errMatch string
+	}{
+		{"unknown_field", "scenario: deploy.yaml\ncases:\n  - name: x\n    mock: []\n", `(?s).*field mock not found.*`},
+		{"no_scenario", "cases:\n  - name: x\n", `.*scenario is required`},
+		{"no_cases", "scenario: deploy.yaml\n", `.*no cases`},
+		{"unnamed_case", "scenario: deploy.yaml\ncases:\n  - vars: {}\n", `.*case #1 has no name`},
+		{"bad_pattern", "scenario: deploy.yaml\ncases:\n  - name: x\n    mocks: [{match: '('}]\n", `.*case "x": invalid mock match.*`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			_, err := runTest(writeSuite(t, tc.suite))
+			c.Assert(exitCode(c, err), qt.Equals, 3)
+			c.Assert(err, qt.ErrorMatches, tc.errMatch)
+		})
+	}
+
+	t.Run("missing_scenario", func(t *testing.T) {
+		c := qt.New(t)
+		out, err := runTest(writeSuite(t, "scenario: missing.yaml\ncases:\n  - name: x\n"))
+		c.Assert(exitCode(c, err), qt.Equals, 1)
+		c.Assert(out, qt.Contains, "failed to read scenario")
+	})
+
+	t.Run("bad_format", func(t *testing.T) {
+		c := qt.New(t)
+		_, err := runTest("--format", "xml", writeSuite(t, "scenario: deploy.yaml\ncases:\n  - name: x\n"))
+		c.Assert(exitCode(c, err), qt.Equals, 3)
+	})
+}
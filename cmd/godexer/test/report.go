@@ -0,0 +1,103 @@
+package testcmd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Supported report formats.
+const (
+	FormatTAP   = "tap"
+	FormatJUnit = "junit"
+)
+
+// writeTAP writes the results in TAP version 13, with the failures of a case
+// in its YAML diagnostic block.
+func writeTAP(w io.Writer, results []Result) error {
+	var sb strings.Builder
+	sb.WriteString("TAP version 13\n")
+	fmt.Fprintf(&sb, "1..%d\n", len(results))
+	for i, r := range results {
+		status := "ok"
+		if !r.Passed() {
+			status = "not ok"
+		}
+		fmt.Fprintf(&sb, "%s %d - %s: %s\n", status, i+1, r.Suite, r.Name)
+		if r.Passed() {
+			continue
+		}
+		sb.WriteString("  ---\n  failures:\n")
+		for _, f := range r.Failures {
+			fmt.Fprintf(&sb, "    - %q\n", f)
+		}
+		sb.WriteString("  ...\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes the results as JUnit XML, with a test suite per file.
+func writeJUnit(w io.Writer, results []Result) error {
+	report := junitTestSuites{}
+	index := make(map[string]int)
+	for _, r := range results {
+		i, ok := index[r.Suite]
+		if !ok {
+			i = len(report.Suites)
+			index[r.Suite] = i
+			report.Suites = append(report.Suites, junitTestSuite{Name: r.Suite})
+		}
+		s := &report.Suites[i]
+
+		tc := junitTestCase{Name: r.Name, ClassName: r.Suite}
+		if !r.Passed() {
+			tc.Failure = &junitFailure{
+				Message: r.Failures[0],
+				Text:    strings.Join(r.Failures, "\n"),
+			}
+			s.Failures++
+			report.Failures++
+		}
+		s.Tests++
+		report.Tests++
+		s.Cases = append(s.Cases, tc)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
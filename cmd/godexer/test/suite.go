@@ -0,0 +1,269 @@
+package testcmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/go-extras/godexer"
+	"github.com/go-extras/godexer/cmd/godexer/shared"
+	"github.com/go-extras/godexer/godexertest"
+	godexerversion "github.com/go-extras/godexer/version"
+)
+
+// Suite is a test file: cases run against one scenario.
+type Suite struct {
+	// Scenario is the scenario path, relative to the test file.
+	Scenario string `yaml:"scenario"`
+	Cases    []Case `yaml:"cases"`
+
+	path string
+}
+
+// Case is a single test case.
+type Case struct {
+	Name   string            `yaml:"name"`
+	Vars   map[string]any    `yaml:"vars"`
+	Files  map[string]string `yaml:"files"`
+	Mocks  []Mock            `yaml:"mocks"`
+	Expect Expect            `yaml:"expect"`
+}
+
+// Mock scripts the result of the commands whose argv, joined with spaces,
+// matches the Match regular expression.
+type Mock struct {
+	Match    string `yaml:"match"`
+	Stdout   string `yaml:"stdout"`
+	Stderr   string `yaml:"stderr"`
+	ExitCode int    `yaml:"exitCode"`
+}
+
+// Expect lists what a case checks after running the scenario.
+type Expect struct {
+	// Error is a regular expression the execution error must match. When
+	// empty, the execution must succeed.
+	Error       string            `yaml:"error"`
+	Ran         []string          `yaml:"ran"`
+	Skipped     []string          `yaml:"skipped"`
+	Commands    []string          `yaml:"commands"`
+	Vars        map[string]any    `yaml:"vars"`
+	Files       map[string]string `yaml:"files"`
+	AbsentFiles []string          `yaml:"absentFiles"`
+}
+
+// Result is the outcome of a case.
+type Result struct {
+	Suite    string
+	Name     string
+	Failures []string
+}
+
+// Passed reports whether the case had no failures.
+func (r *Result) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// LoadSuite reads and validates a test file.
+func LoadSuite(path string) (*Suite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Suite
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if s.Scenario == "" {
+		return nil, fmt.Errorf("%s: scenario is required", path)
+	}
+	if len(s.Cases) == 0 {
+		return nil, fmt.Errorf("%s: no cases", path)
+	}
+	for i, tc := range s.Cases {
+		if tc.Name == "" {
+			return nil, fmt.Errorf("%s: case #%d has no name", path, i+1)
+		}
+		for _, m := range tc.Mocks {
+			if _, err := regexp.Compile(m.Match); err != nil {
+				return nil, fmt.Errorf("%s: case %q: invalid mock match: %w", path, tc.Name, err)
+			}
+		}
+		for _, p := range tc.Expect.Commands {
+			if _, err := regexp.Compile(p); err != nil {
+				return nil, fmt.Errorf("%s: case %q: invalid expected command: %w", path, tc.Name, err)
+			}
+		}
+		if _, err := regexp.Compile(tc.Expect.Error); err != nil {
+			return nil, fmt.Errorf("%s: case %q: invalid expected error: %w", path, tc.Name, err)
+		}
+	}
+	s.path = path
+	return &s, nil
+}
+
+// Run runs the cases. With idempotence, each case that passes is run a second
+// time on the resulting files, which must change nothing.
+func (s *Suite) Run(idempotence bool) []Result {
+	results := make([]Result, 0, len(s.Cases))
+
+	scenario, baseDir, err := s.readScenario()
+	for _, tc := range s.Cases {
+		r := Result{Suite: s.path, Name: tc.Name}
+		if err != nil {
+			r.Failures = []string{fmt.Sprintf("failed to read scenario: %v", err)}
+		} else {
+			r.Failures = runCase(scenario, baseDir, tc, idempotence)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// readScenario returns the scenario and the absolute base directory for its
+// includes.
+func (s *Suite) readScenario() (scenario, baseDir string, err error) {
+	path := s.Scenario
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(s.path), path)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	baseDir, err = filepath.Abs(filepath.Dir(path))
+	return string(content), baseDir, err
+}
+
+func runCase(scenario, baseDir string, tc Case, idempotence bool) []string {
+	h := godexertest.New(tc.Files)
+	for _, m := range tc.Mocks {
+		h.Runner.On(m.Match, godexertest.Result{Stdout: m.Stdout, Stderr: m.Stderr, ExitCode: m.ExitCode})
+	}
+
+	cmds := godexer.GetRegisteredCommands()
+	cmds["include"] = godexer.NewIncludeCommandWithBasePath(shared.NewRootFS(), baseDir)
+	cmds["writefile"] = godexer.NewWriterFileCommandWithStorage(shared.NewRootFS(), baseDir)
+	opts := []godexer.Option{
+		godexer.WithCommandTypes(cmds),
+		godexer.WithDefaultEvaluatorFunctions(),
+		godexerversion.WithVersionFuncs(),
+	}
+
+	vars, err := h.Run(scenario, maps.Clone(tc.Vars), opts...)
+	failures := checkError(err, tc.Expect.Error)
+	if err != nil {
+		return failures
+	}
+	failures = append(failures, checkExpectations(h, vars, tc.Expect)...)
+	if len(failures) > 0 || !idempotence {
+		return failures
+	}
+
+	vars, err = h.Run(scenario, maps.Clone(tc.Vars), opts...)
+	if err != nil {
+		return []string{fmt.Sprintf("second run failed: %v", err)}
+	}
+	for _, step := range changedSteps(vars) {
+		failures = append(failures, fmt.Sprintf("step %q changed on the second run", step))
+	}
+	return failures
+}
+
+func checkError(err error, want string) []string {
+	switch {
+	case want == "" && err != nil:
+		return []string{fmt.Sprintf("execution failed: %v", err)}
+	case want == "":
+		return nil
+	case err == nil:
+		return []string{fmt.Sprintf("execution succeeded, want an error matching %q", want)}
+	case !regexp.MustCompile(want).MatchString(err.Error()):
+		return []string{fmt.Sprintf("execution error %q does not match %q", err.Error(), want)}
+	}
+	return nil
+}
+
+func checkExpectations(h *godexertest.Harness, vars map[string]any, e Expect) []string {
+	var errs []error
+	for _, step := range e.Ran {
+		errs = append(errs, godexertest.CheckStepRan(vars, step))
+	}
+	for _, step := range e.Skipped {
+		errs = append(errs, godexertest.CheckStepSkipped(vars, step))
+	}
+	for _, p := range e.Commands {
+		errs = append(errs, godexertest.CheckCommandRan(h.Runner, p))
+	}
+	for _, name := range sortedKeys(e.Vars) {
+		errs = append(errs, checkVariable(vars, name, e.Vars[name]))
+	}
+	for _, name := range sortedKeys(e.Files) {
+		errs = append(errs, godexertest.CheckFileContents(h.Fs, name, e.Files[name]))
+	}
+	for _, name := range e.AbsentFiles {
+		errs = append(errs, godexertest.CheckNoFile(h.Fs, name))
+	}
+
+	var failures []string
+	for _, err := range errs {
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	return failures
+}
+
+// checkVariable compares the variable with want after bringing both to their
+// JSON form, so that e.g. YAML integers equal numbers computed by expressions.
+func checkVariable(vars map[string]any, name string, want any) error {
+	if got, ok := vars[name]; ok {
+		vars = map[string]any{name: normalize(got)}
+	}
+	return godexertest.CheckVariable(vars, name, normalize(want))
+}
+
+func normalize(v any) any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var result any
+	if err := json.Unmarshal(data, &result); err != nil {
+		return v
+	}
+	return result
+}
+
+// changedSteps returns the steps that reported changes, sorted.
+func changedSteps(vars map[string]any) []string {
+	var steps []string
+	for k, v := range vars {
+		step, ok := strings.CutPrefix(k, "__step:")
+		if !ok {
+			continue
+		}
+		if step, ok = strings.CutSuffix(step, ":changed"); ok && v == true {
+			steps = append(steps, step)
+		}
+	}
+	sort.Strings(steps)
+	return steps
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,368 @@
+package godexer
+
+import (
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-extras/errors"
+	"github.com/spf13/afero"
+)
+
+//nolint:gochecknoinits // init is used for automatic command registration
+func init() {
+	RegisterCommand("lineinfile", NewLineInFileCommand)
+	RegisterCommand("blockinfile", NewBlockInFileCommand)
+}
+
+// Supported values of EditOptions.State.
+const (
+	StatePresent = "present"
+	StateAbsent  = "absent"
+)
+
+// Special values of EditOptions.InsertBefore and InsertAfter.
+const (
+	InsertBOF = "BOF"
+	InsertEOF = "EOF"
+)
+
+// DefaultBlockMarker surrounds the blocks of blockinfile commands.
+const DefaultBlockMarker = "# {mark} GODEXER MANAGED BLOCK"
+
+// FileEditor edits the contents of a file.
+type FileEditor interface {
+	Edit(r *BaseCommand, variables map[string]any, contents []byte) ([]byte, error)
+}
+
+// EditOptions holds the fields shared by the lineinfile and blockinfile
+// commands.
+type EditOptions struct {
+	File string
+	// Permissions is the file mode to set; by default existing files keep
+	// theirs and created ones get 0644.
+	Permissions string
+	// State is present (the default) or absent.
+	State string
+	// InsertAfter and InsertBefore are regular expressions placing new
+	// content after or before the last matching line, or at the end if no
+	// line matches. InsertBefore can be BOF for the beginning of the file and
+	// InsertAfter EOF for its end, the default.
+	InsertAfter  string
+	InsertBefore string
+	// Create creates a missing file instead of failing.
+	Create bool
+}
+
+func (o *EditOptions) absent(r *BaseCommand, variables map[string]any) (bool, error) {
+	state, err := r.EvalString("state", o.State, variables)
+	if err != nil {
+		return false, err
+	}
+	switch state {
+	case "", StatePresent:
+		return false, nil
+	case StateAbsent:
+		return true, nil
+	default:
+		return false, errors.Errorf("unsupported state %q, must be one of present, absent", state)
+	}
+}
+
+// Mode returns the file mode to set, or 0 to keep the existing one.
+func (o *EditOptions) Mode() (os.FileMode, error) {
+	if o.Permissions == "" {
+		return 0, nil
+	}
+	return ParsePermissions(o.Permissions)
+}
+
+// Apply edits contents, the file's current contents if exists is set. It
+// returns false if there is nothing to write: the file is missing and the
+// content is meant to be absent.
+func (o *EditOptions) Apply(r *BaseCommand, variables map[string]any, editor FileEditor, fileName string, contents []byte, exists bool) ([]byte, bool, error) {
+	absent, err := o.absent(r, variables)
+	if err != nil {
+		return nil, false, err
+	}
+	if !exists {
+		if absent {
+			return nil, false, nil
+		}
+		if !o.Create {
+			return nil, false, errors.Errorf("file %s in %q does not exist, set create: true to create it", fileName, r.StepName)
+		}
+	}
+
+	edited, err := editor.Edit(r, variables, contents)
+	if err != nil {
+		return nil, false, err
+	}
+	return edited, true, nil
+}
+
+// insertAt returns the index of the line new content is inserted at.
+func (o *EditOptions) insertAt(r *BaseCommand, variables map[string]any, lines []string) (int, error) {
+	after, err := r.EvalString("insertAfter", o.InsertAfter, variables)
+	if err != nil {
+		return 0, err
+	}
+	before, err := r.EvalString("insertBefore", o.InsertBefore, variables)
+	if err != nil {
+		return 0, err
+	}
+
+	field, pattern, offset := "insertAfter", after, 1
+	switch {
+	case after != "" && before != "":
+		return 0, errors.Errorf("insertAfter and insertBefore in %q are mutually exclusive", r.StepName)
+	case before == InsertBOF:
+		return 0, nil
+	case before != "":
+		field, pattern, offset = "insertBefore", before, 0
+	case after == "" || after == InsertEOF:
+		return len(lines), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s in %q", field, r.StepName)
+	}
+	for i := len(lines) - 1; i >= 0; i-- {
+		if re.MatchString(lines[i]) {
+			return i + offset, nil
+		}
+	}
+	return len(lines), nil
+}
+
+// splitLines splits contents into lines, reporting whether the last one is
+// terminated.
+func splitLines(contents string) ([]string, bool) {
+	if contents == "" {
+		return nil, true
+	}
+	terminated := strings.HasSuffix(contents, "\n")
+	return strings.Split(strings.TrimSuffix(contents, "\n"), "\n"), terminated
+}
+
+func joinLines(lines []string, terminated bool) []byte {
+	s := strings.Join(lines, "\n")
+	if terminated && len(lines) > 0 {
+		s += "\n"
+	}
+	return []byte(s)
+}
+
+// LineInFileOptions makes sure a line is present in or absent from a file.
+type LineInFileOptions struct {
+	EditOptions
+	// Line is the line to make present. With state absent, lines equal to it
+	// are removed unless Regexp is set.
+	Line string
+	// Regexp selects the line to replace with Line, the last one if several
+	// match, or with state absent, the lines to remove.
+	Regexp string
+}
+
+// Edit returns contents with the line made present or absent.
+func (o *LineInFileOptions) Edit(r *BaseCommand, variables map[string]any, contents []byte) ([]byte, error) {
+	absent, err := o.absent(r, variables)
+	if err != nil {
+		return nil, err
+	}
+	line, err := r.EvalString("line", o.Line, variables)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := r.EvalString("regexp", o.Regexp, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	match := func(l string) bool { return l == line }
+	switch {
+	case pattern != "":
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid regexp in %q", r.StepName)
+		}
+		match = re.MatchString
+	case line == "":
+		return nil, errors.Errorf("line in %q is empty", r.StepName)
+	}
+	if !absent && strings.Contains(line, "\n") {
+		return nil, errors.Errorf("line in %q spans several lines, use blockinfile", r.StepName)
+	}
+
+	lines, terminated := splitLines(string(contents))
+	if absent {
+		kept := slices.DeleteFunc(slices.Clone(lines), match)
+		if len(kept) == len(lines) {
+			return contents, nil
+		}
+		return joinLines(kept, terminated), nil
+	}
+
+	last := -1
+	for i, l := range lines {
+		if match(l) {
+			last = i
+		}
+	}
+	switch {
+	case last >= 0 && lines[last] == line, last < 0 && slices.Contains(lines, line):
+		return contents, nil
+	case last >= 0:
+		lines[last] = line
+		return joinLines(lines, true), nil
+	}
+
+	at, err := o.insertAt(r, variables, lines)
+	if err != nil {
+		return nil, err
+	}
+	return joinLines(slices.Insert(lines, at, line), true), nil
+}
+
+// BlockInFileOptions makes sure a block of lines surrounded by marker lines
+// is present in or absent from a file.
+type BlockInFileOptions struct {
+	EditOptions
+	Block string
+	// Marker is the line before and after the block, with {mark} replaced by
+	// BEGIN and END. It defaults to DefaultBlockMarker.
+	Marker string
+}
+
+// Edit returns contents with the block made present or absent.
+func (o *BlockInFileOptions) Edit(r *BaseCommand, variables map[string]any, contents []byte) ([]byte, error) {
+	absent, err := o.absent(r, variables)
+	if err != nil {
+		return nil, err
+	}
+	block, err := r.EvalString("block", o.Block, variables)
+	if err != nil {
+		return nil, err
+	}
+	marker, err := r.EvalString("marker", o.Marker, variables)
+	if err != nil {
+		return nil, err
+	}
+	marker = stringDef(marker, DefaultBlockMarker)
+	if !strings.Contains(marker, "{mark}") {
+		return nil, errors.Errorf("marker in %q must contain {mark}", r.StepName)
+	}
+	begin := strings.ReplaceAll(marker, "{mark}", "BEGIN")
+	end := strings.ReplaceAll(marker, "{mark}", "END")
+
+	blockLines, _ := splitLines(block)
+	blockLines = append(append([]string{begin}, blockLines...), end)
+
+	lines, terminated := splitLines(string(contents))
+	start := slices.Index(lines, begin)
+	stop := -1
+	if start >= 0 {
+		if i := slices.Index(lines[start:], end); i >= 0 {
+			stop = start + i
+		}
+	}
+
+	switch {
+	case stop >= 0 && absent:
+		return joinLines(slices.Delete(lines, start, stop+1), terminated), nil
+	case stop >= 0 && slices.Equal(lines[start:stop+1], blockLines):
+		return contents, nil
+	case stop >= 0:
+		return joinLines(slices.Replace(lines, start, stop+1, blockLines...), true), nil
+	case absent:
+		return contents, nil
+	}
+
+	at, err := o.insertAt(r, variables, lines)
+	if err != nil {
+		return nil, err
+	}
+	return joinLines(slices.Insert(lines, at, blockLines...), true), nil
+}
+
+func NewLineInFileCommand(ectx *ExecutorContext) Command {
+	return &LineInFileCommand{
+		BaseCommand: BaseCommand{
+			Ectx: ectx,
+		},
+	}
+}
+
+// LineInFileCommand edits a file on the executor's Fs with LineInFileOptions.
+type LineInFileCommand struct {
+	BaseCommand
+	WriteOptions
+	LineInFileOptions
+}
+
+func (r *LineInFileCommand) Execute(variables map[string]any) error {
+	return editFile(&r.BaseCommand, &r.WriteOptions, &r.EditOptions, &r.LineInFileOptions, variables)
+}
+
+func NewBlockInFileCommand(ectx *ExecutorContext) Command {
+	return &BlockInFileCommand{
+		BaseCommand: BaseCommand{
+			Ectx: ectx,
+		},
+	}
+}
+
+// BlockInFileCommand edits a file on the executor's Fs with
+// BlockInFileOptions.
+type BlockInFileCommand struct {
+	BaseCommand
+	WriteOptions
+	BlockInFileOptions
+}
+
+func (r *BlockInFileCommand) Execute(variables map[string]any) error {
+	return editFile(&r.BaseCommand, &r.WriteOptions, &r.EditOptions, &r.BlockInFileOptions, variables)
+}
+
+// editFile applies the edit to the file on the executor's Fs and writes the
+// result like writefile does.
+func editFile(r *BaseCommand, w *WriteOptions, o *EditOptions, editor FileEditor, variables map[string]any) error {
+	w.changed = false
+	if len(o.File) == 0 {
+		return errors.Errorf("filename in %q is empty", r.StepName)
+	}
+	fileName, err := r.EvalString("file", o.File, variables)
+	if err != nil {
+		return err
+	}
+	mode, err := o.Mode()
+	if err != nil {
+		return err
+	}
+
+	fs := r.Ectx.Fs
+	info, err := fs.Stat(fileName)
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var contents []byte
+	if exists {
+		if contents, err = afero.ReadFile(fs, fileName); err != nil {
+			return err
+		}
+		if mode == 0 {
+			mode = info.Mode() & permissionBits
+		}
+	} else if mode == 0 {
+		mode = 0o644
+	}
+
+	edited, ok, err := o.Apply(r, variables, editor, fileName, contents, exists)
+	if err != nil || !ok {
+		return err
+	}
+	return w.write(r, fileName, edited, mode, variables)
+}
@@ -0,0 +1,257 @@
+package godexer_test
+
+import (
+	"os"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/spf13/afero"
+
+	"github.com/go-extras/godexer"
+	"github.com/go-extras/godexer/internal/logger"
+)
+
+const sshdConfig = `Port 22
+#PermitRootLogin prohibit-password
+PasswordAuthentication yes
+Match User backup
+    ForceCommand internal-sftp
+`
+
+func TestLineInFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		step     string
+		contents string
+		want     string
+		changed  bool
+	}{{
+		name:     "replace the last match",
+		step:     "regexp: '^#?PermitRootLogin'\n    line: PermitRootLogin no",
+		contents: sshdConfig,
+		want:     "Port 22\nPermitRootLogin no\nPasswordAuthentication yes\nMatch User backup\n    ForceCommand internal-sftp\n",
+		changed:  true,
+	}, {
+		name:     "already present",
+		step:     "regexp: '^PasswordAuthentication'\n    line: PasswordAuthentication yes",
+		contents: sshdConfig,
+		want:     sshdConfig,
+	}, {
+		name:     "present without a match",
+		step:     "regexp: '^#?PermitRootLogin'\n    line: Port 22",
+		contents: "Port 22",
+		want:     "Port 22",
+	}, {
+		name:     "append",
+		step:     "line: UseDNS no",
+		contents: "Port 22",
+		want:     "Port 22\nUseDNS no\n",
+		changed:  true,
+	}, {
+		name:     "insert before the last match",
+		step:     "line: UseDNS no\n    insertBefore: ^Match",
+		contents: sshdConfig,
+		want:     "Port 22\n#PermitRootLogin prohibit-password\nPasswordAuthentication yes\nUseDNS no\nMatch User backup\n    ForceCommand internal-sftp\n",
+		changed:  true,
+	}, {
+		name:     "insert after the last match",
+		step:     "line: AddressFamily inet\n    insertAfter: ^Port",
+		contents: sshdConfig,
+		want:     "Port 22\nAddressFamily inet\n#PermitRootLogin prohibit-password\nPasswordAuthentication yes\nMatch User backup\n    ForceCommand internal-sftp\n",
+		changed:  true,
+	}, {
+		name:     "insert at the beginning",
+		step:     "line: '# managed'\n    insertBefore: BOF",
+		contents: "Port 22\n",
+		want:     "# managed\nPort 22\n",
+		changed:  true,
+	}, {
+		name:     "insert without a match",
+		step:     "line: UseDNS no\n    insertAfter: ^Nothing",
+		contents: "Port 22\n",
+		want:     "Port 22\nUseDNS no\n",
+		changed:  true,
+	}, {
+		name:     "remove matches",
+		step:     "regexp: Authentication\n    state: absent",
+		contents: "PasswordAuthentication yes\nPort 22\nKbdInteractiveAuthentication no",
+		want:     "Port 22",
+		changed:  true,
+	}, {
+		name:     "remove the line",
+		step:     "line: Port 22\n    state: absent",
+		contents: "Port 2222\n",
+		want:     "Port 2222\n",
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+			fs := afero.NewMemMapFs()
+			c.Assert(afero.WriteFile(fs, "/etc/ssh/sshd_config", []byte(tt.contents), 0o600), qt.IsNil)
+
+			ex, err := godexer.NewWithScenario(`commands:
+  - type: lineinfile
+    stepName: edit
+    file: /etc/ssh/sshd_config
+    `+tt.step+`
+`, godexer.WithFS(fs), godexer.WithLogger(&logger.Logger{}))
+			c.Assert(err, qt.IsNil)
+
+			vars := map[string]any{}
+			c.Assert(ex.Execute(vars), qt.IsNil)
+			c.Assert(vars["__step:edit:changed"], qt.Equals, tt.changed)
+			data, err := afero.ReadFile(fs, "/etc/ssh/sshd_config")
+			c.Assert(err, qt.IsNil)
+			c.Assert(string(data), qt.Equals, tt.want)
+			info, err := fs.Stat("/etc/ssh/sshd_config")
+			c.Assert(err, qt.IsNil)
+			c.Assert(info.Mode().Perm(), qt.Equals, os.FileMode(0o600))
+
+			c.Assert(ex.Execute(vars), qt.IsNil)
+			c.Assert(vars["__step:edit:changed"], qt.IsFalse)
+		})
+	}
+}
+
+func TestBlockInFile(t *testing.T) {
+	c := qt.New(t)
+	fs := afero.NewMemMapFs()
+	c.Assert(afero.WriteFile(fs, "/etc/hosts", []byte("127.0.0.1 localhost\n::1 localhost\n"), 0o644), qt.IsNil)
+
+	ex, err := godexer.NewWithScenario(`commands:
+  - type: blockinfile
+    stepName: hosts
+    file: /etc/hosts
+    state: '{{ .state }}'
+    insertAfter: ^127\.
+    block: |-
+      {{ range .hosts -}}
+      {{ .ip }} {{ .name }}
+      {{ end }}
+`, godexer.WithFS(fs), godexer.WithLogger(&logger.Logger{}))
+	c.Assert(err, qt.IsNil)
+
+	run := func(state string, hosts ...map[string]any) (bool, string) {
+		vars := map[string]any{"state": state, "hosts": hosts}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		data, err := afero.ReadFile(fs, "/etc/hosts")
+		c.Assert(err, qt.IsNil)
+		return vars["__step:hosts:changed"].(bool), string(data)
+	}
+	web := map[string]any{"ip": "10.0.0.2", "name": "web"}
+	db := map[string]any{"ip": "10.0.0.3", "name": "db"}
+
+	changed, contents := run("present", web)
+	c.Assert(changed, qt.IsTrue)
+	c.Assert(contents, qt.Equals, `127.0.0.1 localhost
+# BEGIN GODEXER MANAGED BLOCK
+10.0.0.2 web
+# END GODEXER MANAGED BLOCK
+::1 localhost
+`)
+
+	changed, _ = run("present", web)
+	c.Assert(changed, qt.IsFalse)
+
+	changed, contents = run("present", web, db)
+	c.Assert(changed, qt.IsTrue)
+	c.Assert(contents, qt.Equals, `127.0.0.1 localhost
+# BEGIN GODEXER MANAGED BLOCK
+10.0.0.2 web
+10.0.0.3 db
+# END GODEXER MANAGED BLOCK
+::1 localhost
+`)
+
+	changed, contents = run("absent")
+	c.Assert(changed, qt.IsTrue)
+	c.Assert(contents, qt.Equals, "127.0.0.1 localhost\n::1 localhost\n")
+
+	changed, _ = run("absent")
+	c.Assert(changed, qt.IsFalse)
+}
+
+func TestEditFile_Create(t *testing.T) {
+	c := qt.New(t)
+	fs := afero.NewMemMapFs()
+
+	scenario := func(create bool, state string) *godexer.Executor {
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: blockinfile
+    stepName: motd
+    file: /etc/motd
+    block: welcome
+    marker: '<!-- {mark} -->'
+    permissions: "0640"
+    state: `+state+`
+    create: `+map[bool]string{true: "true", false: "false"}[create]+`
+`, godexer.WithFS(fs), godexer.WithLogger(&logger.Logger{}))
+		c.Assert(err, qt.IsNil)
+		return ex
+	}
+
+	vars := map[string]any{}
+	c.Assert(scenario(false, "absent").Execute(vars), qt.IsNil)
+	c.Assert(vars["__step:motd:changed"], qt.IsFalse)
+
+	err := scenario(false, "present").Execute(vars)
+	c.Assert(err, qt.ErrorMatches, `.*file /etc/motd in "motd" does not exist, set create: true to create it`)
+
+	c.Assert(scenario(true, "present").Execute(vars), qt.IsNil)
+	c.Assert(vars["__step:motd:changed"], qt.IsTrue)
+	data, err := afero.ReadFile(fs, "/etc/motd")
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, "<!-- BEGIN -->\nwelcome\n<!-- END -->\n")
+	info, err := fs.Stat("/etc/motd")
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.Mode().Perm(), qt.Equals, os.FileMode(0o640))
+}
+
+func TestEditFile_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		step string
+		err  string
+	}{{
+		name: "state",
+		step: "type: lineinfile\n    line: x\n    state: gone",
+		err:  `unsupported state "gone", must be one of present, absent`,
+	}, {
+		name: "regexp",
+		step: "type: lineinfile\n    line: x\n    regexp: '('",
+		err:  `invalid regexp in "edit": .*`,
+	}, {
+		name: "empty line",
+		step: "type: lineinfile\n    state: absent",
+		err:  `line in "edit" is empty`,
+	}, {
+		name: "both inserts",
+		step: "type: lineinfile\n    line: x\n    insertAfter: a\n    insertBefore: b",
+		err:  `insertAfter and insertBefore in "edit" are mutually exclusive`,
+	}, {
+		name: "marker",
+		step: "type: blockinfile\n    block: x\n    marker: '# managed'",
+		err:  `marker in "edit" must contain {mark}`,
+	}, {
+		name: "permissions",
+		step: "type: blockinfile\n    block: x\n    permissions: rw",
+		err:  `invalid permissions "rw", must be an octal mode such as 0644`,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+			fs := afero.NewMemMapFs()
+			c.Assert(afero.WriteFile(fs, "/etc/app.conf", []byte("a\n"), 0o644), qt.IsNil)
+
+			ex, err := godexer.NewWithScenario(`commands:
+  - stepName: edit
+    file: /etc/app.conf
+    `+tt.step+`
+`, godexer.WithFS(fs), godexer.WithLogger(&logger.Logger{}))
+			c.Assert(err, qt.IsNil)
+			c.Assert(ex.Execute(map[string]any{}), qt.ErrorMatches, `(?s).*`+tt.err)
+		})
+	}
+}
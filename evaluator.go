@@ -1,6 +1,9 @@
 package godexer
 
 import (
+	"slices"
+	"strings"
+
 	"github.com/expr-lang/expr"
 	"gopkg.in/Knetic/govaluate.v2"
 )
@@ -45,11 +48,68 @@ func (r evaluatorFunctionRegistry) registerLegacyAll(funcs map[string]govaluate.
 func (r evaluatorFunctionRegistry) govaluateFunctions() map[string]govaluate.ExpressionFunction {
 	result := make(map[string]govaluate.ExpressionFunction, len(r))
 	for name, fn := range r {
-		result[name] = fn
+		result[name] = func(args ...any) (any, error) {
+			for i, arg := range args {
+				if list, ok := arg.(listArg); ok {
+					args[i] = []any(list)
+				}
+			}
+			return fn(args...)
+		}
+	}
+	return result
+}
+
+// listArgPrefix marks the variables read as listArg.
+const listArgPrefix = "\x00list:"
+
+// listArg is a list variable passed as a function argument. govaluate splices
+// a []any first argument into the arguments and spreads a []any only
+// argument, so list variables are hidden in listArg on their way to a
+// function and unwrapped by it, which then gets them as is, like with expr.
+type listArg []any
+
+// markListArgs marks the variables that make up a whole function argument, so
+// that govaluateParameters reads them as listArg.
+func markListArgs(tokens []govaluate.ExpressionToken) []govaluate.ExpressionToken {
+	result := slices.Clone(tokens)
+	// whether each open clause holds function arguments
+	var calls []bool
+	for i, token := range result {
+		switch token.Kind {
+		case govaluate.CLAUSE:
+			calls = append(calls, i > 0 && result[i-1].Kind == govaluate.FUNCTION)
+		case govaluate.CLAUSE_CLOSE:
+			if len(calls) > 0 {
+				calls = calls[:len(calls)-1]
+			}
+		case govaluate.VARIABLE:
+			if len(calls) == 0 || !calls[len(calls)-1] || i+1 == len(result) {
+				continue
+			}
+			prev, next := result[i-1].Kind, result[i+1].Kind
+			if (prev == govaluate.CLAUSE || prev == govaluate.SEPARATOR) &&
+				(next == govaluate.SEPARATOR || next == govaluate.CLAUSE_CLOSE) {
+				result[i].Value = listArgPrefix + token.Value.(string)
+			}
+		}
 	}
 	return result
 }
 
+// govaluateParameters are the variables of a govaluate expression compiled
+// with markListArgs.
+type govaluateParameters map[string]any
+
+func (p govaluateParameters) Get(name string) (any, error) {
+	name, marked := strings.CutPrefix(name, listArgPrefix)
+	value, err := govaluate.MapParameters(p).Get(name)
+	if list, ok := value.([]any); ok && marked && err == nil {
+		return listArg(list), nil
+	}
+	return value, err
+}
+
 func (r evaluatorFunctionRegistry) exprOptions() []expr.Option {
 	result := make([]expr.Option, 0, len(r))
 	for name, fn := range r {
@@ -58,6 +118,64 @@ func (r evaluatorFunctionRegistry) exprOptions() []expr.Option {
 	return result
 }
 
+// withEvaluatorFunctionRegistry replaces the evaluator functions with the given
+// ones, keeping the function set version, so that child executors can reuse
+// expressions compiled by their parent.
+func withEvaluatorFunctionRegistry(funcs map[string]EvaluatorFunction, version uint64) func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.evaluatorFunctions = newEvaluatorFunctionRegistry()
+		ex.evaluatorFunctions.registerAll(funcs)
+		ex.govaluateEvaluatorFunctions = ex.evaluatorFunctions.govaluateFunctions()
+		ex.funcSetVersion = version
+	}
+}
+
+// exprBuiltins is the curated set of expr builtins available to `requires`
+// expressions and typed template values. Functions that depend on the clock
+// or can allocate unbounded memory (e.g. `repeat`) are left out.
+var exprBuiltins = []string{
+	// predicates and collections
+	"all", "none", "any", "one", "filter", "map", "find", "findIndex", "findLast", "findLastIndex",
+	"count", "sum", "groupBy", "sortBy", "reduce", "len", "first", "last", "get", "take",
+	"keys", "values", "toPairs", "fromPairs", "reverse", "uniq", "concat", "flatten", "sort",
+	// numbers
+	"abs", "ceil", "floor", "round", "int", "float", "max", "min", "mean", "median",
+	// strings
+	"string", "type", "trim", "trimPrefix", "trimSuffix", "upper", "lower", "split", "splitAfter",
+	"replace", "join", "indexOf", "lastIndexOf", "hasPrefix", "hasSuffix",
+	// encoding
+	"toJSON", "fromJSON", "toBase64", "fromBase64",
+}
+
+// compileExpr compiles an expr-language expression with the options shared by
+// `requires` expressions and typed template values.
+func compileExpr(src string, extra []expr.Option) compiledExpression {
+	options := make([]expr.Option, 0, len(exprBuiltins)+len(extra)+3)
+	options = append(options,
+		expr.Env(make(map[string]any)),
+		expr.AllowUndefinedVariables(),
+		expr.DisableAllBuiltins(),
+	)
+	for _, name := range exprBuiltins {
+		options = append(options, expr.EnableBuiltin(name))
+	}
+	options = append(options, extra...)
+
+	program, err := expr.Compile(src, options...)
+	return compiledExpression{program: program, err: err}
+}
+
+func runExpr(compiled compiledExpression, variables map[string]any) (any, error) {
+	if compiled.err != nil {
+		return nil, compiled.err
+	}
+	if variables == nil {
+		variables = make(map[string]any)
+	}
+
+	return expr.Run(compiled.program, variables)
+}
+
 // WithRegisteredEvaluatorFunction registers an evaluator function option without exposing third-party types.
 func WithRegisteredEvaluatorFunction(name string, fn EvaluatorFunction) func(ex *Executor) {
 	return func(ex *Executor) {
@@ -4,7 +4,10 @@ import (
 	"testing"
 
 	qt "github.com/frankban/quicktest"
+	"github.com/spf13/afero"
 	"gopkg.in/Knetic/govaluate.v2"
+
+	"github.com/go-extras/godexer/internal/logger"
 )
 
 func TestExecutorGovaluateEvaluatorFunctionCacheUpdatesOnRegister(t *testing.T) {
@@ -24,3 +27,154 @@ func TestExecutorGovaluateEvaluatorFunctionCacheUpdatesOnRegister(t *testing.T)
 	c.Assert(result, qt.Equals, true)
 	c.Assert(ex.govaluateEvaluatorFunctions, qt.HasLen, 2)
 }
+
+func TestExecutorGovaluateListArguments(t *testing.T) {
+	ex := New()
+	var got [][]any
+	ex.RegisterEvaluatorFunction("args", func(args ...any) (any, error) {
+		got = append(got, args)
+		return true, nil
+	})
+	vars := map[string]any{
+		"empty":  []any{},
+		"single": []any{"db"},
+		"roles":  []any{"web", "db"},
+		"n":      2,
+	}
+
+	tests := []struct {
+		expression string
+		want       []any
+	}{
+		{`args(single)`, []any{[]any{"db"}}},
+		{`args(empty, "db")`, []any{[]any{}, "db"}},
+		{`args(single, "d")`, []any{[]any{"db"}, "d"}},
+		{`args(roles, single)`, []any{[]any{"web", "db"}, []any{"db"}}},
+		{`"db" IN roles && args(n)`, []any{2.0}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.expression, func(t *testing.T) {
+			c := qt.New(t)
+			got = nil
+			result, err := ex.evaluateRequiresGovaluate(tc.expression, vars)
+			c.Assert(err, qt.IsNil)
+			c.Assert(result, qt.Equals, true)
+			c.Assert(got, qt.DeepEquals, [][]any{tc.want})
+		})
+	}
+}
+
+func TestExecutorCompileCache(t *testing.T) {
+	t.Run("expressions_are_compiled_once", func(t *testing.T) {
+		c := qt.New(t)
+		ex := New()
+
+		for i := 0; i < 3; i++ {
+			result, err := ex.evaluateRequires("a > 1", map[string]any{"a": 2})
+			c.Assert(err, qt.IsNil)
+			c.Assert(result, qt.Equals, true)
+		}
+		c.Assert(ex.cache.expressions.items, qt.HasLen, 1)
+
+		child := ex.WithCommands(nil)
+		c.Assert(child.cache, qt.Equals, ex.cache)
+		c.Assert(child.funcSetVersion, qt.Equals, ex.funcSetVersion)
+		_, err := child.evaluateRequires("a > 1", map[string]any{"a": 2})
+		c.Assert(err, qt.IsNil)
+		c.Assert(ex.cache.expressions.items, qt.HasLen, 1)
+	})
+
+	t.Run("function_changes_invalidate", func(t *testing.T) {
+		c := qt.New(t)
+		ex := New()
+		ex.RegisterEvaluatorFunction("check", func(...any) (any, error) { return false, nil })
+
+		result, err := ex.evaluateRequires("check()", make(map[string]any))
+		c.Assert(err, qt.IsNil)
+		c.Assert(result, qt.Equals, false)
+
+		ex.RegisterEvaluatorFunction("check", func(...any) (any, error) { return true, nil })
+		result, err = ex.evaluateRequires("check()", make(map[string]any))
+		c.Assert(err, qt.IsNil)
+		c.Assert(result, qt.Equals, true)
+	})
+
+	t.Run("templates_are_parsed_once", func(t *testing.T) {
+		c := qt.New(t)
+		ex := New()
+
+		for i := 0; i < 3; i++ {
+			result, err := ex.EvalValue("value", "{{ .a }}", map[string]any{"a": i})
+			c.Assert(err, qt.IsNil)
+			c.Assert(result, qt.Equals, string(rune('0'+i)))
+		}
+		c.Assert(ex.cache.templates.items, qt.HasLen, 1)
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		c := qt.New(t)
+		cache := newBoundedCache[int, int](2)
+		cache.put(1, 1)
+		cache.put(2, 2)
+		cache.put(3, 3)
+		c.Assert(cache.items, qt.HasLen, 1)
+		v, ok := cache.get(3)
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(v, qt.Equals, 3)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		c := qt.New(t)
+		ex := New(withCompileCache(newCompileCacheWithLimit(0)))
+
+		for i := 0; i < 3; i++ {
+			result, err := ex.EvalValue("value", "{{ .a }}", map[string]any{"a": i})
+			c.Assert(err, qt.IsNil)
+			c.Assert(result, qt.Equals, string(rune('0'+i)))
+		}
+		c.Assert(ex.cache.templates.items, qt.HasLen, 0)
+	})
+}
+
+func BenchmarkForeach(b *testing.B) {
+	items := make([]any, 5000)
+	for i := range items {
+		items[i] = i
+	}
+
+	caches := []struct {
+		name  string
+		limit int
+	}{
+		{name: "cached", limit: compileCacheLimit},
+		{name: "uncached", limit: 0},
+	}
+	for _, cc := range caches {
+		b.Run(cc.name, func(b *testing.B) {
+			exc, err := NewWithScenario(`commands:
+  - type: foreach
+    stepName: loop
+    variable: items
+    commands:
+      - type: variable
+        stepName: set
+        requires: 'value >= 0 && value < 100000'
+        variable: item
+        value: 'item-{{ .value }}-{{ .parent.prefix | upper }}'
+`,
+				WithFS(afero.NewMemMapFs()),
+				WithLogger(&logger.Logger{}),
+				withCompileCache(newCompileCacheWithLimit(cc.limit)),
+			)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			for b.Loop() {
+				if err := exc.Execute(map[string]any{"items": items, "prefix": "x"}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
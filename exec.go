@@ -11,6 +11,9 @@ import (
 	"github.com/go-extras/errors"
 )
 
+// ExecCommandFn creates the commands started by OSProcessRunner.
+//
+// Deprecated: set a ProcessRunner with WithProcessRunner instead.
 var ExecCommandFn = exec.Command
 
 //nolint:gochecknoinits // init is used for automatic command registration
@@ -33,10 +36,22 @@ func escapeArgs(args []string) (result string) {
 
 type ExecCommand struct {
 	BaseCommand
+	StdinSource
+	ScriptSource
+	ExecEnvironment
+	OutputCapture
+	ExitCriteria
+	BecomeOptions
 	Cmd       []string
 	Variable  string
 	AllowFail bool
-	Env       []string
+	// Env is merged into ExecEnvironment.Env, which scenarios set with the env
+	// field.
+	//
+	// Deprecated: set ExecEnvironment.Env instead.
+	Env []string `json:"-"`
+	// Output overrides the executor's output options for this step.
+	Output *OutputOptions
 
 	// the following parameters will allow retrying the command
 	Attempts int // if 0 or 1, no retry
@@ -52,58 +67,73 @@ func NewExecCommand(ectx *ExecutorContext) Command {
 }
 
 func (r *ExecCommand) Execute(variables map[string]any) error {
-	if len(r.Cmd) == 0 {
-		return errors.Errorf("command %q is empty", r.StepName)
+	stdin, err := r.StdinReader(&r.BaseCommand, variables)
+	if err != nil {
+		return err
 	}
 
-	var cmds []string
-	for _, v := range r.Cmd {
-		cmds = append(cmds, MaybeEvalValue(v, variables).(string))
+	inv := Invocation{Type: InvocationExec}
+	var script *Script
+	if r.HasScript() {
+		if len(r.Cmd) > 0 {
+			return errors.Errorf("cmd and script are mutually exclusive in %q", r.StepName)
+		}
+		script, err = r.RenderScript(&r.BaseCommand, variables)
+		if err != nil {
+			return err
+		}
+		inv.Argv, inv.Script = script.Cmd(""), script.Body
+	} else {
+		inv.Argv, err = r.prepareCommand(variables)
+		if err != nil {
+			return err
+		}
 	}
 
-	if len(cmds) == 0 {
-		// should never actually happen
-		return errors.Errorf("command %q is empty", r.StepName)
+	env := r.ExecEnvironment
+	env.Env = append(append(ExecEnv(nil), r.Env...), env.Env...)
+	penv, err := env.ProcessEnvironment(&r.BaseCommand, variables)
+	if err != nil {
+		return err
 	}
+	inv.Env, inv.ClearEnv, inv.Dir = penv.Env, penv.Clear, penv.Dir
 
-	r.Ectx.Logger.Info(strings.TrimSpace(fmt.Sprintf("Executing: %s %s", cmds[0], escapeArgs(cmds[1:]))))
-
-	cmd := ExecCommandFn(cmds[0], cmds[1:]...)
-
-	var buf Buffer
-	if r.Variable == "" {
-		cmd.Stdout = r.Ectx.Stdout
-		cmd.Stderr = r.Ectx.Stderr
-	} else {
-		cmd.Stdout = NewCombinedWriter([]io.Writer{
-			r.Ectx.Stdout,
-			&buf,
-		})
-		cmd.Stderr = NewCombinedWriter([]io.Writer{
-			r.Ectx.Stderr,
-			&buf,
-		})
+	escalation, err := r.Escalation(&r.BaseCommand, variables)
+	if err != nil {
+		return err
 	}
 
-	cmd.Env = append(cmd.Env, r.Env...)
-
-	err := cmd.Start()
+	output, err := r.Ectx.OpenOutput(OutputInfo{StepName: r.RunStepName(), Options: r.Output})
 	if err != nil {
 		return err
 	}
-	err = cmd.Wait()
+	capture, err := r.StartCapture(r.Variable, r.NeedsOutput(), output.Stdout(), output.Stderr())
+	if err != nil {
+		return err
+	}
+
+	err = r.Ectx.RunInvocation(inv, stdin, capture.Stdout, capture.Stderr, func(stdin io.Reader, stdout, stderr io.Writer) error {
+		return r.run(inv.Argv, script, penv, escalation, stdin, stdout, stderr)
+	})
+
+	code, exited := exitCode(err)
+	if err == nil || exited {
+		err = r.CheckExit(&r.BaseCommand, code, err, capture, variables)
+	}
 
-	if r.Variable != "" {
-		variables[r.Variable] = buf.String()
+	if storeErr := capture.Store(variables); err == nil {
+		err = storeErr
 	}
 
 	if r.AllowFail {
-		if exitError, ok := err.(*exec.ExitError); ok {
+		if _, ok := exitCode(err); ok || errors.Is(err, ErrFailedWhen) {
 			err = nil
-			variables[r.StepName+"_exit_status"] = exitError.ExitCode()
-		} else {
-			variables[r.StepName+"_exit_status"] = 0
 		}
+		variables[r.StepName+"_exit_status"] = code
+	}
+
+	if closeErr := output.Close(err != nil); err == nil {
+		err = closeErr
 	}
 
 	if err != nil {
@@ -111,13 +141,103 @@ func (r *ExecCommand) Execute(variables map[string]any) error {
 	}
 
 	if r.Attempts > 1 {
-		if _, ok := err.(*exec.ExitError); ok {
+		if _, ok := exitCode(err); ok || errors.Is(err, ErrFailedWhen) {
 			r.Attempts--
 			r.Ectx.Logger.Infof("Got execution failure, will retry (attempts left %d)", r.Attempts)
-			TimeSleep(time.Duration(r.Delay) * time.Second)
+			r.Ectx.Sleep(time.Duration(r.Delay) * time.Second)
 			err = r.Execute(variables)
 		}
 	}
 
 	return err
 }
+
+// run starts the command, or the script if it is set, and waits for it.
+func (r *ExecCommand) run(cmds []string, script *Script, penv *ProcessEnv, escalation *Escalation, stdin io.Reader, stdout, stderr io.Writer) error {
+	if script != nil {
+		var cleanup func()
+		var err error
+		cmds, stdin, cleanup, err = r.prepareScript(script, escalation != nil, stdin)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+
+	env, clearEnv := penv.Env, penv.Clear
+	if escalation != nil {
+		// sudo and friends reset the environment, so pass it on with `env`
+		if clearEnv || len(env) > 0 {
+			envArgs := []string{"env"}
+			if clearEnv {
+				envArgs = append(envArgs, "-i")
+			}
+			cmds = append(append(envArgs, env...), cmds...)
+			env, clearEnv = nil, false
+		}
+		var err error
+		cmds, err = escalation.Wrap(cmds, true)
+		if err != nil {
+			return err
+		}
+		stdin = escalation.PasswordStdin(stdin)
+	}
+
+	r.Ectx.Logger.Info(strings.TrimSpace(fmt.Sprintf("Executing: %s %s", cmds[0], escapeArgs(cmds[1:]))))
+
+	process, err := r.Ectx.StartProcess(&ProcessSpec{
+		Path:       cmds[0],
+		Args:       cmds[1:],
+		Env:        env,
+		InheritEnv: !clearEnv,
+		Dir:        penv.Dir,
+		Stdin:      stdin,
+		Stdout:     stdout,
+		Stderr:     stderr,
+	})
+	if err != nil {
+		return err
+	}
+	return process.Wait()
+}
+
+func (r *ExecCommand) prepareCommand(variables map[string]any) ([]string, error) {
+	if len(r.Cmd) == 0 {
+		return nil, errors.Errorf("command %q is empty", r.StepName)
+	}
+
+	var cmds []string
+	for i, v := range r.Cmd {
+		arg, err := r.EvalString(fmt.Sprintf("cmd[%d]", i), v, variables)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, arg)
+	}
+
+	return cmds, nil
+}
+
+// prepareScript returns the command line and stdin running the script. The
+// script is piped to the interpreter when possible and written to a temp file
+// on the executor's Fs otherwise; cleanup removes that file. Escalated
+// scripts are staged by the become user instead.
+func (r *ExecCommand) prepareScript(script *Script, escalated bool, stdin io.Reader) ([]string, io.Reader, func(), error) {
+	if script.Piped(stdin != nil) {
+		return script.Cmd(""), strings.NewReader(script.Body), func() {}, nil
+	}
+	if escalated {
+		return script.Staged(), script.StagedStdin(stdin), func() {}, nil
+	}
+
+	path, err := script.WriteTemp(r.Ectx.Fs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cleanup := func() {
+		if err := r.Ectx.Fs.Remove(path); err != nil {
+			r.Ectx.Logger.Errorf("Failed to remove script file %s: %v", path, err)
+		}
+	}
+	return script.Cmd(path), stdin, cleanup, nil
+}
@@ -0,0 +1,99 @@
+package godexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-extras/errors"
+)
+
+// ExecEnv is a list of KEY=value pairs. In scenarios it may be written either
+// as a list (`["A=1", "B=2"]`) or as a map (`{A: 1, B: 2}`); map entries are
+// sorted by key.
+type ExecEnv []string
+
+func (e *ExecEnv) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*e = list
+		return nil
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return errors.New("env must be a list of KEY=value strings or a map")
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := make(ExecEnv, 0, len(m))
+	for _, k := range keys {
+		var value string
+		switch v := m[k].(type) {
+		case string:
+			value = v
+		case nil:
+		default:
+			value = fmt.Sprint(v)
+		}
+		result = append(result, k+"="+value)
+	}
+	*e = result
+	return nil
+}
+
+// ExecEnvironment holds the process environment fields shared by the exec commands.
+type ExecEnvironment struct {
+	// Env entries are template-rendered and added on top of the base environment.
+	Env ExecEnv
+	// Dir is the (templated) working directory.
+	Dir string
+	// InheritEnv starts from the parent environment (the default). For ssh_exec
+	// the parent is the remote login environment.
+	InheritEnv *bool
+	// ClearEnv starts from an empty environment; it cannot be combined with inheritEnv: true.
+	ClearEnv bool
+}
+
+// ProcessEnv is a rendered ExecEnvironment.
+type ProcessEnv struct {
+	Env   []string
+	Dir   string
+	Clear bool
+}
+
+// ProcessEnvironment renders the environment fields.
+func (e *ExecEnvironment) ProcessEnvironment(r *BaseCommand, variables map[string]any) (*ProcessEnv, error) {
+	if e.ClearEnv && e.InheritEnv != nil && *e.InheritEnv {
+		return nil, errors.New("inheritEnv and clearEnv are mutually exclusive")
+	}
+
+	result := &ProcessEnv{
+		Clear: e.ClearEnv || (e.InheritEnv != nil && !*e.InheritEnv),
+	}
+
+	dir, err := r.EvalString("dir", e.Dir, variables)
+	if err != nil {
+		return nil, err
+	}
+	result.Dir = dir
+
+	for _, kv := range e.Env {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return nil, errors.Errorf("env entry %q must be in KEY=value form", kv)
+		}
+		rendered, err := r.EvalString("env."+key, value, variables)
+		if err != nil {
+			return nil, err
+		}
+		result.Env = append(result.Env, key+"="+rendered)
+	}
+
+	return result, nil
+}
@@ -2,8 +2,14 @@ package godexer_test
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
@@ -150,3 +156,567 @@ func TestExec(t *testing.T) {
 		c.Assert(string(d), qt.Equals, "val1val2")
 	})
 }
+
+func fakeStdinCommand(command string, args ...string) *exec.Cmd {
+	cs := []string{"-test.run=TestExecStdinHelper", "--", command}
+	cs = append(cs, args...)
+	//nolint:gosec // This is a test helper that intentionally uses os.Args[0]
+	cmd := exec.Command(os.Args[0], cs...)
+	cmd.Env = []string{"GO_WANT_STDIN_HELPER_PROCESS=1", "GOCOVERDIR=" + os.TempDir()}
+	return cmd
+}
+
+// TestExecStdinHelper echoes stdin back to stdout.
+func TestExecStdinHelper(t *testing.T) {
+	if os.Getenv("GO_WANT_STDIN_HELPER_PROCESS") != "1" {
+		return
+	}
+	_, _ = io.Copy(os.Stdout, os.Stdin)
+	//nolint:revive // This is a test helper that simulates process exit
+	os.Exit(0)
+}
+
+func TestExec_Stdin(t *testing.T) {
+	newCommand := func(fs afero.Fs, stdout *bytes.Buffer) *godexer.ExecCommand {
+		cmd := godexer.NewExecCommand(&godexer.ExecutorContext{
+			Fs:     fs,
+			Stdout: stdout,
+			Stderr: io.Discard,
+			Logger: &logger.Logger{},
+		})
+		ex := cmd.(*godexer.ExecCommand)
+		ex.Cmd = []string{"cat"}
+		return ex
+	}
+
+	godexer.ExecCommandFn = fakeStdinCommand
+	defer func() { godexer.ExecCommandFn = exec.Command }()
+
+	t.Run("Stdin", func(t *testing.T) {
+		c := qt.New(t)
+		var stdout bytes.Buffer
+		ex := newCommand(afero.NewMemMapFs(), &stdout)
+		ex.Stdin = "SELECT {{ .id }};\n"
+
+		c.Assert(ex.Execute(map[string]any{"id": 42}), qt.IsNil)
+		c.Assert(stdout.String(), qt.Equals, "SELECT 42;\n")
+	})
+
+	t.Run("StdinFromVariable", func(t *testing.T) {
+		c := qt.New(t)
+		var stdout bytes.Buffer
+		ex := newCommand(afero.NewMemMapFs(), &stdout)
+		ex.StdinFromVariable = "manifest"
+		ex.Variable = "out"
+
+		vars := map[string]any{"manifest": []byte("kind: ConfigMap\n")}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["out"], qt.Equals, "kind: ConfigMap\n")
+	})
+
+	t.Run("StdinFromFile", func(t *testing.T) {
+		c := qt.New(t)
+		fs := afero.NewMemMapFs()
+		c.Assert(afero.WriteFile(fs, "/tmp/answers", []byte("yes\n"), 0o600), qt.IsNil)
+		var stdout bytes.Buffer
+		ex := newCommand(fs, &stdout)
+		ex.StdinFromFile = "/tmp/{{ .name }}"
+
+		c.Assert(ex.Execute(map[string]any{"name": "answers"}), qt.IsNil)
+		c.Assert(stdout.String(), qt.Equals, "yes\n")
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		testcases := []struct {
+			name     string
+			stdin    godexer.StdinSource
+			errMatch string
+		}{
+			{
+				name:     "multiple",
+				stdin:    godexer.StdinSource{Stdin: "a", StdinFromFile: "/b"},
+				errMatch: "only one of stdin, stdinFromVariable and stdinFromFile may be set",
+			},
+			{
+				name:     "missing_variable",
+				stdin:    godexer.StdinSource{StdinFromVariable: "nope"},
+				errMatch: `stdin variable "nope" is not set`,
+			},
+			{
+				name:     "invalid_variable",
+				stdin:    godexer.StdinSource{StdinFromVariable: "num"},
+				errMatch: `stdin variable "num" must be a string, got int`,
+			},
+			{
+				name:     "missing_file",
+				stdin:    godexer.StdinSource{StdinFromFile: "/missing"},
+				errMatch: "can't read stdin file: .*",
+			},
+		}
+
+		for _, tc := range testcases {
+			t.Run(tc.name, func(t *testing.T) {
+				c := qt.New(t)
+				ex := newCommand(afero.NewMemMapFs(), &bytes.Buffer{})
+				ex.StdinSource = tc.stdin
+				c.Assert(ex.Execute(map[string]any{"num": 1}), qt.ErrorMatches, tc.errMatch)
+			})
+		}
+	})
+}
+
+// fakeEnvCommand runs TestExecEnvHelper without presetting the environment, so
+// the environment handling of ExecCommand is observable.
+func fakeEnvCommand(command string, args ...string) *exec.Cmd {
+	cs := []string{"-test.run=TestExecEnvHelper", "--", "envhelper", command}
+	cs = append(cs, args...)
+	//nolint:gosec // This is a test helper that intentionally uses os.Args[0]
+	return exec.Command(os.Args[0], cs...)
+}
+
+// TestExecEnvHelper prints the working directory and the requested variables.
+func TestExecEnvHelper(t *testing.T) {
+	if !slices.Contains(os.Args, "envhelper") {
+		return
+	}
+	args := os.Args[slices.Index(os.Args, "envhelper")+2:]
+	wd, _ := os.Getwd()
+	_, _ = fmt.Fprintf(os.Stdout, "dir=%s\n", wd)
+	for _, name := range args {
+		value, ok := os.LookupEnv(name)
+		_, _ = fmt.Fprintf(os.Stdout, "%s=%s,%v\n", name, value, ok)
+	}
+	//nolint:revive // This is a test helper that simulates process exit
+	os.Exit(0)
+}
+
+func TestExec_Environment(t *testing.T) {
+	t.Setenv("GODEXER_PARENT", "parent")
+	dir := t.TempDir()
+
+	godexer.ExecCommandFn = fakeEnvCommand
+	defer func() { godexer.ExecCommandFn = exec.Command }()
+
+	run := func(c *qt.C, env string) string {
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: exec
+    stepName: run
+    variable: out
+    cmd: ["show", "GODEXER_PARENT", "GREETING", "HOME_DIR"]
+`+env, godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(io.Discard), godexer.WithStderr(io.Discard))
+		c.Assert(err, qt.IsNil)
+		vars := map[string]any{"name": "John", "dir": dir}
+		err = ex.Execute(vars)
+		c.Assert(err, qt.IsNil)
+		return vars["out"].(string)
+	}
+
+	t.Run("inherit_by_default", func(t *testing.T) {
+		c := qt.New(t)
+		wd, _ := os.Getwd()
+		c.Assert(run(c, `    env: ["GREETING=Hi {{ .name }}"]
+`), qt.Equals, "dir="+wd+"\nGODEXER_PARENT=parent,true\nGREETING=Hi John,true\nHOME_DIR=,false\n")
+	})
+
+	t.Run("map_form_and_dir", func(t *testing.T) {
+		c := qt.New(t)
+		c.Assert(run(c, `    dir: '{{ .dir }}'
+    env:
+      HOME_DIR: '{{ .dir }}'
+      GREETING: hello
+`), qt.Equals, "dir="+dir+"\nGODEXER_PARENT=parent,true\nGREETING=hello,true\nHOME_DIR="+dir+",true\n")
+	})
+
+	t.Run("clear_env", func(t *testing.T) {
+		c := qt.New(t)
+		c.Assert(run(c, `    dir: '{{ .dir }}'
+    clearEnv: true
+    env: {GREETING: hello}
+`), qt.Equals, "dir="+dir+"\nGODEXER_PARENT=,false\nGREETING=hello,true\nHOME_DIR=,false\n")
+	})
+
+	t.Run("no_inherit_without_env", func(t *testing.T) {
+		c := qt.New(t)
+		wd, _ := os.Getwd()
+		c.Assert(run(c, `    inheritEnv: false
+`), qt.Equals, "dir="+wd+"\nGODEXER_PARENT=,false\nGREETING=,false\nHOME_DIR=,false\n")
+	})
+
+	t.Run("deprecated_env", func(t *testing.T) {
+		c := qt.New(t)
+		cmds := godexer.GetRegisteredCommands()
+		cmds["exec"] = func(ectx *godexer.ExecutorContext) godexer.Command {
+			return &godexer.ExecCommand{
+				BaseCommand: godexer.BaseCommand{Ectx: ectx},
+				Env:         []string{"GREETING=hello", "HOME_DIR=/home"},
+			}
+		}
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: exec
+    stepName: run
+    variable: out
+    cmd: ["show", "GREETING", "HOME_DIR"]
+    env: ["GREETING=Hi {{ .name }}"]
+`, godexer.WithCommandTypes(cmds), godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(io.Discard), godexer.WithStderr(io.Discard))
+		c.Assert(err, qt.IsNil)
+		vars := map[string]any{"name": "John"}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["out"], qt.Matches, `(?s).*\nGREETING=Hi John,true\nHOME_DIR=/home,true\n`)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		testcases := []struct {
+			name     string
+			env      string
+			errMatch string
+		}{
+			{
+				name:     "conflicting_flags",
+				env:      "    inheritEnv: true\n    clearEnv: true\n",
+				errMatch: ".*inheritEnv and clearEnv are mutually exclusive",
+			},
+			{
+				name:     "invalid_entry",
+				env:      "    env: [GREETING]\n",
+				errMatch: `.*env entry "GREETING" must be in KEY=value form`,
+			},
+		}
+
+		for _, tc := range testcases {
+			t.Run(tc.name, func(t *testing.T) {
+				c := qt.New(t)
+				ex, err := godexer.NewWithScenario(`commands:
+  - type: exec
+    stepName: run
+    cmd: ["show"]
+`+tc.env, godexer.WithLogger(&logger.Logger{}))
+				c.Assert(err, qt.IsNil)
+				c.Assert(ex.Execute(map[string]any{}), qt.ErrorMatches, tc.errMatch)
+			})
+		}
+
+		c := qt.New(t)
+		_, err := godexer.NewWithScenario(`commands:
+  - type: exec
+    cmd: ["show"]
+    env: 1
+`)
+		c.Assert(err, qt.ErrorMatches, ".*env must be a list of KEY=value strings or a map.*")
+	})
+}
+
+// fakeOutputCommand runs TestExecOutputHelper, which prints its first argument
+// to stdout and the second one to stderr, and exits with the third one.
+func fakeOutputCommand(command string, args ...string) *exec.Cmd {
+	cs := []string{"-test.run=TestExecOutputHelper", "--", "outputhelper", command}
+	cs = append(cs, args...)
+	//nolint:gosec // This is a test helper that intentionally uses os.Args[0]
+	return exec.Command(os.Args[0], cs...)
+}
+
+func TestExecOutputHelper(t *testing.T) {
+	if !slices.Contains(os.Args, "outputhelper") {
+		return
+	}
+	args := os.Args[slices.Index(os.Args, "outputhelper")+2:]
+	_, _ = fmt.Fprint(os.Stdout, args[0])
+	if len(args) > 1 {
+		_, _ = fmt.Fprint(os.Stderr, args[1])
+	}
+	code := 0
+	if len(args) > 2 {
+		code, _ = strconv.Atoi(args[2])
+	}
+	//nolint:revive // This is a test helper that simulates process exit
+	os.Exit(code)
+}
+
+func TestExec_Capture(t *testing.T) {
+	godexer.ExecCommandFn = fakeOutputCommand
+	defer func() { godexer.ExecCommandFn = exec.Command }()
+
+	run := func(c *qt.C, fields string, stdout, stderr string) (map[string]any, error) {
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: exec
+    stepName: run
+    cmd: ["tool", '{{ .stdout }}', '{{ .stderr }}']
+`+fields, godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(io.Discard), godexer.WithStderr(io.Discard))
+		c.Assert(err, qt.IsNil)
+		vars := map[string]any{"stdout": stdout, "stderr": stderr}
+		return vars, ex.Execute(vars)
+	}
+
+	t.Run("separate_streams", func(t *testing.T) {
+		c := qt.New(t)
+		vars, err := run(c, `    variable: all
+    stdoutVariable: out
+    stderrVariable: errs
+`, " result \n", "warning\n")
+		c.Assert(err, qt.IsNil)
+		c.Assert(vars["out"], qt.Equals, " result \n")
+		c.Assert(vars["errs"], qt.Equals, "warning\n")
+		c.Assert(vars["all"], qt.Equals, " result \nwarning\n")
+	})
+
+	t.Run("trim", func(t *testing.T) {
+		c := qt.New(t)
+		vars, err := run(c, `    stdoutVariable: out
+    stderrVariable: errs
+    trim: true
+`, " result \n", "\n")
+		c.Assert(err, qt.IsNil)
+		c.Assert(vars["out"], qt.Equals, "result")
+		c.Assert(vars["errs"], qt.Equals, "")
+	})
+
+	t.Run("max_capture_bytes", func(t *testing.T) {
+		c := qt.New(t)
+		vars, err := run(c, `    stdoutVariable: out
+    maxCaptureBytes: 4
+`, "0123456789", "")
+		c.Assert(err, qt.IsNil)
+		c.Assert(vars["out"], qt.Equals, "6789")
+	})
+
+	t.Run("max_capture_bytes_utf8", func(t *testing.T) {
+		c := qt.New(t)
+		// the last 4 bytes start in the middle of "ü", which is dropped
+		vars, err := run(c, `    stdoutVariable: out
+    maxCaptureBytes: 4
+`, "grüße", "")
+		c.Assert(err, qt.IsNil)
+		c.Assert(vars["out"], qt.Equals, "ße")
+	})
+
+	parseCases := []struct {
+		name   string
+		parse  string
+		stdout string
+		want   any
+	}{
+		{name: "json", parse: "json", stdout: `{"items": [{"name": "a"}], "count": 1}`, want: map[string]any{
+			"items": []any{map[string]any{"name": "a"}},
+			"count": float64(1),
+		}},
+		{name: "yaml", parse: "yaml", stdout: "items:\n  - a\n  - b\ncount: 2\n", want: map[string]any{
+			"items": []any{"a", "b"},
+			"count": 2,
+		}},
+		{name: "lines", parse: "lines", stdout: "one\r\ntwo\n\nthree\n", want: []any{"one", "two", "three"}},
+		{name: "kv", parse: "kv", stdout: "# comment\nVERSION=\"1.2\"\nchannel: stable\nempty=\n", want: map[string]any{
+			"VERSION": "1.2",
+			"channel": "stable",
+			"empty":   "",
+		}},
+	}
+
+	for _, tc := range parseCases {
+		t.Run("parse_"+tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			vars, err := run(c, "    variable: result\n    parse: "+tc.parse+"\n", tc.stdout, "ignored")
+			c.Assert(err, qt.IsNil)
+			c.Assert(vars["result"], qt.DeepEquals, tc.want)
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		c := qt.New(t)
+		_, err := run(c, "    variable: result\n    parse: json\n", "not json", "")
+		c.Assert(err, qt.ErrorMatches, ".*failed to parse output as json.*")
+
+		_, err = run(c, "    variable: result\n    parse: kv\n", "novalue", "")
+		c.Assert(err, qt.ErrorMatches, ".*line 1: expected key=value or key: value")
+
+		_, err = run(c, "    variable: result\n    parse: xml\n", "", "")
+		c.Assert(err, qt.ErrorMatches, `.*unsupported parse format "xml".*`)
+	})
+}
+
+// fakeScriptCommand runs TestExecScriptHelper, which reports its arguments,
+// its stdin and the contents of the script file passed to it as JSON.
+func fakeScriptCommand(command string, args ...string) *exec.Cmd {
+	cs := []string{"-test.run=TestExecScriptHelper", "--", "scripthelper", command}
+	cs = append(cs, args...)
+	//nolint:gosec // This is a test helper that intentionally uses os.Args[0]
+	return exec.Command(os.Args[0], cs...)
+}
+
+func TestExecScriptHelper(t *testing.T) {
+	if !slices.Contains(os.Args, "scripthelper") {
+		return
+	}
+	result := map[string]any{"argv": os.Args[slices.Index(os.Args, "scripthelper")+1:]}
+	stdin, _ := io.ReadAll(os.Stdin)
+	result["stdin"] = string(stdin)
+	for _, arg := range result["argv"].([]string) {
+		if !strings.Contains(arg, "godexer-script-") {
+			continue
+		}
+		if data, err := os.ReadFile(arg); err == nil {
+			result["path"] = arg
+			result["file"] = string(data)
+			break
+		}
+	}
+	_ = json.NewEncoder(os.Stdout).Encode(result)
+	//nolint:revive // This is a test helper that simulates process exit
+	os.Exit(0)
+}
+
+func TestExec_Script(t *testing.T) {
+	godexer.ExecCommandFn = fakeScriptCommand
+	defer func() { godexer.ExecCommandFn = exec.Command }()
+
+	run := func(c *qt.C, fields string) (map[string]any, error) {
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: exec
+    stepName: setup
+    variable: result
+    parse: json
+`+fields, godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(io.Discard))
+		c.Assert(err, qt.IsNil)
+		vars := map[string]any{"name": "web"}
+		if err := ex.Execute(vars); err != nil {
+			return nil, err
+		}
+		return vars["result"].(map[string]any), nil
+	}
+
+	t.Run("piped", func(t *testing.T) {
+		c := qt.New(t)
+		result, err := run(c, `    script: |
+      mkdir -p /srv/{{ .name }}
+      echo done
+`)
+		c.Assert(err, qt.IsNil)
+		c.Assert(result["argv"], qt.DeepEquals, []any{"/bin/sh", "-e"})
+		c.Assert(result["stdin"], qt.Equals, "mkdir -p /srv/web\necho done\n")
+	})
+
+	t.Run("file_with_args", func(t *testing.T) {
+		c := qt.New(t)
+		result, err := run(c, `    interpreter: python3 -u
+    args: ["{{ .name }}", "2"]
+    script: |
+      import sys
+      print(sys.argv[1:])
+`)
+		c.Assert(err, qt.IsNil)
+		path, _ := result["path"].(string)
+		c.Assert(path, qt.Not(qt.Equals), "")
+		c.Assert(result["argv"], qt.DeepEquals, []any{"python3", "-u", path, "web", "2"})
+		c.Assert(result["file"], qt.Equals, "import sys\nprint(sys.argv[1:])\n")
+		c.Assert(result["stdin"], qt.Equals, "")
+
+		// the temp file is removed once the script has run
+		_, err = os.Stat(path)
+		c.Assert(os.IsNotExist(err), qt.IsTrue)
+	})
+
+	t.Run("file_with_stdin", func(t *testing.T) {
+		c := qt.New(t)
+		result, err := run(c, `    script: read line; echo "$line"
+    stdin: hello
+`)
+		c.Assert(err, qt.IsNil)
+		path, _ := result["path"].(string)
+		c.Assert(result["argv"], qt.DeepEquals, []any{"/bin/sh", "-e", path})
+		c.Assert(result["file"], qt.Equals, `read line; echo "$line"`)
+		c.Assert(result["stdin"], qt.Equals, "hello")
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		c := qt.New(t)
+		_, err := run(c, `    cmd: ["echo"]
+    script: echo
+`)
+		c.Assert(err, qt.ErrorMatches, `.*cmd and script are mutually exclusive in "setup"`)
+
+		_, err = run(c, `    script: echo
+    interpreter: ' '
+`)
+		c.Assert(err, qt.ErrorMatches, ".*interpreter is empty")
+	})
+}
+
+func TestExec_ExitCriteria(t *testing.T) {
+	godexer.ExecCommandFn = fakeOutputCommand
+	defer func() { godexer.ExecCommandFn = exec.Command }()
+
+	run := func(c *qt.C, fields, stdout, stderr string, code int) (map[string]any, error) {
+		ex, err := godexer.NewWithScenario(fmt.Sprintf(`commands:
+  - type: exec
+    stepName: check
+    cmd: ["tool", %q, %q, "%d"]
+`, stdout, stderr, code)+fields, godexer.WithLogger(&logger.Logger{}),
+			godexer.WithStdout(io.Discard), godexer.WithStderr(io.Discard))
+		c.Assert(err, qt.IsNil)
+		vars := map[string]any{"expected": "active"}
+		return vars, ex.Execute(vars)
+	}
+
+	testcases := []struct {
+		name        string
+		fields      string
+		stdout      string
+		stderr      string
+		code        int
+		errMatch    string
+		wantChanged bool
+	}{
+		{name: "default_success", wantChanged: true},
+		{name: "default_failure", code: 1, errMatch: ".*exit status 1"},
+		{name: "success_codes", fields: "    successCodes: [0, 1]\n", code: 1, wantChanged: true},
+		{name: "success_codes_failure", fields: "    successCodes: [0, 1]\n", code: 2, errMatch: ".*exit status 2"},
+		{
+			name:        "failed_when_replaces_codes",
+			fields:      "    failedWhen: exit_status > 1\n",
+			code:        1,
+			wantChanged: true,
+		},
+		{
+			name:     "failed_when_output",
+			fields:   "    failedWhen: stderr =~ 'ERROR'\n",
+			stderr:   "ERROR: disk full",
+			errMatch: ".*exit status 0: failedWhen condition is met",
+		},
+		{
+			name:        "changed_when_variables",
+			fields:      "    changedWhen: stdout != expected\n",
+			stdout:      "active",
+			wantChanged: false,
+		},
+		{
+			name:        "changed_when_exit_status",
+			fields:      "    successCodes: [0, 1]\n    changedWhen: exit_status == 0\n",
+			code:        1,
+			wantChanged: false,
+		},
+		{
+			name:     "non_bool_condition",
+			fields:   "    changedWhen: exit_status\n",
+			errMatch: ".*changedWhen must evaluate to bool, got float64",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			vars, err := run(c, tc.fields, tc.stdout, tc.stderr, tc.code)
+			if tc.errMatch != "" {
+				c.Assert(err, qt.ErrorMatches, tc.errMatch)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(vars["__step:check:changed"], qt.Equals, tc.wantChanged)
+		})
+	}
+
+	t.Run("allow_fail", func(t *testing.T) {
+		c := qt.New(t)
+		vars, err := run(c, "    allowFail: true\n    failedWhen: stdout == ''\n", "", "", 0)
+		c.Assert(err, qt.IsNil)
+		c.Assert(vars["check_exit_status"], qt.Equals, 0)
+		c.Assert(vars["__step:check:changed"], qt.Equals, false)
+	})
+}
@@ -1,15 +1,12 @@
 package godexer
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"strings"
-	"text/template"
 
-	"github.com/expr-lang/expr"
 	"github.com/go-extras/errors"
 	"github.com/spf13/afero"
 	"gopkg.in/Knetic/govaluate.v2"
@@ -25,53 +22,6 @@ type HooksAfter map[string]func(variables map[string]any) error
 
 var registeredCommands = make(map[string]func(ectx *ExecutorContext) Command)
 
-var registeredValueFuncs = make(map[string]any)
-
-func MaybeEvalValue(val any, variables map[string]any) any {
-	// we can only eval strings
-	v1, ok := val.(string)
-	if !ok {
-		return val
-	}
-
-	fnMap := template.FuncMap{
-		"shell_escape": ShellEscape,
-	}
-	for k, v := range registeredValueFuncs {
-		fnMap[k] = v
-	}
-
-	// check if the value is a valid template
-	tmpl, err := template.
-		New("tpl").
-		Funcs(fnMap).
-		Parse(v1)
-	if err != nil {
-		return val
-	}
-
-	// execute
-	var buf bytes.Buffer
-	err = tmpl.Execute(&buf, variables)
-	if err != nil {
-		return val
-	}
-
-	return buf.String()
-}
-
-// RegisterValueFunc registers template value functions.
-// Not safe for concurrent usage.
-func RegisterValueFunc(name string, fn any) {
-	registeredValueFuncs[name] = fn
-}
-
-// UnregisterValueFunc unregisters template value functions.
-// Not safe for concurrent usage.
-func UnregisterValueFunc(name string) {
-	delete(registeredValueFuncs, name)
-}
-
 func RegisterCommand(name string, cmd func(ectx *ExecutorContext) Command) {
 	registeredCommands[name] = cmd
 }
@@ -97,11 +47,23 @@ type Command interface {
 	Execute(variables map[string]any) error
 }
 
+// ChangeReporter is implemented by commands reporting whether their last run
+// changed anything. The executor stores it in `__step:<stepName>:changed`.
+type ChangeReporter interface {
+	Changed() bool
+}
+
 type DebugInfoer interface {
 	DebugInfo() *CommandDebugInfo
 	SetDebugInfo(*CommandDebugInfo)
 }
 
+// stepNameSuffixer is implemented by commands embedding BaseCommand, which
+// the executor tells the suffix of the foreach iteration running them.
+type stepNameSuffixer interface {
+	setStepNameSuffix(suffix string)
+}
+
 type BaseCommand struct {
 	Type        string
 	StepName    string
@@ -110,7 +72,8 @@ type BaseCommand struct {
 	CallsAfter  string
 	Ectx        *ExecutorContext
 
-	debugInfo *CommandDebugInfo
+	debugInfo      *CommandDebugInfo
+	stepNameSuffix string
 }
 
 func (r *BaseCommand) DebugInfo() *CommandDebugInfo {
@@ -133,15 +96,26 @@ func (r *BaseCommand) GetStepName() string {
 	return r.StepName
 }
 
+// RunStepName returns the step name with the suffix of the foreach iteration
+// running the step, as in the `__step:<stepName>:...` variables.
+func (r *BaseCommand) RunStepName() string {
+	return r.GetStepName() + r.stepNameSuffix
+}
+
+func (r *BaseCommand) setStepNameSuffix(suffix string) {
+	r.stepNameSuffix = suffix
+}
+
 func (r *BaseCommand) GetHookAfter() string {
 	return r.CallsAfter
 }
 
 func (r *BaseCommand) GetDescription(variables map[string]any) string {
-	if desc, ok := MaybeEvalValue(r.Description, variables).(string); ok {
-		return desc
+	desc, err := r.EvalString("description", r.Description, variables)
+	if err != nil {
+		return ""
 	}
-	return ""
+	return desc
 }
 
 type ExecutorContext struct {
@@ -150,6 +124,21 @@ type ExecutorContext struct {
 	Stderr   io.Writer
 	Executor *Executor
 	Logger   Logger
+	// Output opens the writers exec commands stream their output to; see
+	// OpenOutput.
+	Output OutputWriterFactory
+	// Runner starts processes; see StartProcess.
+	Runner ProcessRunner
+	// Clock is used by time-based steps; see Now and Sleep.
+	Clock Clock
+	// Chowner changes file ownership for writefile steps; see Chown.
+	Chowner Chowner
+	// Resolver looks up host addresses; see LookupHost.
+	Resolver Resolver
+	// Record and Replay are the cassettes exec commands are recorded into and
+	// replayed from; see RunInvocation.
+	Record *Cassette
+	Replay *Cassette
 }
 
 // RawScenario describes a top-level YAML/JSON scenario document.
@@ -157,6 +146,7 @@ type ExecutorContext struct {
 // Supported shape:
 //   - `commands: [...]`
 //   - optional `meta.experiments: ["expr", "-expr"]`
+//   - optional `meta.strictTemplates: true`
 type RawScenario struct {
 	Meta     *RawScenarioMeta  `json:"meta,omitempty"`
 	Commands []json.RawMessage `json:"commands"`
@@ -165,18 +155,26 @@ type RawScenario struct {
 // RawScenarioMeta contains top-level scenario metadata.
 type RawScenarioMeta struct {
 	Experiments []string `json:"experiments,omitempty"`
+	// StrictTemplates enables strict template rendering (see WithStrictTemplates).
+	StrictTemplates *bool `json:"strictTemplates,omitempty"`
 }
 
 type Executor struct {
-	ectx                         *ExecutorContext
-	commands                     []Command
-	hooksAfter                   HooksAfter
-	experiments                  map[string]bool
-	evaluatorFunctions           evaluatorFunctionRegistry
-	govaluateEvaluatorFunctions  map[string]govaluate.ExpressionFunction
-	commandTypes                 map[string]func(ectx *ExecutorContext) Command
-	beforeCommandExecuteCallback BeforeCommandExecuteCallback
-	stepNameSuffix               string
+	ectx                          *ExecutorContext
+	commands                      []Command
+	hooksAfter                    HooksAfter
+	experiments                   map[string]bool
+	evaluatorFunctions            evaluatorFunctionRegistry
+	govaluateEvaluatorFunctions   map[string]govaluate.ExpressionFunction
+	commandTypes                  map[string]func(ectx *ExecutorContext) Command
+	beforeCommandExecuteCallback  BeforeCommandExecuteCallback
+	stepNameSuffix                string
+	strictTemplates               bool
+	funcSetVersion                uint64
+	valueFuncs                    map[string]any
+	evaluatorFunctionsInTemplates bool
+	cache                         *compileCache
+	outputOptions                 *OutputOptions
 }
 
 type Option func(*Executor)
@@ -195,11 +193,17 @@ func New(opts ...Option) *Executor {
 		beforeCommandExecuteCallback: func(Command, map[string]any) {},
 		hooksAfter:                   make(HooksAfter),
 		commandTypes:                 registeredCommands,
+		funcSetVersion:               nextFuncSetVersion(),
+		valueFuncs:                   make(map[string]any),
+		cache:                        newCompileCache(),
 	}
 	ex.ectx.Executor = ex
 	for _, opt := range opts {
 		opt(ex)
 	}
+	if ex.outputOptions != nil {
+		ex.ectx.Output = NewOutputDecorator(ex.ectx.Stdout, ex.ectx.Stderr, *ex.outputOptions)
+	}
 	return ex
 }
 
@@ -248,6 +252,22 @@ func WithStderr(stderr io.Writer) func(ex *Executor) {
 	}
 }
 
+// WithOutputOptions decorates the output of exec commands written to the
+// executor's stdout and stderr. Steps may override the options with `output`.
+func WithOutputOptions(opts OutputOptions) func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.outputOptions = &opts
+	}
+}
+
+// WithOutputWriterFactory routes the output of exec commands through factory.
+func WithOutputWriterFactory(factory OutputWriterFactory) func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.ectx.Output = factory
+		ex.outputOptions = nil
+	}
+}
+
 func WithFS(fs afero.Fs) func(ex *Executor) {
 	return func(ex *Executor) {
 		ex.ectx.Fs = fs
@@ -276,6 +296,27 @@ func withExperiments(experiments map[string]bool) func(ex *Executor) {
 	}
 }
 
+// WithStrictTemplates enables strict template rendering.
+//
+// In strict mode template parse and execution errors fail the step instead of
+// silently leaving the raw string in place, and referencing a missing key
+// (including via `index`) is an error.
+func WithStrictTemplates() func(ex *Executor) {
+	return withStrictTemplates(true)
+}
+
+func withCompileCache(cache *compileCache) func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.cache = cache
+	}
+}
+
+func withStrictTemplates(strict bool) func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.strictTemplates = strict
+	}
+}
+
 // WithDefaultEvaluatorFunctions registers value evaluator functions.
 //
 // There are 3 of them available:
@@ -360,6 +401,9 @@ func (ex *Executor) AppendScenario(scenario string) error {
 // provided params map.
 func (ex *Executor) Execute(variables map[string]any) (err error) {
 	for _, cmd := range ex.commands {
+		if s, ok := cmd.(stepNameSuffixer); ok {
+			s.setStepNameSuffix(ex.stepNameSuffix)
+		}
 		ex.beforeCommandExecuteCallback(cmd, variables)
 
 		skip, err := ex.checkRequires(cmd, variables)
@@ -372,7 +416,10 @@ func (ex *Executor) Execute(variables map[string]any) (err error) {
 			continue
 		}
 
-		desc := cmd.GetDescription(variables)
+		desc, err := ex.describe(cmd, variables)
+		if err != nil {
+			return NewCommandAwareError(err, cmd, variables)
+		}
 		if desc != "" {
 			ex.ectx.Logger.Info(desc)
 		}
@@ -380,6 +427,9 @@ func (ex *Executor) Execute(variables map[string]any) (err error) {
 		if err != nil {
 			return NewCommandAwareError(err, cmd, variables)
 		}
+		if cr, ok := cmd.(ChangeReporter); ok {
+			variables["__step:"+stepName+":changed"] = cr.Changed()
+		}
 
 		hookName := cmd.GetHookAfter()
 		if hookName == "" {
@@ -407,11 +457,21 @@ func (ex *Executor) WithScenario(scenario string, opts ...Option) (*Executor, er
 		WithHooksAfter(ex.hooksAfter),
 		WithStdout(ex.ectx.Stdout),
 		WithStderr(ex.ectx.Stderr),
+		WithOutputWriterFactory(ex.ectx.Output),
+		WithProcessRunner(ex.ectx.Runner),
+		WithClock(ex.ectx.Clock),
+		WithChowner(ex.ectx.Chowner),
+		WithResolver(ex.ectx.Resolver),
+		WithRecording(ex.ectx.Record),
+		WithReplay(ex.ectx.Replay),
 		WithFS(ex.ectx.Fs),
 		WithCommandTypes(ex.commandTypes),
 		WithLogger(ex.ectx.Logger),
 		withExperiments(ex.experiments),
-		WithRegisteredEvaluatorFunctions(ex.evaluatorFunctions.clone()),
+		withStrictTemplates(ex.strictTemplates),
+		withCompileCache(ex.cache),
+		withEvaluatorFunctionRegistry(ex.evaluatorFunctions.clone(), ex.funcSetVersion),
+		withInheritedValueFuncs(ex.valueFuncs, ex.evaluatorFunctionsInTemplates),
 	}
 	newOpts = append(newOpts, opts...)
 	result, err := NewWithScenario(scenario, newOpts...)
@@ -427,11 +487,21 @@ func (ex *Executor) WithCommands(cmds []Command, opts ...Option) *Executor {
 		WithHooksAfter(ex.hooksAfter),
 		WithStdout(ex.ectx.Stdout),
 		WithStderr(ex.ectx.Stderr),
+		WithOutputWriterFactory(ex.ectx.Output),
+		WithProcessRunner(ex.ectx.Runner),
+		WithClock(ex.ectx.Clock),
+		WithChowner(ex.ectx.Chowner),
+		WithResolver(ex.ectx.Resolver),
+		WithRecording(ex.ectx.Record),
+		WithReplay(ex.ectx.Replay),
 		WithFS(ex.ectx.Fs),
 		WithCommandTypes(ex.commandTypes),
 		WithLogger(ex.ectx.Logger),
 		withExperiments(ex.experiments),
-		WithRegisteredEvaluatorFunctions(ex.evaluatorFunctions.clone()),
+		withStrictTemplates(ex.strictTemplates),
+		withCompileCache(ex.cache),
+		withEvaluatorFunctionRegistry(ex.evaluatorFunctions.clone(), ex.funcSetVersion),
+		withInheritedValueFuncs(ex.valueFuncs, ex.evaluatorFunctionsInTemplates),
 	}
 	newOpts = append(newOpts, opts...)
 	result := New(newOpts...)
@@ -467,6 +537,7 @@ func (ex *Executor) RegisterEvaluatorFunctions(funcs map[string]EvaluatorFunctio
 
 func (ex *Executor) rebuildGovaluateEvaluatorFunctionCache() {
 	ex.govaluateEvaluatorFunctions = ex.evaluatorFunctions.govaluateFunctions()
+	ex.funcSetVersion = nextFuncSetVersion()
 }
 
 func (ex *Executor) experimentEnabled(name string) bool {
@@ -482,6 +553,10 @@ func (ex *Executor) applyScenarioMeta(meta *RawScenarioMeta) {
 		return
 	}
 
+	if meta.StrictTemplates != nil {
+		ex.strictTemplates = *meta.StrictTemplates
+	}
+
 	for _, flag := range meta.Experiments {
 		name, enabled := parseExperimentFlag(flag)
 		if name == "" {
@@ -551,6 +626,12 @@ func (ex *Executor) checkRequires(cmd Command, variables map[string]any) (skip b
 	return false, nil
 }
 
+// Evaluate evaluates expression against variables with the engine and
+// evaluator functions used for `requires`.
+func (ex *Executor) Evaluate(expression string, variables map[string]any) (any, error) {
+	return ex.evaluateRequires(expression, variables)
+}
+
 func (ex *Executor) evaluateRequires(reqs string, variables map[string]any) (any, error) {
 	if ex.experimentEnabled(experimentExpr) {
 		return ex.evaluateRequiresExpr(reqs, variables)
@@ -560,26 +641,26 @@ func (ex *Executor) evaluateRequires(reqs string, variables map[string]any) (any
 }
 
 func (ex *Executor) evaluateRequiresGovaluate(reqs string, variables map[string]any) (any, error) {
-	expression, err := govaluate.NewEvaluableExpressionWithFunctions(reqs, ex.govaluateEvaluatorFunctions)
-	if err != nil {
-		return nil, err
+	key := expressionKey{src: reqs, funcSet: ex.funcSetVersion, engine: "govaluate"}
+	compiled := ex.cache.expression(key, func() compiledExpression {
+		expression, err := govaluate.NewEvaluableExpressionWithFunctions(reqs, ex.govaluateEvaluatorFunctions)
+		if err == nil {
+			expression, err = govaluate.NewEvaluableExpressionFromTokens(markListArgs(expression.Tokens()))
+		}
+		return compiledExpression{govaluate: expression, err: err}
+	})
+	if compiled.err != nil {
+		return nil, compiled.err
 	}
 
-	return expression.Evaluate(variables)
+	return compiled.govaluate.Eval(govaluateParameters(variables))
 }
 
 func (ex *Executor) evaluateRequiresExpr(reqs string, variables map[string]any) (any, error) {
-	options := []expr.Option{
-		expr.Env(make(map[string]any)),
-		expr.AllowUndefinedVariables(),
-		expr.DisableAllBuiltins(),
-	}
-	options = append(options, ex.evaluatorFunctions.exprOptions()...)
-
-	program, err := expr.Compile(reqs, options...)
-	if err != nil {
-		return nil, err
-	}
+	key := expressionKey{src: reqs, funcSet: ex.funcSetVersion, engine: experimentExpr}
+	compiled := ex.cache.expression(key, func() compiledExpression {
+		return compileExpr(reqs, ex.evaluatorFunctions.exprOptions())
+	})
 
-	return expr.Run(program, variables)
+	return runExpr(compiled, variables)
 }
@@ -450,6 +450,36 @@ commands:
 		c.Assert(vars["matched"], qt.Equals, "expr")
 	})
 
+	t.Run("Execute_ExprBuiltins", func(t *testing.T) {
+		c := qt.New(t)
+		vars := map[string]any{
+			"list": []any{1, 2},
+			"os":   "ubuntu",
+			"host": map[string]any{"disks": []any{"sda", "sdb"}},
+		}
+
+		exc, err := godexer.NewWithScenario(`meta:
+  experiments:
+    - expr
+commands:
+  - type: variable
+    stepName: set_with_builtins
+    variable: matched
+    value: expr
+    requires: 'len(list) == 2 && os in ["debian", "ubuntu"] && all(host.disks, {# startsWith "sd"}) && upper(os) == "UBUNTU"'
+  - type: variable
+    stepName: set_with_disabled_builtin
+    variable: repeated
+    value: expr
+    requires: 'repeat("a", 2) == "aa"'
+`)
+		c.Assert(err, qt.IsNil)
+
+		err = exc.Execute(vars)
+		c.Assert(err, qt.ErrorMatches, `(?s).*set_with_disabled_builtin.*cannot call nil.*`)
+		c.Assert(vars["matched"], qt.Equals, "expr")
+	})
+
 	t.Run("Execute_ExprRequiresStillEnforcesBoolResult", func(t *testing.T) {
 		c := qt.New(t)
 
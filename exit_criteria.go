@@ -0,0 +1,100 @@
+package godexer
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/go-extras/errors"
+)
+
+// ErrFailedWhen is returned when a run exits successfully but its failedWhen
+// condition is met.
+var ErrFailedWhen = errors.New("failedWhen condition is met")
+
+// ExitCriteria holds the fields deciding the outcome of the exec commands.
+// The conditions see the step variables plus exit_status, stdout and stderr.
+type ExitCriteria struct {
+	// SuccessCodes lists the exit statuses treated as success (default [0]).
+	SuccessCodes []int
+	// FailedWhen is an expression marking the run as failed. When set, it
+	// replaces the successCodes check.
+	FailedWhen string
+	// ChangedWhen is an expression marking the step as changed. Steps that
+	// succeed are changed by default.
+	ChangedWhen string
+
+	changed bool
+}
+
+// NeedsOutput reports whether the conditions need the captured output.
+func (e *ExitCriteria) NeedsOutput() bool {
+	return e.FailedWhen != "" || e.ChangedWhen != ""
+}
+
+// Changed reports whether the last run changed anything.
+func (e *ExitCriteria) Changed() bool {
+	return e.changed
+}
+
+// CheckExit applies the criteria to a run that exited with code; runErr is
+// the error the run returned for it. It returns nil if the run succeeded,
+// and runErr, or an ErrFailedWhen error if runErr is nil, if it failed.
+func (e *ExitCriteria) CheckExit(r *BaseCommand, code int, runErr error, capture *Capture, variables map[string]any) error {
+	e.changed = false
+
+	var env map[string]any
+	if e.NeedsOutput() {
+		if r.Ectx.Executor == nil {
+			return errors.New("failedWhen and changedWhen require the command to be run from the executor")
+		}
+		env = maps.Clone(variables)
+		env["exit_status"] = float64(code)
+		env["stdout"] = capture.StdoutText()
+		env["stderr"] = capture.StderrText()
+	}
+
+	var failed bool
+	if e.FailedWhen != "" {
+		var err error
+		failed, err = evaluateCondition(r, "failedWhen", e.FailedWhen, env)
+		if err != nil {
+			return err
+		}
+	} else {
+		successCodes := e.SuccessCodes
+		if len(successCodes) == 0 {
+			successCodes = []int{0}
+		}
+		failed = !slices.Contains(successCodes, code)
+	}
+
+	switch {
+	case failed && runErr != nil:
+		return runErr
+	case failed:
+		return errors.Wrapf(ErrFailedWhen, "exit status %d", code)
+	}
+
+	if e.ChangedWhen == "" {
+		e.changed = true
+		return nil
+	}
+	changed, err := evaluateCondition(r, "changedWhen", e.ChangedWhen, env)
+	if err != nil {
+		return err
+	}
+	e.changed = changed
+	return nil
+}
+
+func evaluateCondition(r *BaseCommand, field, expression string, variables map[string]any) (bool, error) {
+	result, err := r.Ectx.Executor.Evaluate(expression, variables)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to evaluate %s", field)
+	}
+	b, ok := result.(bool)
+	if !ok {
+		return false, errors.Errorf("%s must evaluate to bool, got %T", field, result)
+	}
+	return b, nil
+}
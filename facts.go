@@ -0,0 +1,352 @@
+package godexer
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-extras/errors"
+	"github.com/spf13/afero"
+)
+
+//nolint:gochecknoinits // init is used for automatic command registration
+func init() {
+	RegisterCommand("facts", NewFactsCommand)
+}
+
+// FactsSource gives the facts command access to a host. Methods must return an
+// error satisfying errors.Is(err, fs.ErrNotExist) for missing paths; such facts
+// are left out instead of failing the command.
+type FactsSource interface {
+	ReadFile(name string) ([]byte, error)
+	// ReadDir returns the names of the directory entries.
+	ReadDir(name string) ([]string, error)
+	Exists(name string) (bool, error)
+	// Arch returns the machine hardware name, as printed by `uname -m`.
+	Arch() (string, error)
+}
+
+// packageManagers are probed in order; the first one found wins.
+var packageManagers = []struct {
+	name   string
+	binary string
+}{
+	{"apt", "/usr/bin/apt-get"},
+	{"dnf", "/usr/bin/dnf"},
+	{"yum", "/usr/bin/yum"},
+	{"zypper", "/usr/bin/zypper"},
+	{"apk", "/sbin/apk"},
+	{"pacman", "/usr/bin/pacman"},
+	{"brew", "/opt/homebrew/bin/brew"},
+	{"brew", "/usr/local/bin/brew"},
+}
+
+// goArchToMachine maps GOARCH values to the names `uname -m` prints.
+var goArchToMachine = map[string]string{
+	"amd64":   "x86_64",
+	"386":     "i686",
+	"arm64":   "aarch64",
+	"arm":     "armv7l",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+}
+
+type fsFactsSource struct {
+	fs afero.Fs
+}
+
+// NewFsFactsSource returns a FactsSource reading the local host through fs.
+// The architecture is the one the binary was built for.
+func NewFsFactsSource(fs afero.Fs) FactsSource {
+	return &fsFactsSource{fs: fs}
+}
+
+func (s *fsFactsSource) ReadFile(name string) ([]byte, error) {
+	return afero.ReadFile(s.fs, name)
+}
+
+func (s *fsFactsSource) ReadDir(name string) ([]string, error) {
+	infos, err := afero.ReadDir(s.fs, name)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(infos))
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+	return names, nil
+}
+
+func (s *fsFactsSource) Exists(name string) (bool, error) {
+	return fileExists(s.fs, name)
+}
+
+func (*fsFactsSource) Arch() (string, error) {
+	if machine, ok := goArchToMachine[runtime.GOARCH]; ok {
+		return machine, nil
+	}
+	return runtime.GOARCH, nil
+}
+
+// NewFactsCommand creates a facts command reading the executor's Fs.
+func NewFactsCommand(ectx *ExecutorContext) Command {
+	return &FactsCommand{
+		BaseCommand: BaseCommand{
+			Ectx: ectx,
+		},
+	}
+}
+
+// NewFactsCommandWithSource creates a facts command constructor gathering facts from src.
+func NewFactsCommandWithSource(src FactsSource) func(ectx *ExecutorContext) Command {
+	return func(ectx *ExecutorContext) Command {
+		return &FactsCommand{
+			BaseCommand: BaseCommand{
+				Ectx: ectx,
+			},
+			source: src,
+		}
+	}
+}
+
+// FactsCommand stores host facts in Variable (`facts` by default):
+//
+//	os:              os-release fields with lowercased keys (id, version_id, pretty_name, ...)
+//	kernel:          kernel release
+//	arch:            machine hardware name (x86_64, aarch64, ...)
+//	cpus:            number of processors
+//	memory.total:    total memory in bytes
+//	hostname:        host name
+//	mounts:          list of {device, mountpoint, fstype, options}
+//	interfaces:      list of {name, mac, mtu, state}
+//	package_manager: apt, dnf, yum, zypper, apk, pacman or brew
+type FactsCommand struct {
+	BaseCommand
+	Variable string
+
+	// source defaults to the executor's Fs
+	source FactsSource
+}
+
+func (r *FactsCommand) Execute(variables map[string]any) error {
+	name, err := r.EvalString("variable", stringDef(r.Variable, "facts"), variables)
+	if err != nil {
+		return err
+	}
+
+	src := r.source
+	if src == nil {
+		src = NewFsFactsSource(r.Ectx.Fs)
+	}
+
+	facts, err := GatherFacts(src)
+	if err != nil {
+		return errors.Wrap(err, "failed to gather facts")
+	}
+	variables[name] = facts
+	return nil
+}
+
+// GatherFacts collects host facts from src. See FactsCommand for the layout.
+func GatherFacts(src FactsSource) (map[string]any, error) {
+	facts := make(map[string]any)
+
+	gatherers := []func(FactsSource, map[string]any) error{
+		gatherOSRelease,
+		gatherKernel,
+		gatherArch,
+		gatherCPUs,
+		gatherMemory,
+		gatherHostname,
+		gatherMounts,
+		gatherInterfaces,
+		gatherPackageManager,
+	}
+	for _, gather := range gatherers {
+		if err := gather(src, facts); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return facts, nil
+}
+
+func gatherOSRelease(src FactsSource, facts map[string]any) error {
+	data, err := src.ReadFile("/etc/os-release")
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = src.ReadFile("/usr/lib/os-release")
+	}
+	if err != nil {
+		return err
+	}
+	facts["os"] = parseOSRelease(data)
+	return nil
+}
+
+func parseOSRelease(data []byte) map[string]any {
+	result := make(map[string]any)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+		result[strings.ToLower(key)] = value
+	}
+	return result
+}
+
+func gatherKernel(src FactsSource, facts map[string]any) error {
+	data, err := src.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return err
+	}
+	facts["kernel"] = strings.TrimSpace(string(data))
+	return nil
+}
+
+func gatherArch(src FactsSource, facts map[string]any) error {
+	arch, err := src.Arch()
+	if err != nil {
+		return err
+	}
+	facts["arch"] = arch
+	return nil
+}
+
+func gatherCPUs(src FactsSource, facts map[string]any) error {
+	data, err := src.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return err
+	}
+	count := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, _, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.TrimSpace(key) == "processor" {
+			count++
+		}
+	}
+	facts["cpus"] = count
+	return nil
+}
+
+func gatherMemory(src FactsSource, facts map[string]any) error {
+	data, err := src.ReadFile("/proc/meminfo")
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return errors.Wrap(err, "invalid MemTotal in /proc/meminfo")
+		}
+		facts["memory"] = map[string]any{"total": kb * 1024}
+		break
+	}
+	return nil
+}
+
+func gatherHostname(src FactsSource, facts map[string]any) error {
+	data, err := src.ReadFile("/proc/sys/kernel/hostname")
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = src.ReadFile("/etc/hostname")
+	}
+	if err != nil {
+		return err
+	}
+	facts["hostname"] = strings.TrimSpace(string(data))
+	return nil
+}
+
+func gatherMounts(src FactsSource, facts map[string]any) error {
+	data, err := src.ReadFile("/proc/mounts")
+	if err != nil {
+		return err
+	}
+	mounts := make([]any, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		mounts = append(mounts, map[string]any{
+			"device":     fields[0],
+			"mountpoint": fields[1],
+			"fstype":     fields[2],
+			"options":    fields[3],
+		})
+	}
+	facts["mounts"] = mounts
+	return nil
+}
+
+func gatherInterfaces(src FactsSource, facts map[string]any) error {
+	const netDir = "/sys/class/net"
+	names, err := src.ReadDir(netDir)
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	interfaces := make([]any, 0, len(names))
+	for _, name := range names {
+		iface := map[string]any{"name": name}
+		for key, file := range map[string]string{"mac": "address", "state": "operstate", "mtu": "mtu"} {
+			data, err := src.ReadFile(path.Join(netDir, name, file))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			value := strings.TrimSpace(string(data))
+			if key == "mtu" {
+				if mtu, err := strconv.Atoi(value); err == nil {
+					iface[key] = mtu
+				}
+				continue
+			}
+			iface[key] = value
+		}
+		interfaces = append(interfaces, iface)
+	}
+	facts["interfaces"] = interfaces
+	return nil
+}
+
+func gatherPackageManager(src FactsSource, facts map[string]any) error {
+	for _, pm := range packageManagers {
+		ok, err := src.Exists(pm.binary)
+		if err != nil {
+			return err
+		}
+		if ok {
+			facts["package_manager"] = pm.name
+			return nil
+		}
+	}
+	facts["package_manager"] = ""
+	return nil
+}
@@ -0,0 +1,116 @@
+package godexer_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/spf13/afero"
+
+	"github.com/go-extras/godexer"
+)
+
+func newFactsFs(c *qt.C) afero.Fs {
+	fs := afero.NewMemMapFs()
+	files := map[string]string{
+		"/etc/os-release": `NAME="Ubuntu"
+VERSION_ID="22.04"
+ID=ubuntu
+ID_LIKE=debian
+PRETTY_NAME="Ubuntu 22.04.4 LTS"
+`,
+		"/proc/sys/kernel/osrelease":  "5.15.0-101-generic\n",
+		"/proc/sys/kernel/hostname":   "web-01\n",
+		"/proc/cpuinfo":               "processor\t: 0\nmodel name\t: x\n\nprocessor\t: 1\nmodel name\t: x\n",
+		"/proc/meminfo":               "MemTotal:        2048 kB\nMemFree:          512 kB\n",
+		"/proc/mounts":                "/dev/sda1 / ext4 rw,relatime 0 0\ntmpfs /run tmpfs rw,nosuid 0 0\n",
+		"/sys/class/net/lo/address":   "00:00:00:00:00:00\n",
+		"/sys/class/net/lo/operstate": "unknown\n",
+		"/sys/class/net/lo/mtu":       "65536\n",
+		"/sys/class/net/eth0/address": "52:54:00:12:34:56\n",
+		"/sys/class/net/eth0/mtu":     "1500\n",
+		"/usr/bin/apt-get":            "",
+	}
+	for name, contents := range files {
+		c.Assert(afero.WriteFile(fs, name, []byte(contents), 0o644), qt.IsNil)
+	}
+	return fs
+}
+
+func TestFactsCommand(t *testing.T) {
+	t.Run("Execute", func(t *testing.T) {
+		c := qt.New(t)
+		ex, err := godexer.NewWithScenario(`meta:
+  experiments: [expr]
+commands:
+  - type: facts
+    stepName: gather
+  - type: variable
+    stepName: summary
+    variable: summary
+    value: '{{ .facts.os.id }} {{ .facts.os.version_id }} {{ .facts.kernel }} {{ .facts.cpus }} {{ .facts.memory.total }} {{ .facts.hostname }} {{ .facts.package_manager }}'
+    requires: 'facts.os.id == "ubuntu"'
+`,
+			godexer.WithFS(newFactsFs(c)),
+		)
+		c.Assert(err, qt.IsNil)
+
+		vars := map[string]any{}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["summary"], qt.Equals, "ubuntu 22.04 5.15.0-101-generic 2 2097152 web-01 apt")
+
+		facts := vars["facts"].(map[string]any)
+		c.Assert(facts["arch"], qt.Not(qt.Equals), "")
+		c.Assert(facts["os"], qt.DeepEquals, map[string]any{
+			"name":        "Ubuntu",
+			"version_id":  "22.04",
+			"id":          "ubuntu",
+			"id_like":     "debian",
+			"pretty_name": "Ubuntu 22.04.4 LTS",
+		})
+		c.Assert(facts["mounts"], qt.DeepEquals, []any{
+			map[string]any{"device": "/dev/sda1", "mountpoint": "/", "fstype": "ext4", "options": "rw,relatime"},
+			map[string]any{"device": "tmpfs", "mountpoint": "/run", "fstype": "tmpfs", "options": "rw,nosuid"},
+		})
+		c.Assert(facts["interfaces"], qt.DeepEquals, []any{
+			map[string]any{"name": "eth0", "mac": "52:54:00:12:34:56", "mtu": 1500},
+			map[string]any{"name": "lo", "mac": "00:00:00:00:00:00", "mtu": 65536, "state": "unknown"},
+		})
+	})
+
+	t.Run("Execute_CustomVariable", func(t *testing.T) {
+		c := qt.New(t)
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: facts
+    stepName: gather
+    variable: host
+`,
+			godexer.WithFS(afero.NewMemMapFs()),
+		)
+		c.Assert(err, qt.IsNil)
+
+		vars := map[string]any{}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+
+		// missing files are skipped rather than failing the step
+		facts := vars["host"].(map[string]any)
+		c.Assert(facts["os"], qt.IsNil)
+		c.Assert(facts["kernel"], qt.IsNil)
+		c.Assert(facts["package_manager"], qt.Equals, "")
+	})
+
+	t.Run("Execute_InvalidMeminfo", func(t *testing.T) {
+		c := qt.New(t)
+		fs := afero.NewMemMapFs()
+		c.Assert(afero.WriteFile(fs, "/proc/meminfo", []byte("MemTotal: lots kB\n"), 0o644), qt.IsNil)
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: facts
+    stepName: gather
+`,
+			godexer.WithFS(fs),
+		)
+		c.Assert(err, qt.IsNil)
+
+		err = ex.Execute(map[string]any{})
+		c.Assert(err, qt.ErrorMatches, ".*failed to gather facts: invalid MemTotal.*")
+	})
+}
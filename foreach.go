@@ -104,6 +104,9 @@ func (*ForeachCommand) convertIterable(iterable any) (map[string]any, []any, err
 }
 
 func (r *ForeachCommand) prepareCommands() error {
+	// the command may be executed more than once (e.g. in a nested foreach),
+	// so start from scratch instead of appending to the previous run
+	r.commands = make([]Command, 0, len(r.RawCommands))
 	for _, q := range r.RawCommands {
 		var tq struct{ Type string }
 		if err := json.Unmarshal(q, &tq); err != nil {
@@ -396,4 +396,34 @@ func TestForeach(t *testing.T) {
 		c.Assert(err, qt.IsNotNil)
 		c.Assert(err.Error(), qt.Equals, "foreach: invalid map key type \"int\" (expected string)")
 	})
+	t.Run("Execute_Nested", func(t *testing.T) {
+		c := qt.New(t)
+
+		logger := logrus.New()
+		memlog := &bytes.Buffer{}
+		logger.SetOutput(memlog)
+		logger.SetFormatter(&testutils.SimpleFormatter{})
+
+		exc, err := godexer.NewWithScenario(`commands:
+  - type: foreach
+    stepName: outer
+    iterable: [1, 2, 3]
+    commands:
+      - type: foreach
+        stepName: inner
+        iterable: ["a"]
+        commands:
+          - type: message
+            stepName: msg
+            description: '{{ .parent.value }}{{ .value }}'
+`,
+			godexer.WithFS(afero.NewMemMapFs()),
+			godexer.WithLogger(logger),
+		)
+		c.Assert(err, qt.IsNil)
+
+		err = exc.Execute(make(map[string]any))
+		c.Assert(err, qt.IsNil)
+		c.Assert(memlog.String(), qt.Equals, "1a\n2a\n3a\n")
+	})
 }
@@ -0,0 +1,144 @@
+package godexertest
+
+import (
+	"io/fs"
+	"reflect"
+	"testing"
+
+	"github.com/go-extras/errors"
+	"github.com/spf13/afero"
+)
+
+// CheckStepRan returns an error unless the step ran, i.e. was reached and not
+// skipped by its requires condition.
+func CheckStepRan(vars map[string]any, step string) error {
+	skipped, ok := vars["__step:"+step+":skipped"]
+	switch {
+	case !ok:
+		return errors.Errorf("step %q did not run", step)
+	case skipped == true:
+		return errors.Errorf("step %q was skipped, expected it to run", step)
+	}
+	return nil
+}
+
+// CheckStepSkipped returns an error unless the step was skipped by its
+// requires condition.
+func CheckStepSkipped(vars map[string]any, step string) error {
+	skipped, ok := vars["__step:"+step+":skipped"]
+	switch {
+	case !ok:
+		return errors.Errorf("step %q was not reached", step)
+	case skipped != true:
+		return errors.Errorf("step %q ran, expected it to be skipped", step)
+	}
+	return nil
+}
+
+// CheckStepChanged returns an error unless the step reported whether it
+// changed anything, and that is want.
+func CheckStepChanged(vars map[string]any, step string, want bool) error {
+	changed, ok := vars["__step:"+step+":changed"]
+	if !ok {
+		return errors.Errorf("step %q reported no change status", step)
+	}
+	if changed != want {
+		return errors.Errorf("step %q changed = %v, want %v", step, changed, want)
+	}
+	return nil
+}
+
+// CheckVariable returns an error unless the variable deep-equals want.
+func CheckVariable(vars map[string]any, name string, want any) error {
+	got, ok := vars[name]
+	if !ok {
+		return errors.Errorf("variable %q is not set", name)
+	}
+	if !reflect.DeepEqual(got, want) {
+		return errors.Errorf("variable %q = %#v, want %#v", name, got, want)
+	}
+	return nil
+}
+
+// CheckFileContents returns an error unless the file exists on fsys with the
+// contents.
+func CheckFileContents(fsys afero.Fs, name, want string) error {
+	got, err := afero.ReadFile(fsys, name)
+	if err != nil {
+		return errors.Wrapf(err, "can't read %s", name)
+	}
+	if string(got) != want {
+		return errors.Errorf("file %s contents = %q, want %q", name, got, want)
+	}
+	return nil
+}
+
+// CheckNoFile returns an error if the file exists on fsys.
+func CheckNoFile(fsys afero.Fs, name string) error {
+	_, err := fsys.Stat(name)
+	switch {
+	case err == nil:
+		return errors.Errorf("file %s exists", name)
+	case !errors.Is(err, fs.ErrNotExist):
+		return errors.Wrapf(err, "can't stat %s", name)
+	}
+	return nil
+}
+
+// CheckCommandRan returns an error unless a command matching pattern (see
+// FakeRunner.On) was started through the runner.
+func CheckCommandRan(r *FakeRunner, pattern string) error {
+	if len(r.Matching(pattern)) == 0 {
+		return errors.Errorf("no command matching %q ran; commands: %q", pattern, r.commandLines())
+	}
+	return nil
+}
+
+// AssertStepRan fails the test unless the step ran.
+func AssertStepRan(t testing.TB, vars map[string]any, step string) {
+	t.Helper()
+	report(t, CheckStepRan(vars, step))
+}
+
+// AssertStepSkipped fails the test unless the step was skipped.
+func AssertStepSkipped(t testing.TB, vars map[string]any, step string) {
+	t.Helper()
+	report(t, CheckStepSkipped(vars, step))
+}
+
+// AssertStepChanged fails the test unless the step's change status is want.
+func AssertStepChanged(t testing.TB, vars map[string]any, step string, want bool) {
+	t.Helper()
+	report(t, CheckStepChanged(vars, step, want))
+}
+
+// AssertVariable fails the test unless the variable deep-equals want.
+func AssertVariable(t testing.TB, vars map[string]any, name string, want any) {
+	t.Helper()
+	report(t, CheckVariable(vars, name, want))
+}
+
+// AssertFileContents fails the test unless the file has the contents.
+func AssertFileContents(t testing.TB, fsys afero.Fs, name, want string) {
+	t.Helper()
+	report(t, CheckFileContents(fsys, name, want))
+}
+
+// AssertNoFile fails the test if the file exists.
+func AssertNoFile(t testing.TB, fsys afero.Fs, name string) {
+	t.Helper()
+	report(t, CheckNoFile(fsys, name))
+}
+
+// AssertCommandRan fails the test unless a command matching pattern ran.
+func AssertCommandRan(t testing.TB, r *FakeRunner, pattern string) {
+	t.Helper()
+	report(t, CheckCommandRan(r, pattern))
+}
+
+func report(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+}
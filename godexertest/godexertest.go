@@ -0,0 +1,108 @@
+// Package godexertest helps unit-testing godexer scenarios: it runs them
+// against a fake process runner, an in-memory filesystem and a fake clock,
+// and provides assertions on the outcome.
+package godexertest
+
+import (
+	"bytes"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/go-extras/godexer"
+	"github.com/go-extras/godexer/internal/logger"
+)
+
+// NewFs returns an in-memory filesystem holding files, keyed by path. Parent
+// directories are created as needed. It panics if a file can't be written.
+func NewFs(files map[string]string) afero.Fs {
+	fs := afero.NewMemMapFs()
+	for name, contents := range files {
+		if err := fs.MkdirAll(path.Dir(name), 0o755); err != nil {
+			panic(err)
+		}
+		if err := afero.WriteFile(fs, name, []byte(contents), 0o644); err != nil {
+			panic(err)
+		}
+	}
+	return fs
+}
+
+// Clock is a godexer.Clock whose time only moves when slept on.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+// NewClock returns a clock set to now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+}
+
+// Sleeps returns the durations slept so far.
+func (c *Clock) Sleeps() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.sleeps...)
+}
+
+// Harness bundles the fakes a scenario runs against.
+type Harness struct {
+	Runner *FakeRunner
+	Fs     afero.Fs
+	Clock  *Clock
+	Stdout bytes.Buffer
+	Stderr bytes.Buffer
+}
+
+// New returns a harness with an empty runner, a filesystem holding files and
+// a clock set to 2000-01-01 UTC.
+func New(files map[string]string) *Harness {
+	return &Harness{
+		Runner: NewFakeRunner(),
+		Fs:     NewFs(files),
+		Clock:  NewClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}
+}
+
+// Options returns the executor options wiring the harness in. Append your
+// own, such as godexer.WithCommandTypes, after them.
+func (h *Harness) Options() []godexer.Option {
+	return []godexer.Option{
+		godexer.WithProcessRunner(h.Runner),
+		godexer.WithFS(h.Fs),
+		godexer.WithClock(h.Clock),
+		godexer.WithStdout(&h.Stdout),
+		godexer.WithStderr(&h.Stderr),
+		godexer.WithLogger(&logger.Logger{Level: logger.ErrorLevel}),
+	}
+}
+
+// Run loads the scenario with the harness options followed by opts and
+// executes it with vars, which may be nil. It returns the final variables.
+func (h *Harness) Run(scenario string, vars map[string]any, opts ...godexer.Option) (map[string]any, error) {
+	ex, err := godexer.NewWithScenario(scenario, append(h.Options(), opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	if vars == nil {
+		vars = make(map[string]any)
+	}
+	return vars, ex.Execute(vars)
+}
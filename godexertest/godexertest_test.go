@@ -0,0 +1,208 @@
+package godexertest_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-extras/godexer"
+	"github.com/go-extras/godexer/godexertest"
+)
+
+func TestHarness(t *testing.T) {
+	t.Run("scripted_commands", func(t *testing.T) {
+		c := qt.New(t)
+		h := godexertest.New(nil)
+		h.Runner.
+			On(`^git rev-parse`, godexertest.Result{Stdout: "abc123\n"}).
+			On(`^make deploy`, godexertest.Result{Stderr: "boom\n", ExitCode: 2})
+
+		vars, err := h.Run(`commands:
+  - type: exec
+    stepName: rev
+    variable: rev
+    cmd: ["git", "rev-parse", "HEAD"]
+  - type: exec
+    stepName: deploy
+    cmd: ["make", "deploy", "REV={{ .rev }}"]
+    allowFail: true
+  - type: exec
+    stepName: never
+    cmd: ["rm", "-rf", "/"]
+    requires: 'false'
+`, map[string]any{})
+		c.Assert(err, qt.IsNil)
+
+		godexertest.AssertStepRan(t, vars, "rev")
+		godexertest.AssertStepRan(t, vars, "deploy")
+		godexertest.AssertStepSkipped(t, vars, "never")
+		godexertest.AssertVariable(t, vars, "rev", "abc123\n")
+		godexertest.AssertVariable(t, vars, "deploy_exit_status", 2)
+		godexertest.AssertCommandRan(t, h.Runner, `^make deploy REV=abc123\n$`)
+		c.Assert(h.Stderr.String(), qt.Equals, "boom\n")
+		c.Assert(h.Runner.Calls(), qt.HasLen, 2)
+	})
+
+	t.Run("unexpected_command", func(t *testing.T) {
+		c := qt.New(t)
+		h := godexertest.New(nil)
+		_, err := h.Run(`commands:
+  - type: exec
+    cmd: ["uname", "-a"]
+`, nil)
+		c.Assert(err, qt.ErrorIs, godexertest.ErrUnexpectedCommand)
+		c.Assert(err, qt.ErrorMatches, `.*"uname -a": unexpected command`)
+	})
+
+	t.Run("stdin_env_and_dir", func(t *testing.T) {
+		c := qt.New(t)
+		h := godexertest.New(nil)
+		h.Runner.On(`^cat$`, godexertest.Result{})
+		_, err := h.Run(`commands:
+  - type: exec
+    cmd: ["cat"]
+    stdin: hello
+    dir: /srv
+    env: ["A=1"]
+`, nil)
+		c.Assert(err, qt.IsNil)
+		c.Assert(h.Runner.Calls(), qt.DeepEquals, []godexertest.Call{{
+			Argv:  []string{"cat"},
+			Env:   []string{"A=1"},
+			Dir:   "/srv",
+			Stdin: "hello",
+		}})
+	})
+
+	t.Run("seeded_fs", func(t *testing.T) {
+		c := qt.New(t)
+		h := godexertest.New(map[string]string{"/etc/app/old.conf": "old"})
+		vars, err := h.Run(`commands:
+  - type: writefile
+    stepName: write
+    file: /etc/app/new.conf
+    contents: 'port={{ .port }}'
+`, map[string]any{"port": 8080})
+		c.Assert(err, qt.IsNil)
+		godexertest.AssertStepRan(t, vars, "write")
+		godexertest.AssertFileContents(t, h.Fs, "/etc/app/old.conf", "old")
+		godexertest.AssertFileContents(t, h.Fs, "/etc/app/new.conf", "port=8080")
+		godexertest.AssertNoFile(t, h.Fs, "/etc/app/other.conf")
+	})
+
+	t.Run("clock", func(t *testing.T) {
+		c := qt.New(t)
+		h := godexertest.New(nil)
+		vars, err := h.Run(`commands:
+  - type: sleep
+    seconds: 90
+  - type: variable
+    variable: year
+    value: '{{ now.Year }}'
+`, nil)
+		c.Assert(err, qt.IsNil)
+		c.Assert(h.Clock.Sleeps(), qt.DeepEquals, []time.Duration{90 * time.Second})
+		c.Assert(h.Clock.Now(), qt.Equals, time.Date(2000, 1, 1, 0, 1, 30, 0, time.UTC))
+		godexertest.AssertVariable(t, vars, "year", "2000")
+	})
+
+	t.Run("custom_command_types", func(t *testing.T) {
+		c := qt.New(t)
+		h := godexertest.New(nil)
+		h.Runner.On(`^systemctl restart nginx$`, godexertest.Result{})
+
+		types := godexer.GetRegisteredCommands()
+		types["restart"] = newRestartCommand
+		vars, err := h.Run(`commands:
+  - type: restart
+    stepName: restart
+    service: nginx
+`, nil, godexer.WithCommandTypes(types))
+		c.Assert(err, qt.IsNil)
+		godexertest.AssertStepRan(t, vars, "restart")
+		godexertest.AssertCommandRan(t, h.Runner, `^systemctl restart nginx$`)
+	})
+}
+
+type restartCommand struct {
+	godexer.BaseCommand
+	Service string
+}
+
+func newRestartCommand(ectx *godexer.ExecutorContext) godexer.Command {
+	return &restartCommand{BaseCommand: godexer.BaseCommand{Ectx: ectx}}
+}
+
+func (r *restartCommand) Execute(map[string]any) error {
+	p, err := r.Ectx.StartProcess(&godexer.ProcessSpec{
+		Path:   "systemctl",
+		Args:   []string{"restart", r.Service},
+		Stdout: r.Ectx.Stdout,
+		Stderr: r.Ectx.Stderr,
+	})
+	if err != nil {
+		return err
+	}
+	return p.Wait()
+}
+
+// recorder is a testing.TB collecting failures instead of reporting them.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertions(t *testing.T) {
+	c := qt.New(t)
+	h := godexertest.New(map[string]string{"/a": "x"})
+	h.Runner.On(`^true$`, godexertest.Result{})
+	vars := map[string]any{
+		"__step:ran:skipped":     false,
+		"__step:ran:changed":     true,
+		"__step:skipped:skipped": true,
+		"list":                   []any{"a"},
+	}
+
+	r := &recorder{TB: t}
+	godexertest.AssertStepRan(r, vars, "ran")
+	godexertest.AssertStepSkipped(r, vars, "skipped")
+	godexertest.AssertStepChanged(r, vars, "ran", true)
+	godexertest.AssertVariable(r, vars, "list", []any{"a"})
+	godexertest.AssertFileContents(r, h.Fs, "/a", "x")
+	godexertest.AssertNoFile(r, h.Fs, "/b")
+	c.Assert(r.errors, qt.HasLen, 0)
+
+	r = &recorder{TB: t}
+	godexertest.AssertStepRan(r, vars, "skipped")
+	godexertest.AssertStepRan(r, vars, "missing")
+	godexertest.AssertStepSkipped(r, vars, "ran")
+	godexertest.AssertStepChanged(r, vars, "ran", false)
+	godexertest.AssertStepChanged(r, vars, "skipped", true)
+	godexertest.AssertVariable(r, vars, "list", []any{"b"})
+	godexertest.AssertVariable(r, vars, "missing", 1)
+	godexertest.AssertFileContents(r, h.Fs, "/a", "y")
+	godexertest.AssertFileContents(r, h.Fs, "/b", "")
+	godexertest.AssertNoFile(r, h.Fs, "/a")
+	godexertest.AssertCommandRan(r, h.Runner, `^true$`)
+	c.Assert(r.errors, qt.DeepEquals, []string{
+		`step "skipped" was skipped, expected it to run`,
+		`step "missing" did not run`,
+		`step "ran" ran, expected it to be skipped`,
+		`step "ran" changed = true, want false`,
+		`step "skipped" reported no change status`,
+		`variable "list" = []interface {}{"a"}, want []interface {}{"b"}`,
+		`variable "missing" is not set`,
+		`file /a contents = "x", want "y"`,
+		`can't read /b: open /b: file does not exist`,
+		`file /a exists`,
+		`no command matching "^true$" ran; commands: []`,
+	})
+}
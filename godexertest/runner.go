@@ -0,0 +1,155 @@
+package godexertest
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-extras/errors"
+
+	"github.com/go-extras/godexer"
+)
+
+// ErrUnexpectedCommand is returned when a command matches no rule.
+var ErrUnexpectedCommand = errors.New("unexpected command")
+
+// Result is the scripted outcome of a command.
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Call is a command started through a FakeRunner.
+type Call struct {
+	Argv  []string
+	Env   []string
+	Dir   string
+	Stdin string
+}
+
+// CommandLine returns the call's argv joined with spaces.
+func (c Call) CommandLine() string {
+	return strings.Join(c.Argv, " ")
+}
+
+type rule struct {
+	pattern *regexp.Regexp
+	result  Result
+}
+
+// FakeRunner is a godexer.ProcessRunner that serves scripted results instead
+// of starting processes. Commands are matched by their argv joined with
+// spaces; the first matching rule wins.
+type FakeRunner struct {
+	mu    sync.Mutex
+	rules []rule
+	calls []Call
+}
+
+// NewFakeRunner returns a runner without rules.
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{}
+}
+
+// On makes commands matching the pattern, a regular expression, return result.
+// It panics if the pattern is invalid.
+func (r *FakeRunner) On(pattern string, result Result) *FakeRunner {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = append(r.rules, rule{pattern: regexp.MustCompile(pattern), result: result})
+	return r
+}
+
+// Calls returns the commands started so far.
+func (r *FakeRunner) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+func (r *FakeRunner) Start(spec *godexer.ProcessSpec) (godexer.Process, error) {
+	call := Call{
+		Argv: append([]string{spec.Path}, spec.Args...),
+		Env:  spec.Env,
+		Dir:  spec.Dir,
+	}
+	if spec.Stdin != nil {
+		stdin, err := io.ReadAll(spec.Stdin)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read stdin")
+		}
+		call.Stdin = string(stdin)
+	}
+
+	r.mu.Lock()
+	r.calls = append(r.calls, call)
+	result, ok := r.match(call.CommandLine())
+	r.mu.Unlock()
+	if !ok {
+		return nil, errors.Wrapf(ErrUnexpectedCommand, "%q", call.CommandLine())
+	}
+
+	if _, err := io.WriteString(spec.Stdout, result.Stdout); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(spec.Stderr, result.Stderr); err != nil {
+		return nil, err
+	}
+	return &process{exitCode: result.ExitCode}, nil
+}
+
+func (r *FakeRunner) match(commandLine string) (Result, bool) {
+	for _, rl := range r.rules {
+		if rl.pattern.MatchString(commandLine) {
+			return rl.result, true
+		}
+	}
+	return Result{}, false
+}
+
+type process struct {
+	exitCode int
+}
+
+func (p *process) Wait() error {
+	if p.exitCode != 0 {
+		return ExitError(p.exitCode)
+	}
+	return nil
+}
+
+// ExitError is the error returned for scripted non-zero exit codes.
+type ExitError int
+
+func (e ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func (e ExitError) ExitCode() int {
+	return int(e)
+}
+
+// Matching returns the calls whose command line matches the pattern. It
+// panics if the pattern is invalid.
+func (r *FakeRunner) Matching(pattern string) []Call {
+	re := regexp.MustCompile(pattern)
+	var result []Call
+	for _, c := range r.Calls() {
+		if re.MatchString(c.CommandLine()) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func (r *FakeRunner) commandLines() []string {
+	calls := r.Calls()
+	result := make([]string, 0, len(calls))
+	for _, c := range calls {
+		result = append(result, c.CommandLine())
+	}
+	return result
+}
@@ -0,0 +1,269 @@
+package godexer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-extras/errors"
+	"github.com/spf13/afero"
+)
+
+const (
+	passwdFile = "/etc/passwd"
+	groupFile  = "/etc/group"
+)
+
+// WithHostEvaluatorFunctions registers evaluator functions for inspecting the host.
+// File based checks go through the executor's afero.Fs.
+//
+// The following functions are available:
+// - `dir_exists(path string) (bool, error)` - returns true if path exists and is a directory
+// - `file_contains(path, regex string) (bool, error)` - returns true if the file contents match the regex
+// - `file_mode(path string) (string, error)` - returns the permission bits as an octal string, e.g. "0644"
+// - `env(name string) (string, error)` - returns the value of the environment variable
+// - `command_exists(name string) (bool, error)` - returns true if an executable is found on PATH
+// - `os() (string, error)` - returns the operating system, e.g. "linux"
+// - `arch() (string, error)` - returns the architecture, e.g. "amd64"
+// - `hostname() (string, error)` - returns the host name
+// - `user_exists(name string) (bool, error)` - returns true if the user (name or uid) is in /etc/passwd
+// - `group_exists(name string) (bool, error)` - returns true if the group (name or gid) is in /etc/group
+// - `regex_match(str, regex string) (bool, error)` - returns true if the string matches the regex
+// - `contains(haystack, needle any) (bool, error)` - substring check for strings, membership check for lists
+func WithHostEvaluatorFunctions() func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.RegisterEvaluatorFunction("dir_exists", func(args ...any) (any, error) {
+			sargs, err := stringArgs(args, 1)
+			if err != nil {
+				return false, err
+			}
+			fi, err := ex.ectx.Fs.Stat(sargs[0])
+			if err != nil {
+				if os.IsNotExist(err) {
+					return false, nil
+				}
+				return false, err
+			}
+			return fi.IsDir(), nil
+		})
+
+		ex.RegisterEvaluatorFunction("file_contains", func(args ...any) (any, error) {
+			sargs, err := stringArgs(args, 2)
+			if err != nil {
+				return false, err
+			}
+			re, err := regexp.Compile(sargs[1])
+			if err != nil {
+				return false, errors.Wrap(err, "invalid regex")
+			}
+			data, err := afero.ReadFile(ex.ectx.Fs, sargs[0])
+			if err != nil {
+				if os.IsNotExist(err) {
+					return false, nil
+				}
+				return false, err
+			}
+			return re.Match(data), nil
+		})
+
+		ex.RegisterEvaluatorFunction("file_mode", func(args ...any) (any, error) {
+			sargs, err := stringArgs(args, 1)
+			if err != nil {
+				return "", err
+			}
+			fi, err := ex.ectx.Fs.Stat(sargs[0])
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%04o", fi.Mode().Perm()), nil
+		})
+
+		ex.RegisterEvaluatorFunction("env", func(args ...any) (any, error) {
+			sargs, err := stringArgs(args, 1)
+			if err != nil {
+				return "", err
+			}
+			return os.Getenv(sargs[0]), nil
+		})
+
+		ex.RegisterEvaluatorFunction("command_exists", func(args ...any) (any, error) {
+			sargs, err := stringArgs(args, 1)
+			if err != nil {
+				return false, err
+			}
+			return commandExists(ex.ectx.Fs, sargs[0])
+		})
+
+		ex.RegisterEvaluatorFunction("os", func(args ...any) (any, error) {
+			if len(args) != 0 {
+				return "", errors.New("invalid number of arguments")
+			}
+			return runtime.GOOS, nil
+		})
+
+		ex.RegisterEvaluatorFunction("arch", func(args ...any) (any, error) {
+			if len(args) != 0 {
+				return "", errors.New("invalid number of arguments")
+			}
+			return runtime.GOARCH, nil
+		})
+
+		ex.RegisterEvaluatorFunction("hostname", func(args ...any) (any, error) {
+			if len(args) != 0 {
+				return "", errors.New("invalid number of arguments")
+			}
+			return os.Hostname()
+		})
+
+		ex.RegisterEvaluatorFunction("user_exists", func(args ...any) (any, error) {
+			name, err := nameOrIDArg(args)
+			if err != nil {
+				return false, err
+			}
+			return lookupDatabaseEntry(ex.ectx.Fs, passwdFile, name)
+		})
+
+		ex.RegisterEvaluatorFunction("group_exists", func(args ...any) (any, error) {
+			name, err := nameOrIDArg(args)
+			if err != nil {
+				return false, err
+			}
+			return lookupDatabaseEntry(ex.ectx.Fs, groupFile, name)
+		})
+
+		ex.RegisterEvaluatorFunction("regex_match", func(args ...any) (any, error) {
+			sargs, err := stringArgs(args, 2)
+			if err != nil {
+				return false, err
+			}
+			re, err := regexp.Compile(sargs[1])
+			if err != nil {
+				return false, errors.Wrap(err, "invalid regex")
+			}
+			return re.MatchString(sargs[0]), nil
+		})
+
+		ex.RegisterEvaluatorFunction("contains", func(args ...any) (any, error) {
+			if len(args) != 2 {
+				return false, errors.New("invalid number of arguments")
+			}
+			switch haystack := args[0].(type) {
+			case string:
+				needle, ok := args[1].(string)
+				if !ok {
+					return false, errors.New("argument 2 must be a string")
+				}
+				return strings.Contains(haystack, needle), nil
+			case []any:
+				return slices.ContainsFunc(haystack, func(item any) bool {
+					return reflect.DeepEqual(item, args[1])
+				}), nil
+			case []string:
+				needle, ok := args[1].(string)
+				return ok && slices.Contains(haystack, needle), nil
+			default:
+				return false, errors.New("argument 1 must be a string or a list")
+			}
+		})
+	}
+}
+
+func stringArgs(args []any, n int) ([]string, error) {
+	if len(args) != n {
+		return nil, errors.New("invalid number of arguments")
+	}
+	result := make([]string, n)
+	for i, arg := range args {
+		s, ok := arg.(string)
+		if !ok {
+			return nil, errors.Errorf("argument %d must be a string", i+1)
+		}
+		result[i] = s
+	}
+	return result, nil
+}
+
+// nameOrIDArg accepts a name or a numeric id, which govaluate passes as float64.
+func nameOrIDArg(args []any) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("invalid number of arguments")
+	}
+	switch v := args[0].(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int:
+		return strconv.Itoa(v), nil
+	default:
+		return "", errors.New("argument must be a name or an id")
+	}
+}
+
+// commandExists looks name up on PATH the way a shell would. Names containing
+// a slash are checked directly.
+func commandExists(fs afero.Fs, name string) (bool, error) {
+	if name == "" {
+		return false, nil
+	}
+	if strings.Contains(name, "/") {
+		return isExecutable(fs, name)
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			dir = "."
+		}
+		ok, err := isExecutable(fs, filepath.Join(dir, name))
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func isExecutable(fs afero.Fs, path string) (bool, error) {
+	fi, err := fs.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, os.ErrPermission) {
+			return false, nil
+		}
+		return false, err
+	}
+	return !fi.IsDir() && fi.Mode().Perm()&0o111 != 0, nil
+}
+
+// lookupDatabaseEntry reports whether a passwd(5) or group(5) style file has an
+// entry whose name (first field) or id (third field) equals key.
+func lookupDatabaseEntry(fs afero.Fs, path, key string) (bool, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if fields[0] == key || (len(fields) > 2 && fields[2] == key) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,153 @@
+package godexer_test
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/spf13/afero"
+
+	"github.com/go-extras/godexer"
+)
+
+const hostPasswd = `# comment
+root:x:0:0:root:/root:/bin/bash
+deploy:x:1001:1001::/home/deploy:/bin/sh
+`
+
+const hostGroup = `root:x:0:
+docker:x:998:deploy
+`
+
+func newHostFs(c *qt.C) afero.Fs {
+	fs := afero.NewMemMapFs()
+	c.Assert(afero.WriteFile(fs, "/etc/passwd", []byte(hostPasswd), 0o644), qt.IsNil)
+	c.Assert(afero.WriteFile(fs, "/etc/group", []byte(hostGroup), 0o644), qt.IsNil)
+	c.Assert(afero.WriteFile(fs, "/etc/app.conf", []byte("listen 8080\n"), 0o600), qt.IsNil)
+	c.Assert(afero.WriteFile(fs, "/opt/bin/tool", []byte("#!/bin/sh\n"), 0o755), qt.IsNil)
+	c.Assert(afero.WriteFile(fs, "/opt/bin/notexec", []byte("data"), 0o644), qt.IsNil)
+	return fs
+}
+
+func TestWithHostEvaluatorFunctions(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin:/opt/bin")
+	t.Setenv("GODEXER_TEST_ENV", "staging")
+	hostname, err := os.Hostname()
+	qt.Assert(t, err, qt.IsNil)
+
+	testcases := []struct {
+		requires string
+		want     bool
+	}{
+		{requires: `dir_exists("/etc")`, want: true},
+		{requires: `dir_exists("/etc/app.conf")`, want: false},
+		{requires: `dir_exists("/missing")`, want: false},
+		{requires: `file_contains("/etc/app.conf", "^listen [0-9]+")`, want: true},
+		{requires: `file_contains("/etc/app.conf", "ssl")`, want: false},
+		{requires: `file_contains("/missing", ".")`, want: false},
+		{requires: `file_mode("/etc/app.conf") == "0600"`, want: true},
+		{requires: `env("GODEXER_TEST_ENV") == "staging"`, want: true},
+		{requires: `env("GODEXER_TEST_UNSET") == ""`, want: true},
+		{requires: `command_exists("tool")`, want: true},
+		{requires: `command_exists("notexec")`, want: false},
+		{requires: `command_exists("missing")`, want: false},
+		{requires: `command_exists("/opt/bin/tool")`, want: true},
+		{requires: `os() == "` + runtime.GOOS + `"`, want: true},
+		{requires: `arch() == "` + runtime.GOARCH + `"`, want: true},
+		{requires: `hostname() == "` + hostname + `"`, want: true},
+		{requires: `user_exists("deploy")`, want: true},
+		{requires: `user_exists(1001)`, want: true},
+		{requires: `user_exists("comment")`, want: false},
+		{requires: `group_exists("docker")`, want: true},
+		{requires: `group_exists("wheel")`, want: false},
+		{requires: `regex_match("web-01", "^web-[0-9]+$")`, want: true},
+		{requires: `regex_match("db-01", "^web")`, want: false},
+		{requires: `contains("ubuntu-22.04", "ubuntu")`, want: true},
+		{requires: `contains(roles, "db")`, want: true},
+		{requires: `contains(roles, "cache")`, want: false},
+		{requires: `contains(empty, "db")`, want: false},
+		{requires: `contains(single, "db")`, want: true},
+		{requires: `contains(single, "d")`, want: false},
+		{requires: `contains(hosts, web)`, want: true},
+		{requires: `contains(hosts, db)`, want: false},
+		{requires: `contains(ports, 443)`, want: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.requires, func(t *testing.T) {
+			c := qt.New(t)
+			ex, err := godexer.NewWithScenario(`commands:
+  - type: variable
+    stepName: check
+    variable: result
+    value: matched
+    requires: '`+tc.requires+`'
+`,
+				godexer.WithFS(newHostFs(c)),
+				godexer.WithHostEvaluatorFunctions(),
+			)
+			c.Assert(err, qt.IsNil)
+
+			web := map[string]any{"name": "web", "ports": []any{80, 443}}
+			vars := map[string]any{
+				"roles":  []any{"web", "db"},
+				"empty":  []any{},
+				"single": []any{"db"},
+				"hosts":  []any{web, map[string]any{"name": "cache"}},
+				"web":    web,
+				"db":     map[string]any{"name": "db"},
+				"ports":  []any{80.0, 443.0},
+			}
+			c.Assert(ex.Execute(vars), qt.IsNil)
+			c.Assert(vars["__step:check:skipped"], qt.Equals, !tc.want)
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		for _, requires := range []string{
+			`dir_exists(1)`,
+			`file_contains("/etc/app.conf", "(")`,
+			`file_mode("/missing") == "0600"`,
+			`os("x") == "linux"`,
+			`contains("ubuntu", 2)`,
+			`contains(1, 2)`,
+			`contains("ubuntu")`,
+		} {
+			t.Run(requires, func(t *testing.T) {
+				c := qt.New(t)
+				ex, err := godexer.NewWithScenario(`commands:
+  - type: message
+    stepName: check
+    requires: '`+requires+`'
+`,
+					godexer.WithFS(newHostFs(c)),
+					godexer.WithHostEvaluatorFunctions(),
+				)
+				c.Assert(err, qt.IsNil)
+				c.Assert(ex.Execute(map[string]any{}), qt.IsNotNil)
+			})
+		}
+	})
+
+	t.Run("expr_experiment", func(t *testing.T) {
+		c := qt.New(t)
+		ex, err := godexer.NewWithScenario(`meta:
+  experiments: [expr]
+commands:
+  - type: variable
+    stepName: check
+    variable: result
+    value: matched
+    requires: 'user_exists("deploy") && command_exists("tool")'
+`,
+			godexer.WithFS(newHostFs(c)),
+			godexer.WithHostEvaluatorFunctions(),
+		)
+		c.Assert(err, qt.IsNil)
+
+		vars := map[string]any{}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["result"], qt.Equals, "matched")
+	})
+}
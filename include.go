@@ -59,9 +59,9 @@ func (r *IncludeCommand) Execute(variables map[string]any) error {
 		return errors.New("storage is nil")
 	}
 
-	filename, ok := MaybeEvalValue(r.File, variables).(string)
-	if !ok {
-		return errors.Errorf("filename in %q must be a string", r.StepName)
+	filename, err := r.EvalString("file", r.File, variables)
+	if err != nil {
+		return err
 	}
 	if r.basepath != "" && filename[0] != '/' {
 		filename = strings.TrimRight(r.basepath, "/") + "/" + filename
@@ -90,15 +90,21 @@ func (r *IncludeCommand) Execute(variables map[string]any) error {
 		r.Variables = make(map[string]any)
 	}
 
+	rendered, err := r.EvalValue("variables", r.Variables, variables)
+	if err != nil {
+		return err
+	}
+	includeVars, _ := rendered.(map[string]any)
+
 	if !r.NoMergeVars {
-		for k, v := range r.Variables {
+		for k, v := range includeVars {
 			variables[k] = v
 		}
 		vars = variables
 		return r.SubExecuteCommand.Execute(vars)
 	}
 
-	vars = r.Variables
+	vars = includeVars
 	vars["_parent"] = variables
 	err = r.SubExecuteCommand.Execute(vars)
 	delete(vars, "_parent")
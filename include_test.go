@@ -259,4 +259,42 @@ func TestInclude(t *testing.T) {
 			},
 		})
 	})
+	t.Run("Execute_RendersVariables", func(t *testing.T) {
+		c := qt.New(t)
+		scripts := make(fstest.MapFS)
+		scripts["script/include.yaml"] = &fstest.MapFile{
+			Data: []byte(`commands:
+  - type: variable
+    stepName: copy
+    variable: copied
+    value: '{{ .settings.name }}:{{ index .settings.ports 0 }}'
+`),
+		}
+
+		commands := godexer.GetRegisteredCommands()
+		commands["include"] = godexer.NewIncludeCommand(scripts)
+
+		exc, err := godexer.NewWithScenario(`commands:
+  - type: include
+    stepName: inc
+    file: /script/include.yaml
+    noMergeVars: true
+    variables:
+      settings:
+        name: '{{ .app }}'
+        ports: ['${{ base_port + 1 }}']
+`,
+			godexer.WithFS(afero.NewMemMapFs()),
+			godexer.WithCommandTypes(commands),
+		)
+		c.Assert(err, qt.IsNil)
+
+		variables := map[string]any{"app": "web", "base_port": 8000}
+		err = exc.Execute(variables)
+		c.Assert(err, qt.IsNil)
+
+		incVars, ok := variables["inc_variables"].(map[string]any)
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(incVars["copied"], qt.Equals, "web:8001")
+	})
 }
@@ -0,0 +1,233 @@
+package exprmigrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	ghodssyaml "github.com/ghodss/yaml"
+	"gopkg.in/yaml.v3"
+)
+
+const experimentExpr = "expr"
+
+// commandListKeys are the keys whose values are lists of nested commands.
+var commandListKeys = map[string]bool{
+	"commands":       true,
+	"onEachFailure":  true,
+	"onFinalFailure": true,
+}
+
+// Finding describes the outcome of translating a single `requires` field.
+type Finding struct {
+	// Path locates the command, e.g. `commands[2].commands[0]`.
+	Path   string
+	Line   int
+	Before string
+	After  string
+	Issues []Issue
+}
+
+// Fatal reports whether the expression was left untranslated.
+func (f Finding) Fatal() bool {
+	return hasFatal(f.Issues)
+}
+
+// Options controls MigrateScenario.
+type Options struct {
+	// AddExperiment adds `expr` to `meta.experiments` when every expression
+	// was translated.
+	AddExperiment bool
+}
+
+// Result is the outcome of MigrateScenario.
+type Result struct {
+	Output   []byte
+	Findings []Finding
+	// AlreadyExpr is true when the scenario already opts into the expr
+	// experiment, in which case it is returned unchanged.
+	AlreadyExpr bool
+	// ExperimentAdded is true when `expr` was added to `meta.experiments`.
+	ExperimentAdded bool
+}
+
+// Untranslated returns the number of expressions that could not be translated.
+func (r *Result) Untranslated() int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Fatal() {
+			n++
+		}
+	}
+	return n
+}
+
+// MigrateScenario rewrites every `requires` expression in a YAML or JSON
+// scenario from govaluate to expr syntax. Expressions that cannot be
+// translated are left unchanged and reported in the result.
+func MigrateScenario(data []byte, opts Options) (*Result, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("scenario must be a mapping with a `commands` key")
+	}
+	root := doc.Content[0]
+
+	result := &Result{}
+	if experimentEnabled(root) {
+		result.AlreadyExpr = true
+		result.Output = data
+		return result, nil
+	}
+
+	walkCommandLists(root, "", func(path string, cmd *yaml.Node) {
+		req := mappingValue(cmd, "requires")
+		if req == nil || req.Kind != yaml.ScalarNode || strings.TrimSpace(req.Value) == "" {
+			return
+		}
+
+		translated, issues := Translate(req.Value)
+		finding := Finding{Path: path, Line: req.Line, Before: req.Value, After: translated, Issues: issues}
+		result.Findings = append(result.Findings, finding)
+		if !finding.Fatal() {
+			req.Value = translated
+		}
+	})
+
+	if opts.AddExperiment && result.Untranslated() == 0 {
+		addExperiment(root)
+		result.ExperimentAdded = true
+	}
+
+	out, err := encode(&doc, isJSON(data))
+	if err != nil {
+		return nil, err
+	}
+	result.Output = out
+	return result, nil
+}
+
+func walkCommandLists(node *yaml.Node, path string, fn func(path string, cmd *yaml.Node)) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if !commandListKeys[key.Value] || value.Kind != yaml.SequenceNode {
+			continue
+		}
+		for j, cmd := range value.Content {
+			cmdPath := fmt.Sprintf("%s[%d]", joinPath(path, key.Value), j)
+			if cmd.Kind != yaml.MappingNode {
+				continue
+			}
+			fn(cmdPath, cmd)
+			walkCommandLists(cmd, cmdPath, fn)
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func experimentEnabled(root *yaml.Node) bool {
+	meta := mappingValue(root, "meta")
+	if meta == nil || meta.Kind != yaml.MappingNode {
+		return false
+	}
+	experiments := mappingValue(meta, "experiments")
+	if experiments == nil || experiments.Kind != yaml.SequenceNode {
+		return false
+	}
+
+	enabled := false
+	for _, item := range experiments.Content {
+		switch strings.TrimSpace(item.Value) {
+		case experimentExpr:
+			enabled = true
+		case "-" + experimentExpr:
+			enabled = false
+		}
+	}
+	return enabled
+}
+
+func addExperiment(root *yaml.Node) {
+	meta := mappingValue(root, "meta")
+	if meta == nil || meta.Kind != yaml.MappingNode {
+		meta = &yaml.Node{Kind: yaml.MappingNode}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "meta"}
+		// keep the leading comment at the top of the document
+		if len(root.Content) > 0 {
+			key.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+		}
+		root.Content = append([]*yaml.Node{key, meta}, root.Content...)
+	}
+
+	experiments := mappingValue(meta, "experiments")
+	if experiments == nil || experiments.Kind != yaml.SequenceNode {
+		experiments = &yaml.Node{Kind: yaml.SequenceNode}
+		meta.Content = append(meta.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "experiments"},
+			experiments,
+		)
+	}
+
+	// drop a previous opt-out, if any
+	kept := experiments.Content[:0]
+	for _, item := range experiments.Content {
+		if strings.TrimSpace(item.Value) != "-"+experimentExpr {
+			kept = append(kept, item)
+		}
+	}
+	experiments.Content = append(kept, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: experimentExpr})
+}
+
+func encode(doc *yaml.Node, asJSON bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	if !asJSON {
+		return buf.Bytes(), nil
+	}
+
+	data, err := ghodssyaml.YAMLToJSON(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+func isJSON(data []byte) bool {
+	trimmed := bytes.TrimLeftFunc(data, unicode.IsSpace)
+	return bytes.HasPrefix(trimmed, []byte("{"))
+}
@@ -0,0 +1,135 @@
+package exprmigrate_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-extras/godexer"
+	"github.com/go-extras/godexer/internal/exprmigrate"
+)
+
+const govaluateScenario = `# install scenario
+commands:
+  - type: message
+    stepName: first
+    description: hello
+    requires: 'os IN ("debian", "ubuntu")' # distro check
+  - type: foreach
+    stepName: loop
+    iterable: [1, 2]
+    commands:
+      - type: message
+        stepName: nested
+        requires: name =~ '^web'
+`
+
+func TestMigrateScenario(t *testing.T) {
+	t.Run("yaml", func(t *testing.T) {
+		c := qt.New(t)
+		res, err := exprmigrate.MigrateScenario([]byte(govaluateScenario), exprmigrate.Options{AddExperiment: true})
+		c.Assert(err, qt.IsNil)
+		c.Assert(res.Untranslated(), qt.Equals, 0)
+		c.Assert(res.ExperimentAdded, qt.IsTrue)
+		c.Assert(res.Findings, qt.HasLen, 2)
+		c.Assert(res.Findings[0].Path, qt.Equals, "commands[0]")
+		c.Assert(res.Findings[1].Path, qt.Equals, "commands[1].commands[0]")
+		c.Assert(string(res.Output), qt.Equals, `# install scenario
+meta:
+  experiments:
+    - expr
+commands:
+  - type: message
+    stepName: first
+    description: hello
+    requires: 'os in ["debian", "ubuntu"]' # distro check
+  - type: foreach
+    stepName: loop
+    iterable: [1, 2]
+    commands:
+      - type: message
+        stepName: nested
+        requires: name matches "^web"
+`)
+
+		// the migrated scenario must load and behave the same way
+		ex, err := godexer.NewWithScenario(string(res.Output))
+		c.Assert(err, qt.IsNil)
+		vars := map[string]any{"os": "ubuntu", "name": "db"}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["__step:first:skipped"], qt.IsFalse)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		c := qt.New(t)
+		res, err := exprmigrate.MigrateScenario(
+			[]byte(`{"commands":[{"type":"message","requires":"a =~ 'x'"}]}`),
+			exprmigrate.Options{},
+		)
+		c.Assert(err, qt.IsNil)
+		c.Assert(res.ExperimentAdded, qt.IsFalse)
+		c.Assert(string(res.Output), qt.Equals, `{
+  "commands": [
+    {
+      "requires": "a matches \"x\"",
+      "type": "message"
+    }
+  ]
+}
+`)
+	})
+
+	t.Run("untranslatable", func(t *testing.T) {
+		c := qt.New(t)
+		res, err := exprmigrate.MigrateScenario([]byte(`commands:
+  - type: message
+    requires: a & 1 == 1
+  - type: message
+    requires: a == 1
+`), exprmigrate.Options{AddExperiment: true})
+		c.Assert(err, qt.IsNil)
+		c.Assert(res.Untranslated(), qt.Equals, 1)
+		c.Assert(res.ExperimentAdded, qt.IsFalse)
+		c.Assert(res.Findings[0].Fatal(), qt.IsTrue)
+		c.Assert(res.Findings[0].Line, qt.Equals, 3)
+		c.Assert(string(res.Output), qt.Equals, `commands:
+  - type: message
+    requires: a & 1 == 1
+  - type: message
+    requires: a == 1
+`)
+	})
+
+	t.Run("already_expr", func(t *testing.T) {
+		c := qt.New(t)
+		src := `meta:
+  experiments: [expr]
+commands:
+  - type: message
+    requires: a matches "x"
+`
+		res, err := exprmigrate.MigrateScenario([]byte(src), exprmigrate.Options{AddExperiment: true})
+		c.Assert(err, qt.IsNil)
+		c.Assert(res.AlreadyExpr, qt.IsTrue)
+		c.Assert(string(res.Output), qt.Equals, src)
+	})
+
+	t.Run("replaces_opt_out", func(t *testing.T) {
+		c := qt.New(t)
+		res, err := exprmigrate.MigrateScenario([]byte(`meta:
+  experiments: [-expr]
+commands: []
+`), exprmigrate.Options{AddExperiment: true})
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(res.Output), qt.Equals, `meta:
+  experiments: [expr]
+commands: []
+`)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		c := qt.New(t)
+		_, err := exprmigrate.MigrateScenario([]byte(`- a`), exprmigrate.Options{})
+		c.Assert(err, qt.ErrorMatches, "scenario must be a mapping.*")
+	})
+}
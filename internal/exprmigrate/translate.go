@@ -0,0 +1,304 @@
+// Package exprmigrate rewrites govaluate `requires` expressions into the
+// expr-language syntax used by the `expr` experiment.
+package exprmigrate
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Issue describes a construct that could not be translated faithfully.
+type Issue struct {
+	// Fatal is true when the expression could not be translated at all.
+	Fatal   bool
+	Message string
+}
+
+func (i Issue) String() string {
+	if i.Fatal {
+		return "error: " + i.Message
+	}
+	return "warning: " + i.Message
+}
+
+type tokenKind int
+
+const (
+	tokNumber tokenKind = iota
+	tokString
+	tokIdent
+	tokEscapedIdent
+	tokFunction
+	tokOperator
+	tokComma
+	tokOpen
+	tokClose
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+var identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// operators are matched longest first.
+var operators = []string{
+	"**", "==", "!=", ">=", "<=", "=~", "!~", "&&", "||", "??", "<<", ">>",
+	">", "<", "+", "-", "*", "/", "%", "&", "|", "^", "~", "!", "?", ":",
+}
+
+// Translate converts a govaluate expression into an equivalent expr-language
+// expression. Issues with Fatal set mean that the returned string must not be
+// used.
+func Translate(src string) (string, []Issue) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return src, []Issue{{Fatal: true, Message: err.Error()}}
+	}
+
+	var (
+		out     strings.Builder
+		issues  []Issue
+		inLists []int // parenthesis depths at which an `in (...)` list was opened
+		depth   int
+	)
+
+	for i, tok := range tokens {
+		if i > 0 && needsSpace(tokens[i-1], tok) {
+			out.WriteByte(' ')
+		}
+
+		switch tok.kind {
+		case tokNumber, tokComma:
+			out.WriteString(tok.value)
+		case tokString:
+			if _, ok := tryParseTime(tok.value); ok {
+				issues = append(issues, Issue{Message: fmt.Sprintf("string %q is compared as a date by govaluate, but as a string by expr", tok.value)})
+			}
+			out.WriteString(strconv.Quote(tok.value))
+		case tokIdent:
+			if strings.Contains(tok.value, ".") {
+				issues = append(issues, Issue{Message: fmt.Sprintf("%q is looked up as a variable with that exact name by govaluate, but as nested field access by expr", tok.value)})
+			}
+			out.WriteString(tok.value)
+		case tokFunction:
+			out.WriteString(tok.value)
+		case tokEscapedIdent:
+			if identRe.MatchString(tok.value) {
+				out.WriteString(tok.value)
+			} else {
+				out.WriteString("$env[" + strconv.Quote(tok.value) + "]")
+			}
+		case tokOpen:
+			depth++
+			if i > 0 && tokens[i-1].kind == tokOperator && tokens[i-1].value == "in" {
+				inLists = append(inLists, depth)
+				out.WriteByte('[')
+				continue
+			}
+			out.WriteByte('(')
+		case tokClose:
+			if n := len(inLists); n > 0 && inLists[n-1] == depth {
+				inLists = inLists[:n-1]
+				out.WriteByte(']')
+			} else {
+				out.WriteByte(')')
+			}
+			depth--
+		case tokOperator:
+			translated, issue := translateOperator(tok.value)
+			if issue != nil {
+				issues = append(issues, *issue)
+			}
+			out.WriteString(translated)
+		}
+	}
+
+	if hasFatal(issues) {
+		return src, issues
+	}
+	return out.String(), issues
+}
+
+func hasFatal(issues []Issue) bool {
+	for _, issue := range issues {
+		if issue.Fatal {
+			return true
+		}
+	}
+	return false
+}
+
+func translateOperator(op string) (string, *Issue) {
+	switch op {
+	case "=~":
+		return "matches", nil
+	case "!~":
+		return "not matches", nil
+	case "^", "&", "|", "~", "<<", ">>":
+		return op, &Issue{Fatal: true, Message: fmt.Sprintf("bitwise operator %q has no expr equivalent", op)}
+	default:
+		return op, nil
+	}
+}
+
+func needsSpace(prev, cur token) bool {
+	switch {
+	case cur.kind == tokComma, cur.kind == tokClose:
+		return false
+	case prev.kind == tokOpen, prev.kind == tokFunction:
+		return false
+	case cur.kind == tokOpen && prev.kind != tokOperator && prev.kind != tokComma:
+		return false
+	case prev.kind == tokOperator && isPrefixOperator(prev.value) && (cur.kind != tokOperator):
+		return false
+	default:
+		return true
+	}
+}
+
+func isPrefixOperator(op string) bool {
+	return op == "!"
+}
+
+func tokenize(src string) ([]token, error) {
+	runes := []rune(src)
+	var tokens []token
+
+	for i := 0; i < len(runes); {
+		ch := runes[i]
+		switch {
+		case unicode.IsSpace(ch):
+			i++
+		case ch == ',':
+			tokens = append(tokens, token{kind: tokComma, value: ","})
+			i++
+		case ch == '(':
+			tokens = append(tokens, token{kind: tokOpen, value: "("})
+			i++
+		case ch == ')':
+			tokens = append(tokens, token{kind: tokClose, value: ")"})
+			i++
+		case ch == '[':
+			end := indexRune(runes, i+1, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed parameter bracket at position %d", i)
+			}
+			tokens = append(tokens, token{kind: tokEscapedIdent, value: string(runes[i+1 : end])})
+			i = end + 1
+		case ch == '\'' || ch == '"':
+			value, next, err := readString(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokString, value: value})
+			i = next
+		case unicode.IsDigit(ch):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'x' || isHex(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, value: string(runes[start:i])})
+		case unicode.IsLetter(ch):
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, identToken(string(runes[start:i]), nextNonSpace(runes, i) == '('))
+		default:
+			op := matchOperator(runes[i:])
+			if op == "" {
+				return nil, fmt.Errorf("invalid token %q at position %d", string(ch), i)
+			}
+			tokens = append(tokens, token{kind: tokOperator, value: op})
+			i += len([]rune(op))
+		}
+	}
+
+	return tokens, nil
+}
+
+func identToken(word string, isCall bool) token {
+	switch {
+	case word == "in" || word == "IN":
+		return token{kind: tokOperator, value: "in"}
+	case word == "true" || word == "false":
+		return token{kind: tokIdent, value: word}
+	case isCall:
+		return token{kind: tokFunction, value: word}
+	default:
+		return token{kind: tokIdent, value: word}
+	}
+}
+
+func matchOperator(runes []rune) string {
+	s := string(runes)
+	for _, op := range operators {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func readString(runes []rune, start int) (value string, next int, err error) {
+	quote := runes[start]
+	var sb strings.Builder
+	for i := start + 1; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+				sb.WriteRune(runes[i])
+			}
+		case quote:
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteRune(runes[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unclosed string literal at position %d", start)
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+func nextNonSpace(runes []rune, from int) rune {
+	for i := from; i < len(runes); i++ {
+		if !unicode.IsSpace(runes[i]) {
+			return runes[i]
+		}
+	}
+	return 0
+}
+
+func isHex(r rune) bool {
+	return (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
+
+// tryParseTime mirrors the date formats that govaluate recognises in string
+// literals.
+func tryParseTime(s string) (time.Time, bool) {
+	formats := []string{
+		time.ANSIC, time.UnixDate, time.RubyDate, time.Kitchen, time.RFC3339, time.RFC3339Nano,
+		"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05", "2006-01-02T15Z0700", "2006-01-02T15:04Z0700",
+		"2006-01-02T15:04:05Z0700", "2006-01-02T15:04:05.999999999Z0700",
+	}
+	for _, format := range formats {
+		if t, err := time.ParseInLocation(format, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
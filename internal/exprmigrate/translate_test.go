@@ -0,0 +1,116 @@
+package exprmigrate_test
+
+import (
+	"testing"
+
+	"github.com/expr-lang/expr"
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/Knetic/govaluate.v2"
+
+	"github.com/go-extras/godexer/internal/exprmigrate"
+)
+
+func TestTranslate(t *testing.T) {
+	testcases := []struct {
+		name string
+		src  string
+		want string
+		warn bool
+	}{
+		{name: "comparison", src: `a > 1 && b == 'x'`, want: `a > 1 && b == "x"`},
+		{name: "negation", src: `!(a == 1) || !ok`, want: `!(a == 1) || !ok`},
+		{name: "function", src: `strlen(name) > 0`, want: `strlen(name) > 0`},
+		{name: "function_args", src: `version_gte(v, "1.2")`, want: `version_gte(v, "1.2")`},
+		{name: "regex", src: `name =~ '^web'`, want: `name matches "^web"`},
+		{name: "not_regex", src: `name !~ '^web'`, want: `name not matches "^web"`},
+		{name: "in", src: `os IN ('debian', 'ubuntu')`, want: `os in ["debian", "ubuntu"]`},
+		{name: "in_lowercase", src: `(os in ('debian')) && x`, want: `(os in ["debian"]) && x`},
+		{name: "escaped_ident", src: `[my-var] == 1`, want: `$env["my-var"] == 1`},
+		{name: "escaped_plain_ident", src: `[myvar] == 1`, want: `myvar == 1`},
+		{name: "ternary", src: `a ? 1 : 2`, want: `a ? 1 : 2`},
+		{name: "coalesce", src: `a ?? 'x'`, want: `a ?? "x"`},
+		{name: "arithmetic", src: `(a + 2) * 3 ** 2 - -b`, want: `(a + 2) * 3 ** 2 - - b`},
+		{name: "escaped_quote", src: `a == 'it\'s'`, want: `a == "it's"`},
+		{name: "date_literal", src: `d > '2024-01-01'`, want: `d > "2024-01-01"`, warn: true},
+		{name: "dotted_ident", src: `a.b == 1`, want: `a.b == 1`, warn: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			got, issues := exprmigrate.Translate(tc.src)
+			c.Assert(got, qt.Equals, tc.want)
+			if tc.warn {
+				c.Assert(issues, qt.HasLen, 1)
+				c.Assert(issues[0].Fatal, qt.IsFalse)
+			} else {
+				c.Assert(issues, qt.HasLen, 0)
+			}
+		})
+	}
+}
+
+func TestTranslate_Untranslatable(t *testing.T) {
+	testcases := []struct {
+		name string
+		src  string
+	}{
+		{name: "bitwise_and", src: `a & 1 == 1`},
+		{name: "bitwise_xor", src: `a ^ b`},
+		{name: "shift", src: `a << 2`},
+		{name: "unclosed_string", src: `a == 'x`},
+		{name: "unclosed_bracket", src: `[a == 1`},
+		{name: "invalid_token", src: `a == #`},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			got, issues := exprmigrate.Translate(tc.src)
+			c.Assert(got, qt.Equals, tc.src)
+			c.Assert(issues, qt.Not(qt.HasLen), 0)
+			c.Assert(issues[0].Fatal, qt.IsTrue)
+		})
+	}
+}
+
+// TestTranslate_Equivalence checks that the original and the translated
+// expressions evaluate to the same result.
+func TestTranslate_Equivalence(t *testing.T) {
+	params := map[string]any{
+		"a":      float64(3),
+		"b":      float64(2),
+		"name":   "web-01",
+		"os":     "ubuntu",
+		"ok":     true,
+		"my-var": float64(1),
+	}
+
+	sources := []string{
+		`a > 1 && b == 2`,
+		`!(a == 1) || !ok`,
+		`name =~ '^web'`,
+		`name !~ '^db'`,
+		`os IN ('debian', 'ubuntu')`,
+		`[my-var] == 1`,
+		`(a + 2) * 3 ** 2 - -b == 47`,
+		`a > b ? ok : !ok`,
+	}
+
+	for _, src := range sources {
+		t.Run(src, func(t *testing.T) {
+			c := qt.New(t)
+
+			gexpr, err := govaluate.NewEvaluableExpression(src)
+			c.Assert(err, qt.IsNil)
+			want, err := gexpr.Evaluate(params)
+			c.Assert(err, qt.IsNil)
+
+			translated, issues := exprmigrate.Translate(src)
+			c.Assert(issues, qt.HasLen, 0)
+			got, err := expr.Eval(translated, params)
+			c.Assert(err, qt.IsNil)
+			c.Assert(got, qt.Equals, want)
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/go-extras/errors"
 	"golang.org/x/crypto/ssh"
@@ -127,10 +129,35 @@ func NewServer(signer ssh.Signer, handlerFunc HandlerFunc, handlerChFunc Handler
 }
 
 type Server struct {
+	// AcceptEnv makes the server accept env requests, like sshd with a
+	// matching AcceptEnv setting. They are rejected by default.
+	AcceptEnv bool
+
 	listener      net.Listener
 	config        *ssh.ServerConfig
 	handlerFunc   HandlerFunc
 	handlerChFunc HandlerChFunc
+	ptyRequests   atomic.Int32
+
+	mu  sync.Mutex
+	env []string
+}
+
+type msgEnv struct {
+	Name  string
+	Value string
+}
+
+// PtyRequests returns the number of pty-req requests received so far.
+func (s *Server) PtyRequests() int {
+	return int(s.ptyRequests.Load())
+}
+
+// Env returns the variables of the accepted env requests, as KEY=value.
+func (s *Server) Env() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.env...)
 }
 
 func (s *Server) Start() {
@@ -206,7 +233,19 @@ func (s *Server) handleChannel(newChannel ssh.NewChannel) {
 					ch.Close()
 				}
 			case "pty-req":
+				s.ptyRequests.Add(1)
 				req.Reply(true, nil)
+			case "env":
+				var msg msgEnv
+				if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
+					panic(fmt.Sprintf("Could Unmarshal env (%+v, %+v)", req.Payload, err))
+				}
+				if s.AcceptEnv {
+					s.mu.Lock()
+					s.env = append(s.env, msg.Name+"="+msg.Value)
+					s.mu.Unlock()
+				}
+				req.Reply(s.AcceptEnv, nil)
 			default:
 				panic(req.Type)
 			}
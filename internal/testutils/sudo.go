@@ -0,0 +1,75 @@
+package testutils
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// fakeSudo stands in for sudo. It asks for the password in FAKE_SUDO_PASSWORD,
+// on stderr and stdin with -S and on the terminal (stdout and stdin) without,
+// and runs the command with FAKE_SUDO_USER set to the target user, as that
+// user if FAKE_SUDO_SWITCH is set and as the current user otherwise. With
+// FAKE_SUDO_PASSWORD empty it behaves like NOPASSWD and doesn't touch stdin.
+const fakeSudo = `#!/bin/sh
+user=root prompt='Password: ' stdin= nonint=
+while [ "$1" != -- ]; do
+	case $1 in
+	-S) stdin=1 ;;
+	-n) nonint=1 ;;
+	-u) user=$2; shift ;;
+	-p) prompt=$2; shift ;;
+	esac
+	shift
+done
+shift
+if [ -n "$FAKE_SUDO_PASSWORD" ]; then
+	if [ -n "$nonint" ]; then echo "sudo: a password is required" >&2; exit 1; fi
+	tries=0
+	while :; do
+		if [ -n "$stdin" ]; then printf %s "$prompt" >&2; else printf %s "$prompt"; fi
+		IFS= read -r pw || exit 1
+		[ "$pw" = "$FAKE_SUDO_PASSWORD" ] && break
+		echo "Sorry, try again." >&2
+		tries=$((tries + 1))
+		[ $tries -lt 3 ] || exit 1
+	done
+fi
+if [ -n "$FAKE_SUDO_SWITCH" ]; then
+	exec setpriv --reuid "$user" --regid "$(id -g "$user")" --init-groups env FAKE_SUDO_USER="$user" "$@"
+fi
+FAKE_SUDO_USER=$user exec "$@"
+`
+
+// FakeSudo puts a sudo stand-in first in PATH for the rest of the test. With
+// an empty password, sudo doesn't ask for one.
+func FakeSudo(t testing.TB, password string) {
+	t.Helper()
+	installFakeSudo(t, password, "")
+}
+
+// FakeSudoSwitch is FakeSudo switching to the target user, which needs root
+// and setpriv, so it skips the test without them.
+func FakeSudoSwitch(t testing.TB, password string) {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("switching users needs root")
+	}
+	if _, err := exec.LookPath("setpriv"); err != nil {
+		t.Skip("switching users needs setpriv")
+	}
+	installFakeSudo(t, password, "1")
+}
+
+func installFakeSudo(t testing.TB, password, switchUser string) {
+	t.Helper()
+	dir := t.TempDir()
+	//nolint:gosec // the stand-in has to be executable
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte(fakeSudo), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_SUDO_PASSWORD", password)
+	t.Setenv("FAKE_SUDO_SWITCH", switchUser)
+}
@@ -0,0 +1,285 @@
+// Package network provides evaluator and template functions for working with
+// IP addresses, CIDR ranges, host names and local ports.
+package network
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/go-extras/errors"
+
+	"github.com/go-extras/godexer"
+)
+
+var (
+	ErrArgMustBeString = errors.New("argument must be string")
+	ErrArgMustBeNumber = errors.New("argument must be number")
+	ErrInvalidIP       = errors.New("invalid IP address")
+	ErrInvalidCIDR     = errors.New("invalid CIDR")
+)
+
+// Resolver looks up the addresses of a host. *net.Resolver implements it.
+type Resolver = godexer.Resolver
+
+type config struct {
+	resolver Resolver
+}
+
+// Option configures WithNetworkFuncs.
+type Option func(*config)
+
+// WithResolver replaces the resolver used by `resolve_host`, e.g. with a stub
+// in tests. By default `resolve_host` uses the executor's resolver (see
+// godexer.WithResolver).
+func WithResolver(r Resolver) Option {
+	return func(cfg *config) {
+		cfg.resolver = r
+	}
+}
+
+// WithNetworkFuncs registers value evaluator functions. The same functions are
+// registered as template functions on the executor.
+//
+// The following functions are available:
+// - `ip_valid(ip string) (bool, error)`
+// - `ip_version(ip string) (number, error)` - returns 4 or 6
+// - `cidr_contains(cidr, ip string) (bool, error)`
+// - `cidr_host(cidr string, n number) (string, error)` - returns the n-th address of the range; negative n counts from the end
+// - `cidr_netmask(cidr string) (string, error)` - returns the netmask, e.g. "255.255.255.0"
+// - `resolve_host(host string) (string, error)` - returns the first address the host resolves to
+// - `port_free(port number) (bool, error)` - returns true if the local TCP port can be bound
+func WithNetworkFuncs(opts ...Option) func(*godexer.Executor) {
+	cfg := &config{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return func(ex *godexer.Executor) {
+		resolver := cfg.resolver
+		if resolver == nil {
+			resolver = ex
+		}
+		ex.RegisterValueFuncs(templateFuncs(resolver))
+
+		ex.RegisterEvaluatorFunction("ip_valid", func(args ...any) (any, error) {
+			if len(args) != 1 {
+				return false, errors.New("invalid number of arguments")
+			}
+			return ipValid(args[0])
+		})
+
+		ex.RegisterEvaluatorFunction("ip_version", func(args ...any) (any, error) {
+			if len(args) != 1 {
+				return float64(0), errors.New("invalid number of arguments")
+			}
+			v, err := ipVersion(args[0])
+			return float64(v), err
+		})
+
+		ex.RegisterEvaluatorFunction("cidr_contains", func(args ...any) (any, error) {
+			if len(args) != 2 {
+				return false, errors.New("invalid number of arguments")
+			}
+			return cidrContains(args[0], args[1])
+		})
+
+		ex.RegisterEvaluatorFunction("cidr_host", func(args ...any) (any, error) {
+			if len(args) != 2 {
+				return "", errors.New("invalid number of arguments")
+			}
+			return cidrHost(args[0], args[1])
+		})
+
+		ex.RegisterEvaluatorFunction("cidr_netmask", func(args ...any) (any, error) {
+			if len(args) != 1 {
+				return "", errors.New("invalid number of arguments")
+			}
+			return cidrNetmask(args[0])
+		})
+
+		ex.RegisterEvaluatorFunction("resolve_host", func(args ...any) (any, error) {
+			if len(args) != 1 {
+				return "", errors.New("invalid number of arguments")
+			}
+			return resolveHost(resolver, args[0])
+		})
+
+		ex.RegisterEvaluatorFunction("port_free", func(args ...any) (any, error) {
+			if len(args) != 1 {
+				return false, errors.New("invalid number of arguments")
+			}
+			return portFree(args[0])
+		})
+	}
+}
+
+func templateFuncs(resolver Resolver) map[string]any {
+	return map[string]any{
+		"ip_valid":      ipValid,
+		"ip_version":    ipVersion,
+		"cidr_contains": cidrContains,
+		"cidr_host":     cidrHost,
+		"cidr_netmask":  cidrNetmask,
+		"resolve_host": func(host any) (string, error) {
+			return resolveHost(resolver, host)
+		},
+		"port_free": portFree,
+	}
+}
+
+func stringArg(arg any) (string, error) {
+	s, ok := arg.(string)
+	if !ok {
+		return "", ErrArgMustBeString
+	}
+	return s, nil
+}
+
+// intArg accepts the number types produced by govaluate, expr and templates.
+func intArg(arg any) (int, error) {
+	switch n := arg.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case float64:
+		if n != float64(int(n)) {
+			return 0, errors.Wrap(ErrArgMustBeNumber, "argument must be a whole number")
+		}
+		return int(n), nil
+	case string:
+		i, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, errors.Wrap(errors.WithEquivalents(err, ErrArgMustBeNumber), "argument must be a number")
+		}
+		return i, nil
+	default:
+		return 0, ErrArgMustBeNumber
+	}
+}
+
+func parseIP(arg any) (netip.Addr, error) {
+	s, err := stringArg(arg)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, errors.Wrap(errors.WithEquivalents(err, ErrInvalidIP), "argument must be a valid IP address")
+	}
+	return addr, nil
+}
+
+func parseCIDR(arg any) (netip.Prefix, error) {
+	s, err := stringArg(arg)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, errors.Wrap(errors.WithEquivalents(err, ErrInvalidCIDR), "argument must be a valid CIDR")
+	}
+	return prefix.Masked(), nil
+}
+
+func ipValid(arg any) (bool, error) {
+	s, err := stringArg(arg)
+	if err != nil {
+		return false, err
+	}
+	_, err = netip.ParseAddr(s)
+	return err == nil, nil
+}
+
+func ipVersion(arg any) (int, error) {
+	addr, err := parseIP(arg)
+	if err != nil {
+		return 0, err
+	}
+	if addr.Unmap().Is4() {
+		return 4, nil
+	}
+	return 6, nil
+}
+
+func cidrContains(cidr, ip any) (bool, error) {
+	prefix, err := parseCIDR(cidr)
+	if err != nil {
+		return false, err
+	}
+	addr, err := parseIP(ip)
+	if err != nil {
+		return false, err
+	}
+	return prefix.Contains(addr.Unmap()), nil
+}
+
+func cidrHost(cidr, n any) (string, error) {
+	prefix, err := parseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	num, err := intArg(n)
+	if err != nil {
+		return "", err
+	}
+
+	bits := prefix.Addr().BitLen()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-prefix.Bits()))
+	offset := big.NewInt(int64(num))
+	if num < 0 {
+		offset.Add(offset, size)
+	}
+	if offset.Sign() < 0 || offset.Cmp(size) >= 0 {
+		return "", errors.Errorf("prefix %s has no host number %d", prefix, num)
+	}
+
+	base := new(big.Int).SetBytes(prefix.Addr().AsSlice())
+	raw := base.Add(base, offset).FillBytes(make([]byte, bits/8))
+	addr, _ := netip.AddrFromSlice(raw)
+	return addr.String(), nil
+}
+
+func cidrNetmask(cidr any) (string, error) {
+	prefix, err := parseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	mask := net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen())
+	return net.IP(mask).String(), nil
+}
+
+func resolveHost(r Resolver, host any) (string, error) {
+	s, err := stringArg(host)
+	if err != nil {
+		return "", err
+	}
+	addrs, err := r.LookupHost(context.Background(), s)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve %q", s)
+	}
+	if len(addrs) == 0 {
+		return "", errors.Errorf("no addresses found for %q", s)
+	}
+	return addrs[0], nil
+}
+
+func portFree(port any) (bool, error) {
+	p, err := intArg(port)
+	if err != nil {
+		return false, err
+	}
+	if p < 1 || p > 65535 {
+		return false, errors.Errorf("port %d is out of range", p)
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", p))
+	if err != nil {
+		return false, nil
+	}
+	_ = l.Close()
+	return true, nil
+}
@@ -0,0 +1,158 @@
+package network_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-extras/godexer"
+	"github.com/go-extras/godexer/network"
+)
+
+type stubResolver map[string][]string
+
+func (r stubResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	addrs, ok := r[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return addrs, nil
+}
+
+func newExecutor(c *qt.C, scenario string) *godexer.Executor {
+	ex, err := godexer.NewWithScenario(scenario, network.WithNetworkFuncs(
+		network.WithResolver(stubResolver{"db.internal": {"10.0.0.5", "10.0.0.6"}}),
+	))
+	c.Assert(err, qt.IsNil)
+	return ex
+}
+
+func TestWithNetworkFuncs(t *testing.T) {
+	t.Run("requires", func(t *testing.T) {
+		testcases := []struct {
+			requires string
+			want     bool
+		}{
+			{requires: `ip_valid("10.0.0.1")`, want: true},
+			{requires: `ip_valid("fe80::1")`, want: true},
+			{requires: `ip_valid("10.0.0.300")`, want: false},
+			{requires: `ip_version("10.0.0.1") == 4`, want: true},
+			{requires: `ip_version("2001:db8::1") == 6`, want: true},
+			{requires: `cidr_contains("10.0.0.0/24", "10.0.0.42")`, want: true},
+			{requires: `cidr_contains("10.0.0.0/24", "10.0.1.1")`, want: false},
+			{requires: `cidr_contains(subnet, ip)`, want: true},
+			{requires: `cidr_host("10.0.0.0/24", 1) == "10.0.0.1"`, want: true},
+			{requires: `cidr_host("10.0.0.7/24", -2) == "10.0.0.254"`, want: true},
+			{requires: `cidr_host("2001:db8::/64", 16) == "2001:db8::10"`, want: true},
+			{requires: `cidr_netmask("10.0.0.0/20") == "255.255.240.0"`, want: true},
+			{requires: `resolve_host("db.internal") == "10.0.0.5"`, want: true},
+		}
+
+		for _, tc := range testcases {
+			t.Run(tc.requires, func(t *testing.T) {
+				c := qt.New(t)
+				ex := newExecutor(c, `commands:
+  - type: message
+    stepName: check
+    requires: '`+tc.requires+`'
+`)
+				vars := map[string]any{"subnet": "192.168.1.0/24", "ip": "192.168.1.10"}
+				c.Assert(ex.Execute(vars), qt.IsNil)
+				c.Assert(vars["__step:check:skipped"], qt.Equals, !tc.want)
+			})
+		}
+	})
+
+	t.Run("templates", func(t *testing.T) {
+		c := qt.New(t)
+		ex := newExecutor(c, `commands:
+  - type: variable
+    stepName: gateway
+    variable: gateway
+    value: '{{ cidr_host .subnet 1 }}/{{ cidr_netmask .subnet }} v{{ ip_version .ip }} {{ resolve_host "db.internal" }}'
+`)
+		vars := map[string]any{"subnet": "172.16.0.0/16", "ip": "172.16.3.4"}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["gateway"], qt.Equals, "172.16.0.1/255.255.0.0 v4 10.0.0.5")
+	})
+
+	t.Run("port_free", func(t *testing.T) {
+		c := qt.New(t)
+		l, err := net.Listen("tcp", ":0")
+		c.Assert(err, qt.IsNil)
+		defer l.Close()
+		port := l.Addr().(*net.TCPAddr).Port
+
+		ex := newExecutor(c, fmt.Sprintf(`commands:
+  - type: message
+    stepName: check
+    requires: 'port_free(%d)'
+`, port))
+		vars := map[string]any{}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["__step:check:skipped"], qt.IsTrue)
+	})
+
+	t.Run("executor_resolver", func(t *testing.T) {
+		// each executor resolves with its own resolver, in foreach steps too;
+		// any other resolver fails on the host of the other executor
+		for i, addr := range []string{"10.0.0.5", "10.0.0.6"} {
+			c := qt.New(t)
+			host := fmt.Sprintf("db%d.invalid", i)
+			ex, err := godexer.NewWithScenario(`commands:
+  - type: variable
+    stepName: check
+    variable: addr
+    value: '{{ resolve_host .host }}'
+    requires: 'resolve_host(host) == "`+addr+`"'
+  - type: foreach
+    stepName: lookup
+    variable: hosts
+    commands:
+      - type: variable
+        stepName: addr
+        variable: addr
+        value: '{{ resolve_host .value }}'
+`, godexer.WithResolver(stubResolver{host: {addr}}), network.WithNetworkFuncs())
+			c.Assert(err, qt.IsNil)
+			vars := map[string]any{"host": host, "hosts": []any{host}}
+			c.Assert(ex.Execute(vars), qt.IsNil)
+			c.Assert(vars["addr"], qt.Equals, addr)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		testcases := []struct {
+			requires string
+			err      error
+		}{
+			{requires: `ip_version("nope") == 4`, err: network.ErrInvalidIP},
+			{requires: `cidr_contains("10.0.0.0", "10.0.0.1")`, err: network.ErrInvalidCIDR},
+			{requires: `cidr_host("10.0.0.0/30", 4) == ""`},
+			{requires: `cidr_host("10.0.0.0/30", 1.5) == ""`, err: network.ErrArgMustBeNumber},
+			{requires: `resolve_host("missing") == ""`},
+			{requires: `port_free(70000)`},
+			{requires: `ip_valid(1)`, err: network.ErrArgMustBeString},
+		}
+
+		for _, tc := range testcases {
+			t.Run(tc.requires, func(t *testing.T) {
+				c := qt.New(t)
+				ex := newExecutor(c, `commands:
+  - type: message
+    stepName: check
+    requires: '`+tc.requires+`'
+`)
+				err := ex.Execute(map[string]any{})
+				c.Assert(err, qt.IsNotNil)
+				if tc.err != nil {
+					c.Assert(err, qt.ErrorIs, tc.err)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,253 @@
+package godexer
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-extras/errors"
+)
+
+// Supported values of OutputOptions.Prefix.
+const (
+	OutputPrefixStep = "step"
+	OutputPrefixHost = "host"
+	OutputPrefixTime = "time"
+)
+
+// OutputOptions configure how command output is written.
+type OutputOptions struct {
+	// Prefix lists what each line is prefixed with, in order: step, host
+	// and/or time.
+	Prefix []string
+	// TimeFormat is the timestamp layout (default time.RFC3339).
+	TimeFormat string
+	// Collapse holds the output back and only writes it if the step fails.
+	Collapse bool
+}
+
+// OutputInfo describes the run whose output is written.
+type OutputInfo struct {
+	// StepName is the step's RunStepName, which includes the foreach
+	// iteration.
+	StepName string
+	// Host is the remote address for SSH commands, empty for local ones.
+	Host string
+	// Options are the step's output options, nil if the step has none.
+	Options *OutputOptions
+	// Clock timestamps the lines; nil means SystemClock.
+	Clock Clock
+}
+
+// OutputStream is where a single run writes its output. Close is called once
+// the run is finished.
+type OutputStream interface {
+	Stdout() io.Writer
+	Stderr() io.Writer
+	Close(failed bool) error
+}
+
+// OutputWriterFactory opens the output stream for a run.
+type OutputWriterFactory func(info OutputInfo) (OutputStream, error)
+
+// OpenOutput opens the output stream for a run through the context's Output
+// factory, or a decorator without options writing to Stdout and Stderr when
+// it is not set.
+func (ectx *ExecutorContext) OpenOutput(info OutputInfo) (OutputStream, error) {
+	if info.Clock == nil {
+		info.Clock = ectx.Clock
+	}
+	factory := ectx.Output
+	if factory == nil {
+		factory = NewOutputDecorator(ectx.Stdout, ectx.Stderr, OutputOptions{})
+	}
+	return factory(info)
+}
+
+// NewOutputDecorator returns a factory writing to stdout and stderr decorated
+// according to opts. Steps with their own output options use those instead.
+func NewOutputDecorator(stdout, stderr io.Writer, opts OutputOptions) OutputWriterFactory {
+	return func(info OutputInfo) (OutputStream, error) {
+		o := opts
+		if info.Options != nil {
+			o = *info.Options
+		}
+
+		prefix, err := outputPrefix(o, info)
+		if err != nil {
+			return nil, err
+		}
+
+		s := &decoratedOutput{}
+		s.stdout = newLineWriter(&s.mu, stdout, prefix)
+		s.stderr = newLineWriter(&s.mu, stderr, prefix)
+		if o.Collapse {
+			s.held = &heldOutput{}
+		}
+		return s, nil
+	}
+}
+
+// outputPrefix returns a function rendering the line prefix, or nil if lines
+// are written as is.
+func outputPrefix(o OutputOptions, info OutputInfo) (func() string, error) {
+	if len(o.Prefix) == 0 {
+		return nil, nil
+	}
+	timeFormat := stringDef(o.TimeFormat, time.RFC3339)
+	clock := info.Clock
+	if clock == nil {
+		clock = SystemClock{}
+	}
+
+	for _, p := range o.Prefix {
+		switch p {
+		case OutputPrefixStep, OutputPrefixHost, OutputPrefixTime:
+		default:
+			return nil, errors.Errorf("unsupported output prefix %q, must be one of step, host, time", p)
+		}
+	}
+
+	return func() string {
+		var sb strings.Builder
+		for _, p := range o.Prefix {
+			switch p {
+			case OutputPrefixStep:
+				sb.WriteString("[" + info.StepName + "] ")
+			case OutputPrefixHost:
+				if info.Host != "" {
+					sb.WriteString("[" + info.Host + "] ")
+				}
+			case OutputPrefixTime:
+				sb.WriteString(clock.Now().Format(timeFormat) + " ")
+			}
+		}
+		return sb.String()
+	}, nil
+}
+
+type decoratedOutput struct {
+	mu     sync.Mutex
+	stdout *lineWriter
+	stderr *lineWriter
+	held   *heldOutput
+}
+
+func (s *decoratedOutput) Stdout() io.Writer {
+	if s.held != nil {
+		return s.held.writer(s.stdout)
+	}
+	return s.stdout
+}
+
+func (s *decoratedOutput) Stderr() io.Writer {
+	if s.held != nil {
+		return s.held.writer(s.stderr)
+	}
+	return s.stderr
+}
+
+func (s *decoratedOutput) Close(failed bool) error {
+	if s.held != nil && failed {
+		if err := s.held.replay(); err != nil {
+			return err
+		}
+	}
+	if err := s.stdout.Flush(); err != nil {
+		return err
+	}
+	return s.stderr.Flush()
+}
+
+// lineWriter writes whole lines to w, each preceded by prefix. Output of the
+// streams sharing mu is never interleaved within a line.
+type lineWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix func() string
+	buf    []byte
+}
+
+func newLineWriter(mu *sync.Mutex, w io.Writer, prefix func() string) *lineWriter {
+	return &lineWriter{mu: mu, w: w, prefix: prefix}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.prefix == nil {
+		return w.w.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := w.writeLine(w.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes a trailing incomplete line, terminating it.
+func (w *lineWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := append(w.buf, '\n')
+	w.buf = nil
+	return w.writeLine(line)
+}
+
+func (w *lineWriter) writeLine(line []byte) error {
+	_, err := io.WriteString(w.w, w.prefix()+string(line))
+	return err
+}
+
+// heldOutput keeps the output of both streams, in order, until it is replayed.
+type heldOutput struct {
+	mu     sync.Mutex
+	chunks []heldChunk
+}
+
+type heldChunk struct {
+	w    io.Writer
+	data []byte
+}
+
+func (h *heldOutput) writer(w io.Writer) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.chunks = append(h.chunks, heldChunk{w: w, data: bytes.Clone(p)})
+		return len(p), nil
+	})
+}
+
+func (h *heldOutput) replay() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, c := range h.chunks {
+		if _, err := c.w.Write(c.data); err != nil {
+			return err
+		}
+	}
+	h.chunks = nil
+	return nil
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
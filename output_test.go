@@ -0,0 +1,137 @@
+package godexer_test
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-extras/godexer"
+	"github.com/go-extras/godexer/godexertest"
+	"github.com/go-extras/godexer/internal/logger"
+)
+
+func TestOutputDecorator(t *testing.T) {
+	clock := godexertest.NewClock(time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC))
+
+	write := func(c *qt.C, opts godexer.OutputOptions, info godexer.OutputInfo, failed bool) (string, string) {
+		var stdout, stderr bytes.Buffer
+		out, err := godexer.NewOutputDecorator(&stdout, &stderr, opts)(info)
+		c.Assert(err, qt.IsNil)
+		_, _ = io.WriteString(out.Stdout(), "first\nsec")
+		_, _ = io.WriteString(out.Stderr(), "warning\n")
+		_, _ = io.WriteString(out.Stdout(), "ond\nunterminated")
+		c.Assert(out.Close(failed), qt.IsNil)
+		return stdout.String(), stderr.String()
+	}
+
+	t.Run("passthrough", func(t *testing.T) {
+		c := qt.New(t)
+		stdout, stderr := write(c, godexer.OutputOptions{}, godexer.OutputInfo{StepName: "build"}, false)
+		c.Assert(stdout, qt.Equals, "first\nsecond\nunterminated")
+		c.Assert(stderr, qt.Equals, "warning\n")
+	})
+
+	t.Run("prefix", func(t *testing.T) {
+		c := qt.New(t)
+		opts := godexer.OutputOptions{Prefix: []string{"time", "host", "step"}, TimeFormat: time.TimeOnly}
+		stdout, stderr := write(c, opts, godexer.OutputInfo{StepName: "build", Host: "10.0.0.1:22", Clock: clock}, false)
+		c.Assert(stdout, qt.Equals, "10:30:00 [10.0.0.1:22] [build] first\n"+
+			"10:30:00 [10.0.0.1:22] [build] second\n"+
+			"10:30:00 [10.0.0.1:22] [build] unterminated\n")
+		c.Assert(stderr, qt.Equals, "10:30:00 [10.0.0.1:22] [build] warning\n")
+	})
+
+	t.Run("local_host_omitted", func(t *testing.T) {
+		c := qt.New(t)
+		opts := godexer.OutputOptions{Prefix: []string{"host", "step"}}
+		_, stderr := write(c, opts, godexer.OutputInfo{StepName: "build"}, false)
+		c.Assert(stderr, qt.Equals, "[build] warning\n")
+	})
+
+	t.Run("step_options_override", func(t *testing.T) {
+		c := qt.New(t)
+		info := godexer.OutputInfo{StepName: "build", Options: &godexer.OutputOptions{Prefix: []string{"step"}}}
+		_, stderr := write(c, godexer.OutputOptions{Collapse: true}, info, false)
+		c.Assert(stderr, qt.Equals, "[build] warning\n")
+	})
+
+	t.Run("collapse_success", func(t *testing.T) {
+		c := qt.New(t)
+		stdout, stderr := write(c, godexer.OutputOptions{Collapse: true}, godexer.OutputInfo{StepName: "build"}, false)
+		c.Assert(stdout, qt.Equals, "")
+		c.Assert(stderr, qt.Equals, "")
+	})
+
+	t.Run("collapse_failure", func(t *testing.T) {
+		c := qt.New(t)
+		opts := godexer.OutputOptions{Prefix: []string{"step"}, Collapse: true}
+		stdout, stderr := write(c, opts, godexer.OutputInfo{StepName: "build"}, true)
+		c.Assert(stdout, qt.Equals, "[build] first\n[build] second\n[build] unterminated\n")
+		c.Assert(stderr, qt.Equals, "[build] warning\n")
+	})
+
+	t.Run("invalid_prefix", func(t *testing.T) {
+		c := qt.New(t)
+		_, err := godexer.NewOutputDecorator(io.Discard, io.Discard, godexer.OutputOptions{Prefix: []string{"pid"}})(godexer.OutputInfo{})
+		c.Assert(err, qt.ErrorMatches, `unsupported output prefix "pid", must be one of step, host, time`)
+	})
+}
+
+func TestExec_Output(t *testing.T) {
+	godexer.ExecCommandFn = fakeOutputCommand
+	defer func() { godexer.ExecCommandFn = exec.Command }()
+
+	c := qt.New(t)
+	var stdout, stderr bytes.Buffer
+	ex, err := godexer.NewWithScenario(`commands:
+  - type: exec
+    stepName: quiet
+    cmd: ["tool", "compiling\n", "", "0"]
+  - type: exec
+    stepName: loud
+    cmd: ["tool", "done\n"]
+    output:
+      prefix: [step]
+  - type: exec
+    stepName: broken
+    cmd: ["tool", "linking\n", "undefined symbol\n", "1"]
+`, godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(&stdout), godexer.WithStderr(&stderr),
+		godexer.WithOutputOptions(godexer.OutputOptions{Prefix: []string{"step"}, Collapse: true}))
+	c.Assert(err, qt.IsNil)
+
+	err = ex.Execute(map[string]any{})
+	c.Assert(err, qt.ErrorMatches, ".*exit status 1")
+	c.Assert(stdout.String(), qt.Equals, "[loud] done\n[broken] linking\n")
+	c.Assert(stderr.String(), qt.Equals, "[broken] undefined symbol\n")
+}
+
+func TestExec_OutputStepName(t *testing.T) {
+	godexer.ExecCommandFn = fakeOutputCommand
+	defer func() { godexer.ExecCommandFn = exec.Command }()
+
+	// the prefix names unnamed steps and foreach iterations like the
+	// __step variables do
+	c := qt.New(t)
+	var stdout bytes.Buffer
+	ex, err := godexer.NewWithScenario(`commands:
+  - type: exec
+    cmd: ["tool", "unnamed\n"]
+  - type: foreach
+    stepName: hosts
+    variable: hosts
+    commands:
+      - type: exec
+        stepName: ping
+        cmd: ["tool", "pong\n"]
+`, godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(&stdout), godexer.WithStderr(io.Discard),
+		godexer.WithOutputOptions(godexer.OutputOptions{Prefix: []string{"step"}}))
+	c.Assert(err, qt.IsNil)
+
+	vars := map[string]any{"hosts": []any{"web", "db"}}
+	c.Assert(ex.Execute(vars), qt.IsNil)
+	c.Assert(stdout.String(), qt.Equals, "[__step_no_001] unnamed\n[ping_0] pong\n[ping_1] pong\n")
+}
@@ -0,0 +1,100 @@
+package godexer
+
+import (
+	"os/user"
+	"strconv"
+
+	"github.com/go-extras/errors"
+	"github.com/spf13/afero"
+)
+
+// Chowner changes the ownership of files on the executor's Fs for writefile
+// steps.
+type Chowner interface {
+	// Chown sets the owner and group of name on fs, each given as a name or
+	// a numeric ID; empty ones are left as they are. It reports whether the
+	// ownership changed.
+	Chown(fs afero.Fs, name, owner, group string) (bool, error)
+}
+
+// OSChowner resolves names in the OS user database and changes ownership
+// with fs.Chown. Unless the file's current ownership can be read, which takes
+// an OS filesystem, a chown is not reported as a change. It is used when no
+// chowner is set with WithChowner.
+type OSChowner struct{}
+
+func (OSChowner) Chown(fs afero.Fs, name, owner, group string) (bool, error) {
+	uid, err := lookupID(owner, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "unknown owner %q", owner)
+	}
+	gid, err := lookupID(group, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "unknown group %q", group)
+	}
+
+	info, err := fs.Stat(name)
+	if err != nil {
+		return false, err
+	}
+	curUID, curGID, known := fileOwner(info)
+	if known && (uid < 0 || uid == curUID) && (gid < 0 || gid == curGID) {
+		return false, nil
+	}
+	if err := fs.Chown(name, uid, gid); err != nil {
+		return false, err
+	}
+	return known, nil
+}
+
+// lookupID returns the numeric ID s stands for, looking names up with
+// lookup, or -1 if s is empty.
+func lookupID(s string, lookup func(name string) (string, error)) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(s); err == nil {
+		return id, nil
+	}
+	id, err := lookup(s)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// Chown sets the owner and group of name on the context's Fs with its
+// Chowner, or OSChowner if it is not set. It does nothing if both are empty.
+func (ectx *ExecutorContext) Chown(name, owner, group string) (bool, error) {
+	if owner == "" && group == "" {
+		return false, nil
+	}
+	chowner := ectx.Chowner
+	if chowner == nil {
+		chowner = OSChowner{}
+	}
+	changed, err := chowner.Chown(ectx.Fs, name, owner, group)
+	if err != nil {
+		return false, errors.Wrapf(err, "can't change the ownership of %s", name)
+	}
+	return changed, nil
+}
+
+// WithChowner makes writefile steps change file ownership with chowner.
+func WithChowner(chowner Chowner) func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.ectx.Chowner = chowner
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package godexer
+
+import "os"
+
+func fileOwner(os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package godexer
+
+import (
+	"os"
+	"syscall"
+)
+
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
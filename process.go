@@ -0,0 +1,146 @@
+package godexer
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-extras/errors"
+)
+
+// ProcessSpec describes a process to start.
+type ProcessSpec struct {
+	Path string
+	Args []string
+	// Env entries are added to the base environment, which is the current
+	// process' environment if InheritEnv is set and empty otherwise.
+	Env        []string
+	InheritEnv bool
+	Dir        string
+	Stdin      io.Reader
+	Stdout     io.Writer
+	Stderr     io.Writer
+}
+
+// Process is a started process.
+type Process interface {
+	// Wait waits for the process to exit. If it exits with a non-zero
+	// status, the error implements ExitCoder.
+	Wait() error
+}
+
+// ExitCoder is implemented by errors carrying a process exit status, such as
+// *exec.ExitError.
+type ExitCoder interface {
+	ExitCode() int
+}
+
+// ProcessRunner starts the processes of exec commands.
+type ProcessRunner interface {
+	Start(spec *ProcessSpec) (Process, error)
+}
+
+// OSProcessRunner starts processes with os/exec. It is used when no runner is
+// set with WithProcessRunner.
+type OSProcessRunner struct{}
+
+func (OSProcessRunner) Start(spec *ProcessSpec) (Process, error) {
+	cmd := ExecCommandFn(spec.Path, spec.Args...)
+	// ExecCommandFn may preset the environment, keep it as the base
+	env := cmd.Env
+	if env == nil && spec.InheritEnv {
+		env = os.Environ()
+	}
+	env = append(env, spec.Env...)
+	if env == nil {
+		// a nil Env would make the process inherit ours
+		env = []string{}
+	}
+	cmd.Env = env
+	cmd.Dir = spec.Dir
+	cmd.Stdin = spec.Stdin
+	cmd.Stdout = spec.Stdout
+	cmd.Stderr = spec.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// exitCode returns the exit status carried by err, if any. Besides ExitCoder,
+// it understands errors with an ExitStatus method, such as *ssh.ExitError.
+func exitCode(err error) (int, bool) {
+	var ec ExitCoder
+	if errors.As(err, &ec) {
+		return ec.ExitCode(), true
+	}
+	var es interface{ ExitStatus() int }
+	if errors.As(err, &es) {
+		return es.ExitStatus(), true
+	}
+	return 0, false
+}
+
+// Clock tells and waits for the time in time-based steps.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// SystemClock is the real clock. It is used when no clock is set with
+// WithClock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) Sleep(d time.Duration) {
+	TimeSleep(d)
+}
+
+// StartProcess starts a process with the context's Runner, or OSProcessRunner
+// if it is not set.
+func (ectx *ExecutorContext) StartProcess(spec *ProcessSpec) (Process, error) {
+	if ectx.Runner != nil {
+		return ectx.Runner.Start(spec)
+	}
+	return OSProcessRunner{}.Start(spec)
+}
+
+// Now returns the current time from the context's Clock, or SystemClock if it
+// is not set.
+func (ectx *ExecutorContext) Now() time.Time {
+	if ectx.Clock != nil {
+		return ectx.Clock.Now()
+	}
+	return SystemClock{}.Now()
+}
+
+// Sleep pauses on the context's Clock, or SystemClock if it is not set.
+func (ectx *ExecutorContext) Sleep(d time.Duration) {
+	if ectx.Clock != nil {
+		ectx.Clock.Sleep(d)
+		return
+	}
+	SystemClock{}.Sleep(d)
+}
+
+// WithProcessRunner makes exec commands start processes with runner.
+func WithProcessRunner(runner ProcessRunner) func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.ectx.Runner = runner
+	}
+}
+
+// WithClock makes time-based steps, output timestamps and the `now` template
+// function use clock.
+func WithClock(clock Clock) func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.ectx.Clock = clock
+		if clock != nil {
+			ex.RegisterValueFunc("now", clock.Now)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package godexer_test
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-extras/godexer"
+	"github.com/go-extras/godexer/internal/logger"
+)
+
+type fakeExitError int
+
+func (e fakeExitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+
+func (e fakeExitError) ExitCode() int { return int(e) }
+
+type fakeProcess struct {
+	err error
+}
+
+func (p fakeProcess) Wait() error { return p.err }
+
+// fakeRunner records the specs it is asked to start and fails the first
+// `failures` runs with exit status 3.
+type fakeRunner struct {
+	mu       sync.Mutex
+	specs    []godexer.ProcessSpec
+	failures int
+}
+
+func (r *fakeRunner) Start(spec *godexer.ProcessSpec) (godexer.Process, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.specs = append(r.specs, *spec)
+
+	var stdin []byte
+	if spec.Stdin != nil {
+		stdin, _ = io.ReadAll(spec.Stdin)
+	}
+	_, _ = io.WriteString(spec.Stdout, strings.Join(append([]string{spec.Path}, spec.Args...), " ")+string(stdin))
+	if r.failures > 0 {
+		r.failures--
+		return fakeProcess{err: fakeExitError(3)}, nil
+	}
+	return fakeProcess{}, nil
+}
+
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+}
+
+func TestProcessRunner(t *testing.T) {
+	t.Run("spec", func(t *testing.T) {
+		c := qt.New(t)
+		runner := &fakeRunner{}
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: exec
+    stepName: build
+    cmd: ["make", "{{ .target }}"]
+    env: {CC: clang}
+    clearEnv: true
+    dir: /src
+    stdin: input
+    variable: out
+`, godexer.WithProcessRunner(runner), godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(io.Discard))
+		c.Assert(err, qt.IsNil)
+
+		vars := map[string]any{"target": "all"}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(runner.specs, qt.HasLen, 1)
+		spec := runner.specs[0]
+		c.Assert(spec.Path, qt.Equals, "make")
+		c.Assert(spec.Args, qt.DeepEquals, []string{"all"})
+		c.Assert(spec.Env, qt.DeepEquals, []string{"CC=clang"})
+		c.Assert(spec.InheritEnv, qt.IsFalse)
+		c.Assert(spec.Dir, qt.Equals, "/src")
+		c.Assert(vars["out"], qt.Equals, "make allinput")
+	})
+
+	t.Run("exit_code", func(t *testing.T) {
+		c := qt.New(t)
+		runner := &fakeRunner{failures: 1}
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: exec
+    stepName: check
+    cmd: ["test", "-f", "/etc/motd"]
+    allowFail: true
+`, godexer.WithProcessRunner(runner), godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(io.Discard))
+		c.Assert(err, qt.IsNil)
+
+		vars := map[string]any{}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["check_exit_status"], qt.Equals, 3)
+	})
+
+	t.Run("retry_with_clock", func(t *testing.T) {
+		c := qt.New(t)
+		runner := &fakeRunner{failures: 2}
+		clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: exec
+    stepName: download
+    cmd: ["curl", "-fO", "https://example.com/file"]
+    attempts: 3
+    delay: 5
+  - type: sleep
+    stepName: settle
+    seconds: 30
+  - type: variable
+    stepName: stamp
+    variable: finished
+    value: '{{ now | date "15:04:05" }}'
+`, godexer.WithProcessRunner(runner), godexer.WithClock(clock),
+			godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(io.Discard))
+		c.Assert(err, qt.IsNil)
+
+		vars := map[string]any{}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(runner.specs, qt.HasLen, 3)
+		c.Assert(clock.sleeps, qt.DeepEquals, []time.Duration{5 * time.Second, 5 * time.Second, 30 * time.Second})
+		c.Assert(vars["finished"], qt.Equals, "00:00:40")
+	})
+
+	t.Run("inherited_by_children", func(t *testing.T) {
+		c := qt.New(t)
+		runner := &fakeRunner{}
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: foreach
+    stepName: each
+    iterable: ["a", "b"]
+    commands:
+      - type: exec
+        stepName: touch
+        cmd: ["touch", "{{ .value }}"]
+`, godexer.WithProcessRunner(runner), godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(io.Discard))
+		c.Assert(err, qt.IsNil)
+
+		c.Assert(ex.Execute(map[string]any{}), qt.IsNil)
+		c.Assert(runner.specs, qt.HasLen, 2)
+		c.Assert(runner.specs[1].Args, qt.DeepEquals, []string{"b"})
+	})
+}
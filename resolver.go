@@ -0,0 +1,34 @@
+package godexer
+
+import (
+	"context"
+	"net"
+)
+
+// Resolver looks up the addresses of a host. *net.Resolver implements it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// LookupHost resolves host with the context's Resolver, or
+// net.DefaultResolver if it is not set.
+func (ectx *ExecutorContext) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if ectx.Resolver != nil {
+		return ectx.Resolver.LookupHost(ctx, host)
+	}
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+// LookupHost resolves host with the executor's Resolver, for functions
+// registered on it.
+func (ex *Executor) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return ex.ectx.LookupHost(ctx, host)
+}
+
+// WithResolver makes host lookups, such as the network package's
+// `resolve_host`, use resolver.
+func WithResolver(resolver Resolver) func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.ectx.Resolver = resolver
+	}
+}
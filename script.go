@@ -0,0 +1,136 @@
+package godexer
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/go-extras/errors"
+	"github.com/spf13/afero"
+)
+
+// DefaultInterpreter runs scripts that don't set an interpreter.
+const DefaultInterpreter = "/bin/sh -e"
+
+// ScriptSource holds the inline script fields shared by the exec commands.
+// Script is used instead of cmd.
+type ScriptSource struct {
+	// Script is a templated script body.
+	Script string
+	// Interpreter is the (templated) command line running the script, split on
+	// whitespace. Defaults to DefaultInterpreter.
+	Interpreter string
+	// Args are templated arguments passed to the script.
+	Args []string
+}
+
+// HasScript reports whether a script is set.
+func (s *ScriptSource) HasScript() bool {
+	return s.Script != ""
+}
+
+// Script is a rendered ScriptSource.
+type Script struct {
+	Body        string
+	Interpreter []string
+	Args        []string
+}
+
+// RenderScript renders the script fields.
+func (s *ScriptSource) RenderScript(r *BaseCommand, variables map[string]any) (*Script, error) {
+	body, err := r.EvalString("script", s.Script, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	interpreter := s.Interpreter
+	if interpreter == "" {
+		interpreter = DefaultInterpreter
+	}
+	interpreter, err = r.EvalString("interpreter", interpreter, variables)
+	if err != nil {
+		return nil, err
+	}
+	result := &Script{
+		Body:        body,
+		Interpreter: strings.Fields(interpreter),
+	}
+	if len(result.Interpreter) == 0 {
+		return nil, errors.New("interpreter is empty")
+	}
+
+	for i, v := range s.Args {
+		arg, err := r.EvalString(fmt.Sprintf("args[%d]", i), v, variables)
+		if err != nil {
+			return nil, err
+		}
+		result.Args = append(result.Args, arg)
+	}
+
+	return result, nil
+}
+
+// Piped reports whether the script can be piped to the interpreter's stdin.
+// That needs stdin to be free and no args, since interpreters differ in how
+// they take args along with a script on stdin; otherwise the script is run
+// from a file.
+func (s *Script) Piped(hasStdin bool) bool {
+	return !hasStdin && len(s.Args) == 0
+}
+
+// Cmd returns the command line running the script from path, or from stdin
+// when path is empty.
+func (s *Script) Cmd(path string) []string {
+	cmd := slices.Clone(s.Interpreter)
+	if path != "" {
+		cmd = append(cmd, path)
+	}
+	return append(cmd, s.Args...)
+}
+
+// WriteTemp writes the script to a new temp file on fs, readable only by the
+// owner, and returns its name. The caller removes it.
+func (s *Script) WriteTemp(fs afero.Fs) (string, error) {
+	f, err := afero.TempFile(fs, "", "godexer-script-*")
+	if err != nil {
+		return "", errors.Wrap(err, "can't create script file")
+	}
+	_, err = f.WriteString(s.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = fs.Chmod(f.Name(), 0o700)
+	}
+	if err != nil {
+		_ = fs.Remove(f.Name())
+		return "", errors.Wrap(err, "can't write script file")
+	}
+	return f.Name(), nil
+}
+
+// stageScript reads the script from stdin into a temp file and runs it from
+// there. dd reads one byte at a time so that it stops right at the end of the
+// script and leaves the rest of stdin to it.
+const stageScript = `f=$(mktemp) || exit
+trap 'rm -f -- "$f"' EXIT
+dd bs=1 count=%d of="$f" 2>/dev/null || exit
+%s "$f" %s`
+
+// Staged returns the command line running the script from a temp file that
+// the command creates itself, reading the script from the start of its
+// stdin, so that the file belongs to whoever runs it. Escalated scripts use
+// it, as the become user can't read a file written by the executor.
+func (s *Script) Staged() []string {
+	return []string{"sh", "-c", fmt.Sprintf(stageScript, len(s.Body), escapeArgs(s.Interpreter), escapeArgs(s.Args))}
+}
+
+// StagedStdin returns the stdin of the Staged command line: the script
+// followed by stdin, if any.
+func (s *Script) StagedStdin(stdin io.Reader) io.Reader {
+	if stdin == nil {
+		return strings.NewReader(s.Body)
+	}
+	return io.MultiReader(strings.NewReader(s.Body), stdin)
+}
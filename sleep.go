@@ -24,6 +24,6 @@ type SleepCommand struct {
 
 func (s *SleepCommand) Execute(_ map[string]any) error {
 	s.Ectx.Logger.Infof("Sleeping for %d seconds", s.Seconds)
-	TimeSleep(time.Duration(s.Seconds) * time.Second)
+	s.Ectx.Sleep(time.Duration(s.Seconds) * time.Second)
 	return nil
 }
@@ -0,0 +1,142 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"sync"
+
+	"github.com/go-extras/errors"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/go-extras/godexer"
+)
+
+// promptResponder passes output through to w and answers the become password
+// prompt, the escalation's marker, on stdin. The prompt is left out of the
+// output. Another prompt means the password was rejected, so stdin is closed
+// to make the command fail instead of waiting for input.
+type promptResponder struct {
+	mu       sync.Mutex
+	w        io.Writer
+	stdin    io.WriteCloser
+	prompt   []byte
+	password string
+	answered bool
+	// pending is output that may be the start of a prompt
+	pending []byte
+}
+
+func (p *promptResponder) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending = append(p.pending, b...)
+	for {
+		i := bytes.Index(p.pending, p.prompt)
+		if i < 0 {
+			break
+		}
+		if _, err := p.w.Write(p.pending[:i]); err != nil {
+			return 0, err
+		}
+		p.pending = p.pending[i+len(p.prompt):]
+		if err := p.answer(); err != nil {
+			return 0, err
+		}
+	}
+
+	keep := len(p.prompt) - 1
+	for keep > 0 && !bytes.HasSuffix(p.pending, p.prompt[:keep]) {
+		keep--
+	}
+	if _, err := p.w.Write(p.pending[:len(p.pending)-keep]); err != nil {
+		return 0, err
+	}
+	p.pending = append([]byte(nil), p.pending[len(p.pending)-keep:]...)
+	return len(b), nil
+}
+
+func (p *promptResponder) answer() error {
+	if p.answered {
+		_ = p.stdin.Close()
+		return nil
+	}
+	p.answered = true
+	if _, err := io.WriteString(p.stdin, p.password+"\n"); err != nil {
+		return errors.Wrap(err, "failed to answer the password prompt")
+	}
+	return nil
+}
+
+// Flush writes out the output held back as a possible start of a prompt.
+func (p *promptResponder) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err := p.w.Write(p.pending)
+	p.pending = nil
+	return err
+}
+
+// answerPasswordPrompt makes the session answer the password prompt of a
+// command wrapped by the escalation without passwordOnStdin and returns the
+// writer to use as its stdout. The session needs a pty.
+func answerPasswordPrompt(session *ssh.Session, stdout io.Writer, escalation *godexer.Escalation) (*promptResponder, error) {
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open stdin")
+	}
+	return &promptResponder{
+		w:        stdout,
+		stdin:    stdin,
+		prompt:   []byte(escalation.Marker),
+		password: escalation.Password,
+	}, nil
+}
+
+func requestPty(session *ssh.Session) error {
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,     // disable echoing
+		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+	}
+
+	if err := session.RequestPty("xterm", 80, 40, modes); err != nil {
+		return errors.Wrap(err, "failed to request pty")
+	}
+	return nil
+}
+
+// runAs runs argv on a new session, through the escalation if it is set, in
+// which case the password is put on stdin in front of the given one.
+func runAs(client *ssh.Client, escalation *godexer.Escalation, argv []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if escalation != nil {
+		var err error
+		if argv, err = escalation.Wrap(argv, true); err != nil {
+			return err
+		}
+		stdin = escalation.PasswordStdin(stdin)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return errors.Wrap(err, "unable to get ssh session")
+	}
+	defer session.Close()
+
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+	return session.Run(escapeArgs(argv))
+}
+
+// remoteTempPath returns a random path in the remote /tmp.
+func remoteTempPath(prefix string) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", errors.Wrap(err, "can't generate a temp file name")
+	}
+	return "/tmp/" + prefix + hex.EncodeToString(suffix), nil
+}
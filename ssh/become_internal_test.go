@@ -0,0 +1,52 @@
+package ssh
+
+import (
+	"bytes"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+type stdinRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (s *stdinRecorder) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestPromptResponder(t *testing.T) {
+	c := qt.New(t)
+	var out bytes.Buffer
+	stdin := &stdinRecorder{}
+	p := &promptResponder{w: &out, stdin: stdin, prompt: []byte("[marker]"), password: "s3cret"}
+
+	write := func(s string) {
+		n, err := p.Write([]byte(s))
+		c.Assert(err, qt.IsNil)
+		c.Assert(n, qt.Equals, len(s))
+	}
+
+	// other prompts are not answered
+	write("[sudo] password for deploy: ")
+	c.Assert(stdin.String(), qt.Equals, "")
+
+	// the prompt may be split across writes
+	write("[mar")
+	c.Assert(out.String(), qt.Equals, "[sudo] password for deploy: ")
+	write("ker]\nok\n")
+	c.Assert(stdin.String(), qt.Equals, "s3cret\n")
+	c.Assert(out.String(), qt.Equals, "[sudo] password for deploy: \nok\n")
+
+	// a second prompt means the password was rejected
+	write("[marker]")
+	c.Assert(stdin.String(), qt.Equals, "s3cret\n")
+	c.Assert(stdin.closed, qt.IsTrue)
+
+	write("done [m")
+	c.Assert(out.String(), qt.Equals, "[sudo] password for deploy: \nok\ndone ")
+	c.Assert(p.Flush(), qt.IsNil)
+	c.Assert(out.String(), qt.Equals, "[sudo] password for deploy: \nok\ndone [m")
+}
@@ -0,0 +1,260 @@
+package ssh_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/go-extras/godexer"
+	"github.com/go-extras/godexer/internal/logger"
+	"github.com/go-extras/godexer/internal/testutils"
+	sshexec "github.com/go-extras/godexer/ssh"
+)
+
+// commandRecorder keeps the commands run by shellHandler.
+type commandRecorder struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (r *commandRecorder) handle(cmd string, ch ssh.Channel) error {
+	r.mu.Lock()
+	r.commands = append(r.commands, cmd)
+	r.mu.Unlock()
+	return shellHandler(cmd, ch)
+}
+
+func (r *commandRecorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	commands := r.commands
+	r.commands = nil
+	return commands
+}
+
+// receiveSCP receives a single file sent to `scp -t`.
+func receiveSCP(r *bufio.Reader, ch ssh.Channel) (string, error) {
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	size, err := strconv.Atoi(strings.Fields(header)[1])
+	if err != nil {
+		return "", err
+	}
+	_, _ = ch.Write([]byte("\x00"))
+	data := make([]byte, size+1)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	_, _ = ch.Write([]byte("\x00"))
+	return string(data[:size]), nil
+}
+
+func TestSSHBecome(t *testing.T) {
+	c := qt.New(t)
+
+	signer, err := testutils.MakeSigner(key)
+	c.Assert(err, qt.IsNil)
+
+	recorder := &commandRecorder{}
+	server := testutils.NewServer(signer, nil, recorder.handle)
+	go server.Start()
+	defer server.Stop()
+
+	config, err := testutils.GetClientConfig("testuser", key)
+	c.Assert(err, qt.IsNil)
+	client, err := testutils.CreateConn("127.0.0.1", fmt.Sprintf("%d", server.Addr().Port), config)
+	c.Assert(err, qt.IsNil)
+	defer client.Close()
+
+	newExecutor := func(c *qt.C, scenario string, stdout io.Writer) *godexer.Executor {
+		cmds := godexer.GetRegisteredCommands()
+		cmds["ssh_exec"] = sshexec.NewSSHExecCommand(client, io.Discard, io.Discard)
+		cmds["scp_writefile"] = sshexec.NewScpWriterFileCommand(client)
+		cmds["ssh_lineinfile"] = sshexec.NewSSHLineInFileCommand(client)
+		ex, err := godexer.NewWithScenario(scenario, godexer.WithCommandTypes(cmds),
+			godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(stdout), godexer.WithStderr(io.Discard))
+		c.Assert(err, qt.IsNil)
+		return ex
+	}
+
+	c.Run("exec_password_prompt", func(c *qt.C) {
+		testutils.FakeSudo(c.TB, "s3cret")
+		recorder.take()
+		var stdout bytes.Buffer
+		ex := newExecutor(c, `commands:
+  - type: ssh_exec
+    stepName: whoami
+    cmd: ["printenv", "FAKE_SUDO_USER"]
+    become: true
+    becomeUser: deploy
+    becomePasswordVariable: password
+`, &stdout)
+
+		c.Assert(ex.Execute(map[string]any{"password": "s3cret"}), qt.IsNil)
+		commands := recorder.take()
+		c.Assert(commands, qt.HasLen, 1)
+		c.Assert(commands[0], qt.Matches, `sudo -p godexer-become-[0-9a-f]{32} -u deploy -- sh -c 'printenv FAKE_SUDO_USER'`)
+		// the prompt is answered and left out of the output
+		c.Assert(stdout.String(), qt.Equals, "deploy\n")
+
+		err := ex.Execute(map[string]any{"password": "wrong"})
+		c.Assert(err, qt.ErrorMatches, ".*exited with status 1.*")
+	})
+
+	for _, sudoPassword := range []string{"s3cret", ""} {
+		c.Run("stdin_"+map[string]string{"s3cret": "prompted", "": "nopasswd"}[sudoPassword], func(c *qt.C) {
+			testutils.FakeSudo(c.TB, sudoPassword)
+			ex := newExecutor(c, `commands:
+  - type: ssh_exec
+    stepName: read
+    cmd: ["cat"]
+    stdin: "line 1\nline 2\n"
+    variable: out
+    become: true
+    becomePasswordVariable: password
+`, io.Discard)
+
+			// sudo without a prompt leaves the password on stdin, it must not
+			// reach the command
+			vars := map[string]any{"password": "s3cret"}
+			c.Assert(ex.Execute(vars), qt.IsNil)
+			c.Assert(vars["out"], qt.Equals, "line 1\nline 2\n")
+		})
+	}
+
+	c.Run("script_as_user", func(c *qt.C) {
+		testutils.FakeSudoSwitch(c.TB, "s3cret")
+		recorder.take()
+		// an uploaded script would belong to the login user, so the become
+		// user has to write, read and remove its own copy
+		ex := newExecutor(c, `commands:
+  - type: ssh_exec
+    stepName: script
+    script: |
+      id -un
+      echo "$1"
+      cat
+      echo "$0"
+    args: ["a b"]
+    stdin: "data\n"
+    variable: out
+    become: true
+    becomeUser: nobody
+    becomePasswordVariable: password
+`, io.Discard)
+
+		vars := map[string]any{"password": "s3cret"}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		lines := strings.Split(vars["out"].(string), "\n")
+		c.Assert(lines, qt.HasLen, 5)
+		c.Assert(lines[:3], qt.DeepEquals, []string{"nobody", "a b", "data"})
+		_, err := os.Stat(lines[3])
+		c.Assert(os.IsNotExist(err), qt.IsTrue, qt.Commentf("script file %s is left behind", lines[3]))
+		c.Assert(recorder.take(), qt.HasLen, 1)
+	})
+
+	c.Run("writefile", func(c *qt.C) {
+		testutils.FakeSudo(c.TB, "s3cret")
+		recorder.take()
+		file := filepath.Join(c.TempDir(), "nginx.conf")
+		ex := newExecutor(c, `commands:
+  - type: scp_writefile
+    stepName: config
+    file: '{{ .file }}'
+    contents: "worker_processes 4;\n"
+    permissions: "0644"
+    become: true
+    becomeUser: deploy
+    becomePasswordVariable: password
+`, io.Discard)
+
+		vars := map[string]any{"password": "s3cret", "file": file}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["__step:config:changed"], qt.IsTrue)
+		data, err := os.ReadFile(file)
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(data), qt.Equals, "worker_processes 4;\n")
+
+		// the contents are streamed to the install, nothing is uploaded
+		commands := recorder.take()
+		c.Assert(commands, qt.HasLen, 1)
+		c.Assert(commands[0], qt.Matches, `(?s)sudo -S -p '' -u deploy -- sh -c '.*' godexer-become-[0-9a-f]{32} sh -c '.*' sh - .*`)
+	})
+
+	c.Run("writefile_as_user", func(c *qt.C) {
+		testutils.FakeSudoSwitch(c.TB, "s3cret")
+		dir, err := os.MkdirTemp("", "godexer-become-")
+		c.Assert(err, qt.IsNil)
+		c.Cleanup(func() { _ = os.RemoveAll(dir) })
+		c.Assert(os.Chown(dir, 65534, -1), qt.IsNil)
+
+		ex := newExecutor(c, `commands:
+  - type: scp_writefile
+    stepName: config
+    file: '{{ .file }}'
+    contents: "worker_processes 4;\n"
+    permissions: "0600"
+    become: true
+    becomeUser: nobody
+    becomePasswordVariable: password
+`, io.Discard)
+
+		file := filepath.Join(dir, "nginx.conf")
+		vars := map[string]any{"password": "s3cret", "file": file}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		data, err := os.ReadFile(file)
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(data), qt.Equals, "worker_processes 4;\n")
+		owner, err := exec.Command("stat", "-c", "%U", file).Output()
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(owner), qt.Equals, "nobody\n")
+	})
+
+	c.Run("lineinfile", func(c *qt.C) {
+		testutils.FakeSudo(c.TB, "s3cret")
+		file := filepath.Join(c.TempDir(), "sshd_config")
+		c.Assert(os.WriteFile(file, []byte("#PermitRootLogin yes\n"), 0o600), qt.IsNil)
+		ex := newExecutor(c, `commands:
+  - type: ssh_lineinfile
+    stepName: root
+    file: '{{ .file }}'
+    regexp: ^#?PermitRootLogin
+    line: PermitRootLogin no
+    become: true
+    becomePasswordVariable: password
+`, io.Discard)
+
+		vars := map[string]any{"password": "s3cret", "file": file}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["__step:root:changed"], qt.IsTrue)
+		data, err := os.ReadFile(file)
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(data), qt.Equals, "PermitRootLogin no\n")
+	})
+
+	c.Run("su_password", func(c *qt.C) {
+		ex := newExecutor(c, `commands:
+  - type: ssh_exec
+    stepName: restart
+    cmd: ["true"]
+    become: true
+    becomeMethod: su
+    becomePasswordVariable: password
+`, io.Discard)
+		err := ex.Execute(map[string]any{"password": "s3cret"})
+		c.Assert(err, qt.ErrorMatches, ".*becomeMethod su doesn't support a become password, use sudo")
+	})
+}
@@ -0,0 +1,126 @@
+package ssh
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/go-extras/errors"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/go-extras/godexer"
+)
+
+// readScript prints the file, or exits with missingStatus if it doesn't
+// exist.
+const (
+	readScript    = `[ -e "$1" ] || exit 3; exec cat -- "$1"`
+	missingStatus = 3
+)
+
+// NewSSHLineInFileCommand creates a lineinfile command editing a file on the
+// remote host.
+func NewSSHLineInFileCommand(sshClient *ssh.Client) func(ectx *godexer.ExecutorContext) godexer.Command {
+	return func(ectx *godexer.ExecutorContext) godexer.Command {
+		return &LineInFileCommand{
+			sshClient: sshClient,
+			BaseCommand: godexer.BaseCommand{
+				Ectx: ectx,
+			},
+		}
+	}
+}
+
+type LineInFileCommand struct {
+	godexer.BaseCommand
+	godexer.BecomeOptions
+	godexer.WriteOptions
+	godexer.LineInFileOptions
+	sshClient *ssh.Client
+	Timeout   int
+}
+
+func (r *LineInFileCommand) Execute(variables map[string]any) error {
+	return editRemote(r.sshClient, &r.BaseCommand, &r.BecomeOptions, &r.WriteOptions, &r.EditOptions,
+		&r.LineInFileOptions, r.Timeout, variables)
+}
+
+// NewSSHBlockInFileCommand creates a blockinfile command editing a file on
+// the remote host.
+func NewSSHBlockInFileCommand(sshClient *ssh.Client) func(ectx *godexer.ExecutorContext) godexer.Command {
+	return func(ectx *godexer.ExecutorContext) godexer.Command {
+		return &BlockInFileCommand{
+			sshClient: sshClient,
+			BaseCommand: godexer.BaseCommand{
+				Ectx: ectx,
+			},
+		}
+	}
+}
+
+type BlockInFileCommand struct {
+	godexer.BaseCommand
+	godexer.BecomeOptions
+	godexer.WriteOptions
+	godexer.BlockInFileOptions
+	sshClient *ssh.Client
+	Timeout   int
+}
+
+func (r *BlockInFileCommand) Execute(variables map[string]any) error {
+	return editRemote(r.sshClient, &r.BaseCommand, &r.BecomeOptions, &r.WriteOptions, &r.EditOptions,
+		&r.BlockInFileOptions, r.Timeout, variables)
+}
+
+// editRemote reads the remote file, applies the edit and installs the result
+// like scp_writefile does.
+func editRemote(sshClient *ssh.Client, r *godexer.BaseCommand, b *godexer.BecomeOptions, w *godexer.WriteOptions, o *godexer.EditOptions, editor godexer.FileEditor, timeout int, variables map[string]any) error {
+	w.Reset()
+	if len(o.File) == 0 {
+		return errors.Errorf("filename in %q is empty", r.StepName)
+	}
+	fileName, err := r.EvalString("file", o.File, variables)
+	if err != nil {
+		return err
+	}
+	mode, err := o.Mode()
+	if err != nil {
+		return err
+	}
+	escalation, err := b.Escalation(r, variables)
+	if err != nil {
+		return err
+	}
+
+	contents, exists, err := readRemote(sshClient, escalation, fileName)
+	if err != nil {
+		return err
+	}
+	edited, ok, err := o.Apply(r, variables, editor, fileName, contents, exists)
+	if err != nil || !ok {
+		return err
+	}
+	if exists && bytes.Equal(edited, contents) && mode == 0 && w.Owner == "" && w.Group == "" {
+		r.Ectx.Logger.Debugf("%s is up to date", fileName)
+		return nil
+	}
+
+	modeArg := ""
+	if mode != 0 {
+		modeArg = godexer.FormatPermissions(mode)
+	}
+	return install(sshClient, r, w, escalation, bytes.NewReader(edited), fileName, modeArg, timeout, variables)
+}
+
+// readRemote returns the contents of the remote file and whether it exists,
+// reading it through the escalation if it is set.
+func readRemote(sshClient *ssh.Client, escalation *godexer.Escalation, fileName string) ([]byte, bool, error) {
+	var stdout, stderr bytes.Buffer
+	err := runAs(sshClient, escalation, []string{"sh", "-c", readScript, "sh", fileName}, nil, &stdout, &stderr)
+	if status, ok := exitStatus(err); ok && status == missingStatus {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "failed to read %s: %s", fileName, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), true, nil
+}
@@ -0,0 +1,91 @@
+package ssh_test
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-extras/godexer"
+	"github.com/go-extras/godexer/internal/logger"
+	"github.com/go-extras/godexer/internal/testutils"
+	sshexec "github.com/go-extras/godexer/ssh"
+)
+
+func TestSSHEditFile(t *testing.T) {
+	c := qt.New(t)
+
+	signer, err := testutils.MakeSigner(key)
+	c.Assert(err, qt.IsNil)
+	server := testutils.NewServer(signer, nil, shellHandler)
+	go server.Start()
+	defer server.Stop()
+
+	config, err := testutils.GetClientConfig("testuser", key)
+	c.Assert(err, qt.IsNil)
+	client, err := testutils.CreateConn("127.0.0.1", fmt.Sprintf("%d", server.Addr().Port), config)
+	c.Assert(err, qt.IsNil)
+	defer client.Close()
+
+	dir := t.TempDir()
+	sshdConfig := filepath.Join(dir, "sshd_config")
+	hosts := filepath.Join(dir, "hosts")
+	c.Assert(os.WriteFile(sshdConfig, []byte("Port 22\n#PermitRootLogin yes\n"), 0o600), qt.IsNil)
+
+	cmds := godexer.GetRegisteredCommands()
+	cmds["ssh_lineinfile"] = sshexec.NewSSHLineInFileCommand(client)
+	cmds["ssh_blockinfile"] = sshexec.NewSSHBlockInFileCommand(client)
+	ex, err := godexer.NewWithScenario(`commands:
+  - type: ssh_lineinfile
+    stepName: root
+    file: '{{ .sshd_config }}'
+    regexp: ^#?PermitRootLogin
+    line: PermitRootLogin {{ .root }}
+  - type: ssh_blockinfile
+    stepName: hosts
+    file: '{{ .hosts }}'
+    block: 10.0.0.2 web
+    create: true
+`, godexer.WithCommandTypes(cmds), godexer.WithLogger(&logger.Logger{}),
+		godexer.WithStdout(io.Discard), godexer.WithStderr(io.Discard))
+	c.Assert(err, qt.IsNil)
+
+	run := func(root string) map[string]any {
+		vars := map[string]any{"sshd_config": sshdConfig, "hosts": hosts, "root": root}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		return vars
+	}
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		c.Assert(err, qt.IsNil)
+		return string(data)
+	}
+
+	vars := run("no")
+	c.Assert(vars["__step:root:changed"], qt.IsTrue)
+	c.Assert(vars["__step:hosts:changed"], qt.IsTrue)
+	c.Assert(read(sshdConfig), qt.Equals, "Port 22\nPermitRootLogin no\n")
+	c.Assert(read(hosts), qt.Equals,
+		"# BEGIN GODEXER MANAGED BLOCK\n10.0.0.2 web\n# END GODEXER MANAGED BLOCK\n")
+	info, err := os.Stat(sshdConfig)
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.Mode().Perm(), qt.Equals, os.FileMode(0o600))
+	info, err = os.Stat(hosts)
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.Mode().Perm(), qt.Equals, os.FileMode(0o644))
+
+	vars = run("no")
+	c.Assert(vars["__step:root:changed"], qt.IsFalse)
+	c.Assert(vars["__step:hosts:changed"], qt.IsFalse)
+
+	vars = run("prohibit-password")
+	c.Assert(vars["__step:root:changed"], qt.IsTrue)
+	c.Assert(read(sshdConfig), qt.Equals, "Port 22\nPermitRootLogin prohibit-password\n")
+
+	c.Assert(os.Remove(sshdConfig), qt.IsNil)
+	err = ex.Execute(map[string]any{"sshd_config": sshdConfig, "hosts": hosts, "root": "no"})
+	c.Assert(err, qt.ErrorMatches, `(?s).*file .*sshd_config in "root" does not exist, set create: true to create it`)
+}
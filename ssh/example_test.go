@@ -18,6 +18,8 @@ func Example_registerSSHCommands() {
 	cmds := godexer.GetRegisteredCommands()
 	cmds["scp_writefile"] = sshexec.NewScpWriterFileCommand(client)
 	cmds["ssh_exec"] = sshexec.NewSSHExecCommand(client, io.Discard, io.Discard)
+	cmds["ssh_lineinfile"] = sshexec.NewSSHLineInFileCommand(client)
+	cmds["ssh_blockinfile"] = sshexec.NewSSHBlockInFileCommand(client)
 
 	// scenario := "..."
 	// ex, _ := godexer.NewWithScenario(scenario, godexer.WithCommandTypes(cmds))
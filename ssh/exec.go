@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
@@ -14,6 +16,9 @@ import (
 	"github.com/go-extras/godexer"
 )
 
+// TimeSleep pauses between retries when the executor has no Clock.
+//
+// Deprecated: set a Clock with godexer.WithClock instead.
 var TimeSleep = time.Sleep
 
 func escapeArgs(args []string) (result string) {
@@ -30,6 +35,8 @@ func escapeArgs(args []string) (result string) {
 
 type ExecCommand struct {
 	godexer.BaseCommand
+	godexer.StdinSource
+	godexer.ScriptSource
 	sshClient      *ssh.Client
 	stdout         io.Writer
 	stderr         io.Writer
@@ -39,7 +46,17 @@ type ExecCommand struct {
 	AllowFail      bool
 	OnEachFailure  []json.RawMessage
 	OnFinalFailure []json.RawMessage
-	Env            map[string]string
+	// Env is merged into ExecEnvironment.Env, which scenarios set with the env
+	// field.
+	//
+	// Deprecated: set ExecEnvironment.Env instead.
+	Env map[string]string `json:"-"`
+	godexer.ExecEnvironment
+	godexer.OutputCapture
+	godexer.ExitCriteria
+	godexer.BecomeOptions
+	// Output overrides the executor's output options for this step.
+	Output *godexer.OutputOptions
 
 	// the following parameters will allow retrying the command
 	Attempts int // if 0 or 1, no retry
@@ -64,27 +81,64 @@ func (r *ExecCommand) Execute(variables map[string]any) error {
 		return errors.Errorf("this command must be run from the executor")
 	}
 
-	cmd, err := r.prepareCommand(variables)
+	stdin, err := r.StdinReader(&r.BaseCommand, variables)
 	if err != nil {
 		return err
 	}
 
-	r.printCommand(cmd, variables)
+	escalation, err := r.Escalation(&r.BaseCommand, variables)
+	if err != nil {
+		return err
+	}
+
+	inv := godexer.Invocation{Type: godexer.InvocationSSHExec, Host: r.host()}
+	var script *godexer.Script
+	if r.HasScript() {
+		if len(r.Cmd) > 0 {
+			return errors.Errorf("cmd and script are mutually exclusive in %q", r.StepName)
+		}
+		script, err = r.RenderScript(&r.BaseCommand, variables)
+		if err != nil {
+			return err
+		}
+		inv.Argv, inv.Script = script.Cmd(""), script.Body
+	} else {
+		inv.Argv, err = r.prepareCommand(variables)
+		if err != nil {
+			return err
+		}
+	}
 
-	session, err := r.createSession()
+	penv, err := r.processEnvironment(variables)
 	if err != nil {
 		return err
 	}
-	defer session.Close()
+	inv.Env, inv.ClearEnv, inv.Dir = penv.Env, penv.Clear, penv.Dir
 
-	var buf godexer.Buffer
-	r.setupSessionIO(session, &buf)
+	if err := r.printCommand(escapeArgs(inv.Argv), variables); err != nil {
+		return err
+	}
 
-	if err := r.setEnvironment(session, variables); err != nil {
+	output, err := r.Ectx.OpenOutput(godexer.OutputInfo{
+		StepName: r.RunStepName(),
+		Host:     inv.Host,
+		Options:  r.Output,
+	})
+	if err != nil {
+		return err
+	}
+	capture, err := r.StartCapture(r.Variable, r.NeedsOutput(), output.Stdout(), output.Stderr())
+	if err != nil {
 		return err
 	}
 
-	err = r.runCommand(session, cmd, &buf, variables)
+	err = r.Ectx.RunInvocation(inv, stdin, capture.Stdout, capture.Stderr, func(stdin io.Reader, stdout, stderr io.Writer) error {
+		return r.run(inv.Argv, script, penv, escalation, stdin, stdout, stderr)
+	})
+	err = r.checkExit(err, capture, variables)
+	if closeErr := output.Close(err != nil); err == nil {
+		err = closeErr
+	}
 	if err == nil {
 		return nil
 	}
@@ -92,103 +146,234 @@ func (r *ExecCommand) Execute(variables map[string]any) error {
 	return r.handleError(err, variables)
 }
 
-func (r *ExecCommand) prepareCommand(variables map[string]any) (string, error) {
+// run runs the command, or the script if it is set, in a new session.
+func (r *ExecCommand) run(cmds []string, script *godexer.Script, penv *godexer.ProcessEnv, escalation *godexer.Escalation, stdin io.Reader, stdout, stderr io.Writer) error {
+	cmd := escapeArgs(cmds)
+	var scriptPath string
+	if script != nil {
+		var err error
+		cmd, stdin, scriptPath, err = r.prepareScript(script, escalation != nil, stdin)
+		if err != nil {
+			return err
+		}
+	}
+
+	// a pty would echo stdin back, mangle line endings and merge stderr into stdout
+	pty := stdin == nil && r.StdoutVariable == "" && r.StderrVariable == "" && r.Parse == "" && !r.NeedsOutput()
+	session, err := r.createSession(pty)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	// the escalation command resets the environment, so it only gets the
+	// variables through the command line
+	if escalation == nil && !penv.Clear && setEnvironment(session, penv.Env) == nil {
+		penv = &godexer.ProcessEnv{Dir: penv.Dir}
+	}
+	cmd = wrapEnvironment(cmd, penv)
+	if scriptPath != "" {
+		// remove the uploaded script, keeping the exit status
+		cmd += "; rc=$?; rm -f -- " + escapeArgs([]string{scriptPath}) + "; exit $rc"
+	}
+	if escalation != nil {
+		// sudo prompts on the pty if there is one, and reads stdin otherwise
+		argv, err := escalation.Wrap([]string{"sh", "-c", cmd}, !pty)
+		if err != nil {
+			return err
+		}
+		cmd = escapeArgs(argv)
+		if !pty {
+			stdin = escalation.PasswordStdin(stdin)
+		}
+	}
+
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if stdin != nil {
+		session.Stdin = stdin
+	}
+	var responder *promptResponder
+	if pty && escalation != nil && escalation.Password != "" {
+		if responder, err = answerPasswordPrompt(session, stdout, escalation); err != nil {
+			return err
+		}
+		session.Stdout = responder
+	}
+
+	if err := session.Start(cmd); err != nil {
+		return err
+	}
+	err = session.Wait()
+	if responder != nil {
+		if flushErr := responder.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+func (r *ExecCommand) prepareCommand(variables map[string]any) ([]string, error) {
 	var cmds []string
-	for _, v := range r.Cmd {
-		cmds = append(cmds, godexer.MaybeEvalValue(v, variables).(string))
+	for i, v := range r.Cmd {
+		arg, err := r.EvalString(fmt.Sprintf("cmd[%d]", i), v, variables)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, arg)
 	}
 
-	cmd := escapeArgs(cmds)
-	if len(cmd) == 0 {
-		return "", errors.Errorf("command %q is empty", r.StepName)
+	if len(cmds) == 0 {
+		return nil, errors.Errorf("command %q is empty", r.StepName)
 	}
 
-	return cmd, nil
+	return cmds, nil
 }
 
-func (r *ExecCommand) printCommand(cmd string, variables map[string]any) {
-	addr := r.sshClient.RemoteAddr().String()
+// prepareScript returns the command line and stdin running the script. The
+// script is piped to the remote interpreter when stdin is free and uploaded
+// to a temp file otherwise, whose path is returned. Escalated scripts are
+// staged by the become user instead, as it can't read the upload.
+func (r *ExecCommand) prepareScript(script *godexer.Script, escalated bool, stdin io.Reader) (string, io.Reader, string, error) {
+	if script.Piped(stdin != nil) {
+		return escapeArgs(script.Cmd("")), strings.NewReader(script.Body), "", nil
+	}
+	if escalated {
+		return escapeArgs(script.Staged()), script.StagedStdin(stdin), "", nil
+	}
+
+	path, err := r.uploadScript(script.Body)
+	if err != nil {
+		return "", nil, "", err
+	}
+	return escapeArgs(script.Cmd(path)), stdin, path, nil
+}
+
+func (r *ExecCommand) uploadScript(body string) (string, error) {
+	path, err := remoteTempPath("godexer-script-")
+	if err != nil {
+		return "", err
+	}
+
+	session, err := r.sshClient.NewSession()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get ssh session")
+	}
+	defer session.Close()
+
+	session.Stdin = strings.NewReader(body)
+	if err := session.Run("umask 077 && cat > " + escapeArgs([]string{path})); err != nil {
+		return "", errors.Wrap(err, "failed to upload script")
+	}
+	return path, nil
+}
+
+// host returns the remote address, or an empty string without a client, as
+// when replaying a cassette.
+func (r *ExecCommand) host() string {
+	if r.sshClient == nil {
+		return ""
+	}
+	return r.sshClient.RemoteAddr().String()
+}
+
+func (r *ExecCommand) printCommand(cmd string, variables map[string]any) error {
+	addr := r.host()
 	switch r.CmdRedact {
 	case "":
 		fmt.Fprintf(r.stdout, "%s$ %s\n", addr, cmd)
 	case "-":
 		fmt.Fprintf(r.stdout, "%s$ %s\n", addr, "[command redacted]")
 	default:
-		cmdRedact, ok := godexer.MaybeEvalValue(r.CmdRedact, variables).(string)
-		if !ok {
-			cmdRedact = "[invalid redact value]"
+		cmdRedact, err := r.EvalString("cmdRedact", r.CmdRedact, variables)
+		if err != nil {
+			return err
 		}
 		fmt.Fprintf(r.stdout, "%s$ %s\n", addr, cmdRedact)
 	}
+	return nil
 }
 
-func (r *ExecCommand) createSession() (*ssh.Session, error) {
+func (r *ExecCommand) createSession(pty bool) (*ssh.Session, error) {
 	session, err := r.sshClient.NewSession()
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to get ssh session")
 	}
-
-	modes := ssh.TerminalModes{
-		ssh.ECHO:          1,     // disable echoing
-		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
-		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+	if !pty {
+		return session, nil
 	}
 
-	if err := session.RequestPty("xterm", 80, 40, modes); err != nil {
-		return nil, errors.Wrap(err, "failed to request pty")
+	if err := requestPty(session); err != nil {
+		session.Close()
+		return nil, err
 	}
 
 	return session, nil
 }
 
-func (r *ExecCommand) setupSessionIO(session *ssh.Session, buf *godexer.Buffer) {
-	if r.Variable == "" {
-		session.Stdout = r.Ectx.Stdout
-		session.Stderr = r.Ectx.Stderr
-	} else {
-		session.Stdout = godexer.NewCombinedWriter([]io.Writer{r.Ectx.Stdout, buf})
-		session.Stderr = godexer.NewCombinedWriter([]io.Writer{r.Ectx.Stderr, buf})
+// processEnvironment renders the environment fields, the deprecated Env map
+// first.
+func (r *ExecCommand) processEnvironment(variables map[string]any) (*godexer.ProcessEnv, error) {
+	env := r.ExecEnvironment
+	if len(r.Env) > 0 {
+		merged := make(godexer.ExecEnv, 0, len(r.Env)+len(env.Env))
+		for _, k := range slices.Sorted(maps.Keys(r.Env)) {
+			merged = append(merged, k+"="+r.Env[k])
+		}
+		env.Env = append(merged, env.Env...)
 	}
+	return env.ProcessEnvironment(&r.BaseCommand, variables)
 }
 
-func (r *ExecCommand) setEnvironment(session *ssh.Session, variables map[string]any) error {
-	if r.Env == nil {
-		return nil
-	}
-
-	for k, v := range r.Env {
-		if err := session.Setenv(k, godexer.MaybeEvalValue(v, variables).(string)); err != nil {
-			return errors.Wrap(err, "failed to set ssh environment variable")
+// setEnvironment passes env with session requests, which keeps the values out
+// of the remote command line. It fails if the server rejects a variable, as
+// sshd does for names not listed in AcceptEnv.
+func setEnvironment(session *ssh.Session, env []string) error {
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		if err := session.Setenv(key, value); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (r *ExecCommand) runCommand(session *ssh.Session, cmd string, buf *godexer.Buffer, variables map[string]any) error {
-	if err := session.Start(cmd); err != nil {
-		return err
+// wrapEnvironment prefixes cmd with `cd` and `env` so the working directory and
+// environment do not depend on the server's AcceptEnv settings. The values of
+// variables passed this way are visible in the remote process list.
+func wrapEnvironment(cmd string, penv *godexer.ProcessEnv) string {
+	if penv.Clear || len(penv.Env) > 0 {
+		args := []string{"env"}
+		if penv.Clear {
+			args = append(args, "-i")
+		}
+		cmd = escapeArgs(append(args, penv.Env...)) + " " + cmd
 	}
+	if penv.Dir != "" {
+		cmd = "cd " + escapeArgs([]string{penv.Dir}) + " && " + cmd
+	}
+	return cmd
+}
 
-	err := session.Wait()
-
-	if r.Variable != "" {
-		variables[r.Variable] = buf.String()
+// checkExit decides the outcome of a run that returned err and stores the
+// captured output.
+func (r *ExecCommand) checkExit(err error, capture *godexer.Capture, variables map[string]any) error {
+	exitCode, isExitError := exitStatus(err)
+	if err == nil || isExitError {
+		err = r.CheckExit(&r.BaseCommand, exitCode, err, capture, variables)
 	}
 
+	storeErr := capture.Store(variables)
+
 	if r.AllowFail {
-		r.handleAllowFail(err, variables)
-		return nil
+		variables[r.StepName+"_exit_status"] = exitCode
+		return storeErr
 	}
 
-	return err
-}
-
-func (r *ExecCommand) handleAllowFail(err error, variables map[string]any) {
-	if exitError, ok := err.(*ssh.ExitError); ok {
-		variables[r.StepName+"_exit_status"] = exitError.ExitStatus()
-	} else {
-		variables[r.StepName+"_exit_status"] = 0
+	if err == nil {
+		err = storeErr
 	}
+	return err
 }
 
 func (r *ExecCommand) handleError(err error, variables map[string]any) error {
@@ -207,16 +392,34 @@ func (r *ExecCommand) handleError(err error, variables map[string]any) error {
 		return err
 	}
 
-	if _, ok := err.(*ssh.ExitError); ok {
+	if _, ok := exitStatus(err); ok || errors.Is(err, godexer.ErrFailedWhen) {
 		r.Attempts--
 		r.Ectx.Logger.Infof("Got execution failure, will retry (attempts left %d)", r.Attempts)
-		TimeSleep(time.Duration(r.Delay) * time.Second)
+		if r.Ectx.Clock != nil {
+			r.Ectx.Clock.Sleep(time.Duration(r.Delay) * time.Second)
+		} else {
+			TimeSleep(time.Duration(r.Delay) * time.Second)
+		}
 		return r.Execute(variables)
 	}
 
 	return err
 }
 
+// exitStatus returns the exit status carried by err, which is an
+// *ssh.ExitError for real runs and a godexer.ExitCoder for replayed ones.
+func exitStatus(err error) (int, bool) {
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), true
+	}
+	var ec godexer.ExitCoder
+	if errors.As(err, &ec) {
+		return ec.ExitCode(), true
+	}
+	return 0, false
+}
+
 func (r *ExecCommand) onFailure(commands []json.RawMessage, variables map[string]any) error {
 	cmdScriptObj := struct {
 		Commands any `json:"commands"`
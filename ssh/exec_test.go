@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
 	qt "github.com/frankban/quicktest"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/ssh"
 
 	"github.com/go-extras/godexer"
 	"github.com/go-extras/godexer/internal/logger"
@@ -498,3 +502,428 @@ func TestSSHExec_EscapeArgs(t *testing.T) {
 		c.Assert(output, qt.Contains, "echo")
 	})
 }
+
+func TestSSHExec_Stdin(t *testing.T) {
+	c := qt.New(t)
+
+	signer, err := testutils.MakeSigner(key)
+	c.Assert(err, qt.IsNil)
+
+	// echo stdin back to the client
+	server := testutils.NewServer(signer, nil, func(_ string, ch ssh.Channel) error {
+		data, err := io.ReadAll(ch)
+		if err != nil {
+			return err
+		}
+		if _, err := ch.Write(data); err != nil {
+			return err
+		}
+		if _, err := ch.SendRequest("exit-status", false, ssh.Marshal(&testutils.MsgExit{Status: 0})); err != nil {
+			return err
+		}
+		return ch.Close()
+	})
+	go server.Start()
+	defer server.Stop()
+
+	config, err := testutils.GetClientConfig("testuser", key)
+	c.Assert(err, qt.IsNil)
+	client, err := testutils.CreateConn("127.0.0.1", fmt.Sprintf("%d", server.Addr().Port), config)
+	c.Assert(err, qt.IsNil)
+	defer client.Close()
+
+	fs := afero.NewMemMapFs()
+	c.Assert(afero.WriteFile(fs, "/tmp/query.sql", []byte("SELECT 2;\n"), 0o600), qt.IsNil)
+
+	testcases := []struct {
+		name  string
+		stdin godexer.StdinSource
+		want  string
+	}{
+		{name: "stdin", stdin: godexer.StdinSource{Stdin: "SELECT {{ .id }};\n"}, want: "SELECT 1;\n"},
+		{name: "variable", stdin: godexer.StdinSource{StdinFromVariable: "query"}, want: "SELECT 3;\n"},
+		{name: "file", stdin: godexer.StdinSource{StdinFromFile: "/tmp/query.sql"}, want: "SELECT 2;\n"},
+	}
+
+	for _, tc := range testcases {
+		c.Run(tc.name, func(c *qt.C) {
+			var stdout bytes.Buffer
+			ex := godexer.New(godexer.WithLogger(&logger.Logger{}), godexer.WithFS(fs))
+			cmd := sshexec.NewSSHExecCommand(client, io.Discard, io.Discard)(&godexer.ExecutorContext{
+				Executor: ex,
+				Fs:       fs,
+				Stdout:   &stdout,
+				Stderr:   io.Discard,
+				Logger:   &logger.Logger{},
+			})
+
+			execCmd := cmd.(*sshexec.ExecCommand)
+			execCmd.Cmd = []string{"psql"}
+			execCmd.StepName = "query"
+			execCmd.Variable = "result"
+			execCmd.StdinSource = tc.stdin
+
+			vars := map[string]any{"id": 1, "query": "SELECT 3;\n"}
+			c.Assert(execCmd.Execute(vars), qt.IsNil)
+			c.Assert(vars["result"], qt.Equals, tc.want)
+		})
+	}
+
+	// no pty is requested when stdin is attached
+	c.Assert(server.PtyRequests(), qt.Equals, 0)
+}
+
+func TestSSHExec_Environment(t *testing.T) {
+	c := qt.New(t)
+
+	signer, err := testutils.MakeSigner(key)
+	c.Assert(err, qt.IsNil)
+
+	testcases := []struct {
+		name      string
+		env       string
+		legacyEnv map[string]string
+		acceptEnv bool
+		want      string
+		setenv    []string
+	}{
+		{
+			name: "none",
+			want: "make install",
+		},
+		{
+			name: "dir_and_env",
+			env: `    dir: '/srv/{{ .app }}'
+    env:
+      PREFIX: '/opt/{{ .app }}'
+      DEBUG: 1
+`,
+			want: "cd /srv/web && env DEBUG=1 PREFIX=/opt/web make install",
+		},
+		{
+			name: "clear_env",
+			env: `    clearEnv: true
+    env: ["GREETING=hello world"]
+`,
+			acceptEnv: true,
+			want:      "env -i 'GREETING=hello world' make install",
+		},
+		{
+			name: "setenv",
+			env: `    dir: '/srv/{{ .app }}'
+    env:
+      TOKEN: secret
+`,
+			acceptEnv: true,
+			want:      "cd /srv/web && make install",
+			setenv:    []string{"TOKEN=secret"},
+		},
+		{
+			name:      "deprecated_env",
+			env:       "    env: [B=2]\n",
+			legacyEnv: map[string]string{"C": "3", "A": "{{ .app }}"},
+			want:      "env A=web C=3 B=2 make install",
+		},
+	}
+
+	for _, tc := range testcases {
+		c.Run(tc.name, func(c *qt.C) {
+			received := make(chan string, 10)
+			server := testutils.NewServer(signer, func(cmd string) ([]byte, uint32, bool) {
+				received <- cmd
+				return nil, 0, true
+			}, nil)
+			server.AcceptEnv = tc.acceptEnv
+			go server.Start()
+			defer server.Stop()
+
+			config, err := testutils.GetClientConfig("testuser", key)
+			c.Assert(err, qt.IsNil)
+			client, err := testutils.CreateConn("127.0.0.1", fmt.Sprintf("%d", server.Addr().Port), config)
+			c.Assert(err, qt.IsNil)
+			defer client.Close()
+
+			cmds := godexer.GetRegisteredCommands()
+			cmds["ssh_exec"] = func(ectx *godexer.ExecutorContext) godexer.Command {
+				cmd := sshexec.NewSSHExecCommand(client, io.Discard, io.Discard)(ectx)
+				cmd.(*sshexec.ExecCommand).Env = tc.legacyEnv
+				return cmd
+			}
+			ex, err := godexer.NewWithScenario(`commands:
+  - type: ssh_exec
+    stepName: build
+    cmd: ["make", "install"]
+`+tc.env, godexer.WithCommandTypes(cmds), godexer.WithLogger(&logger.Logger{}))
+			c.Assert(err, qt.IsNil)
+
+			c.Assert(ex.Execute(map[string]any{"app": "web"}), qt.IsNil)
+			c.Assert(<-received, qt.Equals, tc.want)
+			c.Assert(server.Env(), qt.DeepEquals, append([]string(nil), tc.setenv...))
+		})
+	}
+}
+
+func TestSSHExec_Capture(t *testing.T) {
+	c := qt.New(t)
+
+	signer, err := testutils.MakeSigner(key)
+	c.Assert(err, qt.IsNil)
+
+	server := testutils.NewServer(signer, nil, func(_ string, ch ssh.Channel) error {
+		if _, err := ch.Write([]byte(`{"status": "active"}` + "\n")); err != nil {
+			return err
+		}
+		if _, err := ch.Stderr().Write([]byte("deprecated flag\n")); err != nil {
+			return err
+		}
+		if _, err := ch.SendRequest("exit-status", false, ssh.Marshal(&testutils.MsgExit{Status: 0})); err != nil {
+			return err
+		}
+		return ch.Close()
+	})
+	go server.Start()
+	defer server.Stop()
+
+	config, err := testutils.GetClientConfig("testuser", key)
+	c.Assert(err, qt.IsNil)
+	client, err := testutils.CreateConn("127.0.0.1", fmt.Sprintf("%d", server.Addr().Port), config)
+	c.Assert(err, qt.IsNil)
+	defer client.Close()
+
+	cmds := godexer.GetRegisteredCommands()
+	cmds["ssh_exec"] = sshexec.NewSSHExecCommand(client, io.Discard, io.Discard)
+	ex, err := godexer.NewWithScenario(`commands:
+  - type: ssh_exec
+    stepName: status
+    cmd: ["systemctl", "status", "--output=json"]
+    stdoutVariable: status
+    stderrVariable: warnings
+    trim: true
+    parse: json
+`, godexer.WithCommandTypes(cmds), godexer.WithLogger(&logger.Logger{}),
+		godexer.WithStdout(io.Discard), godexer.WithStderr(io.Discard))
+	c.Assert(err, qt.IsNil)
+
+	vars := map[string]any{}
+	c.Assert(ex.Execute(vars), qt.IsNil)
+	c.Assert(vars["status"], qt.DeepEquals, map[string]any{"status": "active"})
+	c.Assert(vars["warnings"], qt.Equals, "deprecated flag")
+
+	// separate streams need a session without a pty
+	c.Assert(server.PtyRequests(), qt.Equals, 0)
+}
+
+func TestSSHExec_Script(t *testing.T) {
+	c := qt.New(t)
+
+	signer, err := testutils.MakeSigner(key)
+	c.Assert(err, qt.IsNil)
+
+	type request struct {
+		cmd   string
+		stdin string
+	}
+	received := make(chan request, 10)
+	server := testutils.NewServer(signer, nil, func(cmd string, ch ssh.Channel) error {
+		data, err := io.ReadAll(ch)
+		if err != nil {
+			return err
+		}
+		received <- request{cmd: cmd, stdin: string(data)}
+		if _, err := ch.SendRequest("exit-status", false, ssh.Marshal(&testutils.MsgExit{Status: 0})); err != nil {
+			return err
+		}
+		return ch.Close()
+	})
+	go server.Start()
+	defer server.Stop()
+
+	config, err := testutils.GetClientConfig("testuser", key)
+	c.Assert(err, qt.IsNil)
+	client, err := testutils.CreateConn("127.0.0.1", fmt.Sprintf("%d", server.Addr().Port), config)
+	c.Assert(err, qt.IsNil)
+	defer client.Close()
+
+	run := func(c *qt.C, fields string) {
+		cmds := godexer.GetRegisteredCommands()
+		cmds["ssh_exec"] = sshexec.NewSSHExecCommand(client, io.Discard, io.Discard)
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: ssh_exec
+    stepName: setup
+`+fields, godexer.WithCommandTypes(cmds), godexer.WithLogger(&logger.Logger{}))
+		c.Assert(err, qt.IsNil)
+		c.Assert(ex.Execute(map[string]any{"name": "web"}), qt.IsNil)
+	}
+
+	c.Run("piped", func(c *qt.C) {
+		run(c, `    script: |
+      mkdir -p /srv/{{ .name }}
+`)
+		req := <-received
+		c.Assert(req.cmd, qt.Equals, "/bin/sh -e")
+		c.Assert(req.stdin, qt.Equals, "mkdir -p /srv/web\n")
+	})
+
+	c.Run("uploaded", func(c *qt.C) {
+		run(c, `    interpreter: bash
+    args: ["{{ .name }}"]
+    env: {MODE: prod}
+    script: echo "$1"
+`)
+		upload := <-received
+		c.Assert(upload.cmd, qt.Matches, `umask 077 && cat > /tmp/godexer-script-[0-9a-f]{16}`)
+		c.Assert(upload.stdin, qt.Equals, `echo "$1"`)
+
+		path := upload.cmd[len("umask 077 && cat > "):]
+		req := <-received
+		c.Assert(req.cmd, qt.Equals, "env MODE=prod bash "+path+" web; rc=$?; rm -f -- "+path+"; exit $rc")
+	})
+}
+
+func TestSSHExec_ExitCriteria(t *testing.T) {
+	c := qt.New(t)
+
+	signer, err := testutils.MakeSigner(key)
+	c.Assert(err, qt.IsNil)
+
+	// behaves like `systemctl is-active`: inactive units exit with 3
+	server := testutils.NewServer(signer, func(cmd string) ([]byte, uint32, bool) {
+		if strings.HasSuffix(cmd, "nginx") {
+			return []byte("active\n"), 0, true
+		}
+		return []byte("inactive\n"), 3, true
+	}, nil)
+	go server.Start()
+	defer server.Stop()
+
+	config, err := testutils.GetClientConfig("testuser", key)
+	c.Assert(err, qt.IsNil)
+	client, err := testutils.CreateConn("127.0.0.1", fmt.Sprintf("%d", server.Addr().Port), config)
+	c.Assert(err, qt.IsNil)
+	defer client.Close()
+
+	cmds := godexer.GetRegisteredCommands()
+	cmds["ssh_exec"] = sshexec.NewSSHExecCommand(client, io.Discard, io.Discard)
+	ex, err := godexer.NewWithScenario(`commands:
+  - type: ssh_exec
+    stepName: nginx
+    cmd: ["systemctl", "is-active", "nginx"]
+    successCodes: [0, 3]
+    changedWhen: "false"
+  - type: ssh_exec
+    stepName: redis
+    cmd: ["systemctl", "is-active", "redis"]
+    successCodes: [0, 3]
+    changedWhen: exit_status == 3
+  - type: ssh_exec
+    stepName: postgres
+    cmd: ["systemctl", "is-active", "postgres"]
+    failedWhen: stdout != 'active'
+    allowFail: true
+`, godexer.WithCommandTypes(cmds), godexer.WithLogger(&logger.Logger{}))
+	c.Assert(err, qt.IsNil)
+
+	vars := map[string]any{}
+	c.Assert(ex.Execute(vars), qt.IsNil)
+	c.Assert(vars["__step:nginx:changed"], qt.IsFalse)
+	c.Assert(vars["__step:redis:changed"], qt.IsTrue)
+	c.Assert(vars["postgres_exit_status"], qt.Equals, 3)
+	c.Assert(vars["__step:postgres:changed"], qt.IsFalse)
+}
+
+func TestSSHExec_Output(t *testing.T) {
+	c := qt.New(t)
+
+	signer, err := testutils.MakeSigner(key)
+	c.Assert(err, qt.IsNil)
+
+	server := testutils.NewServer(signer, func(string) ([]byte, uint32, bool) {
+		return []byte("line one\nline two\n"), 0, true
+	}, nil)
+	go server.Start()
+	defer server.Stop()
+
+	config, err := testutils.GetClientConfig("testuser", key)
+	c.Assert(err, qt.IsNil)
+	client, err := testutils.CreateConn("127.0.0.1", fmt.Sprintf("%d", server.Addr().Port), config)
+	c.Assert(err, qt.IsNil)
+	defer client.Close()
+
+	cmds := godexer.GetRegisteredCommands()
+	cmds["ssh_exec"] = sshexec.NewSSHExecCommand(client, io.Discard, io.Discard)
+	var stdout bytes.Buffer
+	ex, err := godexer.NewWithScenario(`commands:
+  - type: ssh_exec
+    stepName: uptime
+    cmd: ["uptime"]
+`, godexer.WithCommandTypes(cmds), godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(&stdout),
+		godexer.WithOutputOptions(godexer.OutputOptions{Prefix: []string{"host", "step"}}))
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(ex.Execute(map[string]any{}), qt.IsNil)
+	host := client.RemoteAddr().String()
+	c.Assert(stdout.String(), qt.Equals, "["+host+"] [uptime] line one\n["+host+"] [uptime] line two\n")
+}
+
+func TestSSHExec_Cassette(t *testing.T) {
+	c := qt.New(t)
+
+	signer, err := testutils.MakeSigner(key)
+	c.Assert(err, qt.IsNil)
+
+	server := testutils.NewServer(signer, nil, func(cmd string, ch ssh.Channel) error {
+		if _, err := ch.Write([]byte(cmd + "\n")); err != nil {
+			return err
+		}
+		if _, err := ch.SendRequest("exit-status", false, ssh.Marshal(&testutils.MsgExit{Status: 0})); err != nil {
+			return err
+		}
+		return ch.Close()
+	})
+	go server.Start()
+	defer server.Stop()
+
+	config, err := testutils.GetClientConfig("testuser", key)
+	c.Assert(err, qt.IsNil)
+	client, err := testutils.CreateConn("127.0.0.1", fmt.Sprintf("%d", server.Addr().Port), config)
+	c.Assert(err, qt.IsNil)
+	defer client.Close()
+
+	const scenario = `commands:
+  - type: ssh_exec
+    stepName: greet
+    cmd: ["echo", "{{ .name }}"]
+    env: {LANG: C}
+    stdoutVariable: greeting
+    trim: true
+`
+	run := func(client *ssh.Client, opt godexer.Option) (map[string]any, error) {
+		cmds := godexer.GetRegisteredCommands()
+		cmds["ssh_exec"] = sshexec.NewSSHExecCommand(client, io.Discard, io.Discard)
+		ex, err := godexer.NewWithScenario(scenario, opt, godexer.WithCommandTypes(cmds),
+			godexer.WithLogger(&logger.Logger{}), godexer.WithStdout(io.Discard), godexer.WithStderr(io.Discard))
+		c.Assert(err, qt.IsNil)
+		vars := map[string]any{"name": "John"}
+		return vars, ex.Execute(vars)
+	}
+
+	cassette := godexer.NewCassette()
+	vars, err := run(client, godexer.WithRecording(cassette))
+	c.Assert(err, qt.IsNil)
+	c.Assert(vars["greeting"], qt.Equals, "env LANG=C echo John")
+	c.Assert(cassette.Interactions, qt.HasLen, 1)
+	i := cassette.Interactions[0]
+	c.Assert(i.Type, qt.Equals, godexer.InvocationSSHExec)
+	c.Assert(i.Host, qt.Equals, client.RemoteAddr().String())
+	c.Assert(i.Argv, qt.DeepEquals, []string{"echo", "John"})
+	c.Assert(i.Env, qt.DeepEquals, []string{"LANG=C"})
+	c.Assert(i.Stdout, qt.Equals, "env LANG=C echo John\n")
+
+	// no client is needed to replay
+	vars, err = run(nil, godexer.WithReplay(cassette))
+	c.Assert(err, qt.IsNil)
+	c.Assert(vars["greeting"], qt.Equals, "env LANG=C echo John")
+
+	_, err = run(nil, godexer.WithReplay(cassette))
+	c.Assert(err, qt.ErrorIs, godexer.ErrNotRecorded)
+}
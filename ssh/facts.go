@@ -0,0 +1,86 @@
+package ssh
+
+import (
+	"bytes"
+	"io/fs"
+	"strings"
+
+	"github.com/go-extras/errors"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/go-extras/godexer"
+)
+
+// NewSSHFactsCommand creates a facts command gathering facts from the remote host.
+func NewSSHFactsCommand(sshClient *ssh.Client) func(ectx *godexer.ExecutorContext) godexer.Command {
+	return godexer.NewFactsCommandWithSource(NewFactsSource(sshClient))
+}
+
+// NewFactsSource returns a godexer.FactsSource that reads the remote host by
+// running `cat`, `ls`, `test` and `uname` over SSH.
+func NewFactsSource(sshClient *ssh.Client) godexer.FactsSource {
+	return &factsSource{sshClient: sshClient}
+}
+
+type factsSource struct {
+	sshClient *ssh.Client
+}
+
+func (s *factsSource) ReadFile(name string) ([]byte, error) {
+	out, err := s.run("cat -- " + escapeArgs([]string{name}))
+	if err != nil {
+		return nil, notExist(name, err)
+	}
+	return out, nil
+}
+
+func (s *factsSource) ReadDir(name string) ([]string, error) {
+	out, err := s.run("ls -1A -- " + escapeArgs([]string{name}))
+	if err != nil {
+		return nil, notExist(name, err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+func (s *factsSource) Exists(name string) (bool, error) {
+	_, err := s.run("test -e " + escapeArgs([]string{name}))
+	if err == nil {
+		return true, nil
+	}
+	if _, ok := err.(*ssh.ExitError); ok {
+		return false, nil
+	}
+	return false, err
+}
+
+func (s *factsSource) Arch() (string, error) {
+	out, err := s.run("uname -m")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (s *factsSource) run(cmd string) ([]byte, error) {
+	session, err := s.sshClient.NewSession()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get ssh session")
+	}
+	defer session.Close()
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Run(cmd); err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// notExist reports a failed remote command as a missing path, so the fact is
+// skipped. Transport errors are returned as is.
+func notExist(name string, err error) error {
+	if _, ok := err.(*ssh.ExitError); ok {
+		return errors.Wrapf(errors.WithEquivalents(err, fs.ErrNotExist), "cannot read %s", name)
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package ssh_test
+
+import (
+	"fmt"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-extras/godexer"
+	"github.com/go-extras/godexer/internal/testutils"
+	sshexec "github.com/go-extras/godexer/ssh"
+)
+
+func TestSSHFacts(t *testing.T) {
+	c := qt.New(t)
+
+	signer, err := testutils.MakeSigner(key)
+	c.Assert(err, qt.IsNil)
+
+	responses := map[string]string{
+		"cat -- /etc/os-release":            "ID=debian\nVERSION_ID=\"12\"\n",
+		"cat -- /proc/sys/kernel/osrelease": "6.1.0-18-amd64\n",
+		"cat -- /proc/sys/kernel/hostname":  "db-01\n",
+		"uname -m":                          "aarch64\n",
+		"ls -1A -- /sys/class/net":          "eth0\n",
+		"cat -- /sys/class/net/eth0/mtu":    "9000\n",
+		"test -e /usr/bin/apt-get":          "",
+	}
+	server := testutils.NewServer(signer, func(cmd string) ([]byte, uint32, bool) {
+		out, ok := responses[cmd]
+		if !ok {
+			return nil, 1, true
+		}
+		return []byte(out), 0, true
+	}, nil)
+	go server.Start()
+	defer server.Stop()
+
+	config, err := testutils.GetClientConfig("testuser", key)
+	c.Assert(err, qt.IsNil)
+	client, err := testutils.CreateConn("127.0.0.1", fmt.Sprintf("%d", server.Addr().Port), config)
+	c.Assert(err, qt.IsNil)
+	defer client.Close()
+
+	cmds := godexer.GetRegisteredCommands()
+	cmds["ssh_facts"] = sshexec.NewSSHFactsCommand(client)
+	ex, err := godexer.NewWithScenario(`commands:
+  - type: ssh_facts
+    stepName: gather
+    variable: remote
+`, godexer.WithCommandTypes(cmds))
+	c.Assert(err, qt.IsNil)
+
+	vars := map[string]any{}
+	c.Assert(ex.Execute(vars), qt.IsNil)
+	c.Assert(vars["remote"], qt.DeepEquals, map[string]any{
+		"os":              map[string]any{"id": "debian", "version_id": "12"},
+		"kernel":          "6.1.0-18-amd64",
+		"arch":            "aarch64",
+		"hostname":        "db-01",
+		"interfaces":      []any{map[string]any{"name": "eth0", "mtu": 9000}},
+		"package_manager": "apt",
+	})
+}
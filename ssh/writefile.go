@@ -2,7 +2,6 @@ package ssh
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"os"
 	"strings"
@@ -28,6 +27,8 @@ func NewScpWriterFileCommand(sshClient *ssh.Client) func(ectx *godexer.ExecutorC
 
 type ScpWriteFileCommand struct {
 	godexer.BaseCommand
+	godexer.BecomeOptions
+	godexer.WriteOptions
 	sshClient            *ssh.Client
 	File                 string
 	Contents             string
@@ -44,57 +45,104 @@ func (r *ScpWriteFileCommand) Execute(variables map[string]any) error {
 	if r.Permissions == "" {
 		return errors.Errorf("filemode permissions in %q are empty", r.StepName)
 	}
+	mode, err := godexer.ParsePermissions(r.Permissions)
+	if err != nil {
+		return err
+	}
 
-	remoteFileName, ok := godexer.MaybeEvalValue(r.File, variables).(string)
-	if !ok {
-		return errors.Errorf("filename in %q must be a string", r.StepName)
+	remoteFileName, err := r.EvalString("file", r.File, variables)
+	if err != nil {
+		return err
 	}
 
 	var reader io.Reader
 
 	switch {
 	case r.ContentsFromVariable != "":
-		variable, ok := godexer.MaybeEvalValue(r.ContentsFromVariable, variables).(string)
-		if !ok {
-			return errors.Errorf("contents_from_variable in %q must be a string", r.StepName)
+		variable, err := r.EvalString("contentsFromVariable", r.ContentsFromVariable, variables)
+		if err != nil {
+			return err
 		}
-		switch v := variables[variable].(type) {
-		case string:
-			reader = strings.NewReader(v)
-		case []byte:
-			reader = bytes.NewReader(v)
-		case fmt.Stringer:
-			reader = strings.NewReader(v.String())
+		contents, err := godexer.VariableContents(variables, variable)
+		if err != nil {
+			return err
 		}
+		reader = bytes.NewReader(contents)
 	case r.ContentsFromFile != "":
-		fileName := godexer.MaybeEvalValue(r.ContentsFromFile, variables)
-		f, err := os.Open(fileName.(string))
+		fileName, err := r.EvalString("contentsFromFile", r.ContentsFromFile, variables)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(fileName)
 		if err != nil {
 			return errors.Wrap(err, "can't open local file")
 		}
 		defer f.Close()
 		reader = f
 	default:
-		contents := godexer.MaybeEvalValue(r.Contents, variables)
-		reader = strings.NewReader(contents.(string))
+		contents, err := r.EvalString("contents", r.Contents, variables)
+		if err != nil {
+			return err
+		}
+		reader = strings.NewReader(contents)
 	}
 
-	session, err := r.sshClient.NewSession()
+	escalation, err := r.Escalation(&r.BaseCommand, variables)
+	if err != nil {
+		return err
+	}
+
+	return install(r.sshClient, &r.BaseCommand, &r.WriteOptions, escalation, reader, remoteFileName, godexer.FormatPermissions(mode), r.Timeout, variables)
+}
+
+// upload copies the contents of reader to remoteFileName with scp.
+func upload(sshClient *ssh.Client, reader io.Reader, remoteFileName, permissions string, timeout int) error {
+	session, err := sshClient.NewSession()
 	if err != nil {
 		return errors.Wrap(err, "unable to get ssh session")
 	}
 	defer session.Close()
-	client := scp.NewClient(r.sshClient.Conn, session)
+	client := scp.NewClient(sshClient.Conn, session)
 
-	if r.Timeout > 0 {
-		client.Timeout = time.Duration(r.Timeout) * time.Second
+	if timeout > 0 {
+		client.Timeout = time.Duration(timeout) * time.Second
 	}
 
-	r.Ectx.Logger.Debugf("Writing to %s", remoteFileName)
-	err = client.CopyFile(reader, remoteFileName, r.Permissions)
+	return client.CopyFile(reader, remoteFileName, permissions)
+}
+
+// install uploads the file to a temp path as the login user and installs it
+// in place. With an escalation set, the contents are streamed to the install
+// through the escalation instead, as the become user may not be able to read
+// the upload.
+func install(sshClient *ssh.Client, r *godexer.BaseCommand, w *godexer.WriteOptions, escalation *godexer.Escalation, reader io.Reader, remoteFileName, mode string, timeout int, variables map[string]any) error {
+	source, stdin := "-", reader
+	if escalation == nil {
+		tmp, err := remoteTempPath("godexer-upload-")
+		if err != nil {
+			return err
+		}
+		source, stdin = tmp, nil
+	}
+	install, err := w.Install(r, variables, source, remoteFileName, mode)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	user := ""
+	if escalation != nil {
+		user = " as " + escalation.User
+	}
+	r.Ectx.Logger.Debugf("Writing to %s%s", remoteFileName, user)
+	if escalation == nil {
+		if err := upload(sshClient, reader, source, "0600", timeout); err != nil {
+			return err
+		}
+	}
+
+	var output bytes.Buffer
+	if err := runAs(sshClient, escalation, install.Argv(), stdin, &output, r.Ectx.Stderr); err != nil {
+		return errors.Wrapf(err, "failed to install %s%s", remoteFileName, user)
+	}
+	return w.Finish(install, output.String())
 }
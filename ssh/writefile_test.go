@@ -1,11 +1,20 @@
 package ssh_test
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
+	"golang.org/x/crypto/ssh"
 
 	"github.com/go-extras/godexer"
 	"github.com/go-extras/godexer/internal/logger"
@@ -92,3 +101,142 @@ func TestScpWriteFile(t *testing.T) {
 		c.Assert(err, qt.ErrorMatches, `filemode permissions in "test_step" are empty`)
 	})
 }
+
+// shellHandler stands in for a real host: it stores scp uploads on the local
+// disk and runs other commands with the local shell.
+func shellHandler(cmd string, ch ssh.Channel) error {
+	status := 0
+	if path, ok := strings.CutPrefix(cmd, "scp -qt "); ok {
+		data, err := receiveSCP(bufio.NewReader(ch), ch)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(strings.Trim(path, "'"), []byte(data), 0o600); err != nil {
+			return err
+		}
+	} else {
+		sh := exec.Command("sh", "-c", cmd)
+		sh.Stdout, sh.Stderr = ch, ch.Stderr()
+		sh.WaitDelay = time.Second
+		// like sshd, don't wait for the client to close stdin
+		stdin, err := sh.StdinPipe()
+		if err != nil {
+			return err
+		}
+		if err := sh.Start(); err != nil {
+			return err
+		}
+		go func() {
+			_, _ = io.Copy(stdin, ch)
+			_ = stdin.Close()
+		}()
+		err = sh.Wait()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			status = exitErr.ExitCode()
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if _, err := ch.SendRequest("exit-status", false, ssh.Marshal(&testutils.MsgExit{Status: uint32(status)})); err != nil {
+		return err
+	}
+	return ch.Close()
+}
+
+func TestScpWriteFile_Update(t *testing.T) {
+	c := qt.New(t)
+
+	signer, err := testutils.MakeSigner(key)
+	c.Assert(err, qt.IsNil)
+	server := testutils.NewServer(signer, nil, shellHandler)
+	go server.Start()
+	defer server.Stop()
+
+	config, err := testutils.GetClientConfig("testuser", key)
+	c.Assert(err, qt.IsNil)
+	client, err := testutils.CreateConn("127.0.0.1", fmt.Sprintf("%d", server.Addr().Port), config)
+	c.Assert(err, qt.IsNil)
+	defer client.Close()
+
+	dir := filepath.Join(t.TempDir(), "nginx")
+	file := filepath.Join(dir, "nginx.conf")
+	cmds := godexer.GetRegisteredCommands()
+	cmds["scp_writefile"] = sshexec.NewScpWriterFileCommand(client)
+	ex, err := godexer.NewWithScenario(`commands:
+  - type: scp_writefile
+    stepName: config
+    file: '{{ .file }}'
+    contents: '{{ .contents }}'
+    permissions: "0640"
+    owner: '{{ .uid }}'
+    mkdirs: true
+    backup: true
+    validate: ["grep", "-q", "worker_processes", "%s"]
+`, godexer.WithCommandTypes(cmds), godexer.WithLogger(&logger.Logger{}),
+		godexer.WithStdout(io.Discard), godexer.WithStderr(io.Discard))
+	c.Assert(err, qt.IsNil)
+
+	run := func(contents string) (map[string]any, error) {
+		vars := map[string]any{"file": file, "contents": contents, "uid": os.Getuid()}
+		return vars, ex.Execute(vars)
+	}
+	files := func() []string {
+		entries, err := os.ReadDir(dir)
+		c.Assert(err, qt.IsNil)
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	vars, err := run("worker_processes 4;")
+	c.Assert(err, qt.IsNil)
+	c.Assert(vars["__step:config:changed"], qt.IsTrue)
+	data, err := os.ReadFile(file)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, "worker_processes 4;")
+	info, err := os.Stat(file)
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.Mode().Perm(), qt.Equals, os.FileMode(0o640))
+	c.Assert(files(), qt.DeepEquals, []string{"nginx.conf"})
+
+	vars, err = run("worker_processes 4;")
+	c.Assert(err, qt.IsNil)
+	c.Assert(vars["__step:config:changed"], qt.IsFalse)
+
+	vars, err = run("worker_processes 8;")
+	c.Assert(err, qt.IsNil)
+	c.Assert(vars["__step:config:changed"], qt.IsTrue)
+	names := files()
+	c.Assert(names, qt.HasLen, 2)
+	c.Assert(names[1], qt.Matches, `nginx\.conf\.\d{8}T\d{6}\.bak`)
+	data, err = os.ReadFile(filepath.Join(dir, names[1]))
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, "worker_processes 4;")
+
+	_, err = run("events {}")
+	c.Assert(err, qt.ErrorIs, godexer.ErrValidationFailed)
+	data, err = os.ReadFile(file)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, "worker_processes 8;")
+	c.Assert(files(), qt.HasLen, 2)
+
+	// the setgid and sticky bits reach chmod
+	ex, err = godexer.NewWithScenario(`commands:
+  - type: scp_writefile
+    stepName: shared
+    file: '{{ .file }}'
+    contents: "shared"
+    permissions: "3770"
+`, godexer.WithCommandTypes(cmds), godexer.WithLogger(&logger.Logger{}),
+		godexer.WithStdout(io.Discard), godexer.WithStderr(io.Discard))
+	c.Assert(err, qt.IsNil)
+	shared := filepath.Join(dir, "shared")
+	c.Assert(ex.Execute(map[string]any{"file": shared}), qt.IsNil)
+	info, err = os.Stat(shared)
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.Mode(), qt.Equals, os.ModeSetgid|os.ModeSticky|0o770)
+}
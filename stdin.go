@@ -0,0 +1,73 @@
+package godexer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-extras/errors"
+	"github.com/spf13/afero"
+)
+
+// StdinSource holds the stdin fields shared by the exec commands. At most one
+// of them may be set.
+type StdinSource struct {
+	// Stdin is a templated string written to the process' stdin.
+	Stdin string
+	// StdinFromVariable names a variable (string, []byte or fmt.Stringer) to write to stdin.
+	StdinFromVariable string
+	// StdinFromFile is a file, read through the executor's Fs, to write to stdin.
+	StdinFromFile string
+}
+
+// StdinReader returns a reader for the configured stdin, or nil if none is set.
+func (s *StdinSource) StdinReader(r *BaseCommand, variables map[string]any) (io.Reader, error) {
+	set := 0
+	for _, v := range []string{s.Stdin, s.StdinFromVariable, s.StdinFromFile} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, errors.New("only one of stdin, stdinFromVariable and stdinFromFile may be set")
+	}
+
+	switch {
+	case s.Stdin != "":
+		stdin, err := r.EvalString("stdin", s.Stdin, variables)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(stdin), nil
+	case s.StdinFromVariable != "":
+		name, err := r.EvalString("stdinFromVariable", s.StdinFromVariable, variables)
+		if err != nil {
+			return nil, err
+		}
+		switch v := variables[name].(type) {
+		case string:
+			return strings.NewReader(v), nil
+		case []byte:
+			return bytes.NewReader(v), nil
+		case fmt.Stringer:
+			return strings.NewReader(v.String()), nil
+		case nil:
+			return nil, errors.Errorf("stdin variable %q is not set", name)
+		default:
+			return nil, errors.Errorf("stdin variable %q must be a string, got %T", name, v)
+		}
+	case s.StdinFromFile != "":
+		name, err := r.EvalString("stdinFromFile", s.StdinFromFile, variables)
+		if err != nil {
+			return nil, err
+		}
+		data, err := afero.ReadFile(r.Ectx.Fs, name)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't read stdin file")
+		}
+		return bytes.NewReader(data), nil
+	default:
+		return nil, nil
+	}
+}
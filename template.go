@@ -0,0 +1,441 @@
+package godexer
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/go-extras/errors"
+)
+
+const (
+	templateSnippetLen = 80
+	typedExprOpen      = "${{"
+	typedExprClose     = "}}"
+)
+
+var registeredValueFuncs = make(map[string]any)
+
+// TemplateError is returned by strict template rendering when a templated
+// field fails to parse or execute.
+type TemplateError struct {
+	// Field is the name of the command field that holds the template.
+	Field string
+	// Template is the (possibly truncated) template source.
+	Template string
+	Err      error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("template error in field %q (template: %q): %v", e.Field, e.Template, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+func newTemplateError(field, src string, err error) *TemplateError {
+	snippet := src
+	if len(snippet) > templateSnippetLen {
+		snippet = snippet[:templateSnippetLen] + "..."
+	}
+	return &TemplateError{
+		Field:    field,
+		Template: snippet,
+		Err:      err,
+	}
+}
+
+// MaybeEvalValue renders val as a Go template if it is a string.
+// Maps and lists are rendered recursively into new values.
+// A string of the form `${{ expression }}` is evaluated as an expr-language
+// expression and its native result (number, bool, list, ...) is returned.
+// If a template fails to parse or execute, the raw value is returned.
+func MaybeEvalValue(val any, variables map[string]any) any {
+	switch v := val.(type) {
+	case string:
+		return maybeEvalString(v, variables)
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for k, item := range v {
+			result[k] = MaybeEvalValue(item, variables)
+		}
+		return result
+	case []any:
+		result := make([]any, 0, len(v))
+		for _, item := range v {
+			result = append(result, MaybeEvalValue(item, variables))
+		}
+		return result
+	default:
+		return val
+	}
+}
+
+func maybeEvalString(src string, variables map[string]any) any {
+	if code, ok := typedExpression(src); ok {
+		key := expressionKey{src: code, engine: experimentExpr}
+		compiled := globalTemplateCache.expression(key, func() compiledExpression {
+			return compileExpr(code, nil)
+		})
+		result, err := runExpr(compiled, variables)
+		if err != nil {
+			return src
+		}
+		return result
+	}
+
+	key := templateKey{src: src, globalFuncs: valueFuncsGeneration.Load()}
+	tmpl, err := globalTemplateCache.template(key, globalValueFuncs)
+	if err != nil {
+		return src
+	}
+
+	result, err := executeTemplate(tmpl, variables)
+	if err != nil {
+		return src
+	}
+
+	return result
+}
+
+// typedExpression extracts the expression from a `${{ expression }}` value.
+func typedExpression(src string) (string, bool) {
+	trimmed := strings.TrimSpace(src)
+	if !strings.HasPrefix(trimmed, typedExprOpen) || !strings.HasSuffix(trimmed, typedExprClose) {
+		return "", false
+	}
+
+	code := trimmed[len(typedExprOpen) : len(trimmed)-len(typedExprClose)]
+	if strings.Contains(code, typedExprOpen) {
+		// `${{ a }} and ${{ b }}` is not a single expression
+		return "", false
+	}
+	return strings.TrimSpace(code), true
+}
+
+// RegisterValueFunc registers template value functions globally, for every
+// executor in the process. Prefer WithValueFunc, which keeps functions scoped
+// to a single executor and its children.
+// Not safe for concurrent usage.
+func RegisterValueFunc(name string, fn any) {
+	registeredValueFuncs[name] = fn
+	valueFuncsGeneration.Add(1)
+}
+
+// UnregisterValueFunc unregisters template value functions.
+// Not safe for concurrent usage.
+func UnregisterValueFunc(name string) {
+	delete(registeredValueFuncs, name)
+	valueFuncsGeneration.Add(1)
+}
+
+// WithValueFunc registers a template value function on the executor.
+// It is inherited by child executors (foreach, include, commands).
+func WithValueFunc(name string, fn any) func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.RegisterValueFunc(name, fn)
+	}
+}
+
+// WithValueFuncs registers template value functions on the executor.
+// They are inherited by child executors (foreach, include, commands).
+func WithValueFuncs(funcs map[string]any) func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.RegisterValueFuncs(funcs)
+	}
+}
+
+// WithEvaluatorFunctionsInTemplates exposes the executor's evaluator functions
+// (see RegisterEvaluatorFunction) inside templates, so that one function serves
+// both `requires:` expressions and templates. Value functions registered with
+// WithValueFunc take precedence over evaluator functions with the same name.
+func WithEvaluatorFunctionsInTemplates() func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.evaluatorFunctionsInTemplates = true
+		ex.funcSetVersion = nextFuncSetVersion()
+	}
+}
+
+func withInheritedValueFuncs(funcs map[string]any, evaluatorFunctionsInTemplates bool) func(ex *Executor) {
+	return func(ex *Executor) {
+		ex.valueFuncs = make(map[string]any, len(funcs))
+		for name, fn := range funcs {
+			ex.valueFuncs[name] = fn
+		}
+		ex.evaluatorFunctionsInTemplates = evaluatorFunctionsInTemplates
+	}
+}
+
+// RegisterValueFunc registers a template value function on the executor.
+func (ex *Executor) RegisterValueFunc(name string, fn any) *Executor {
+	ex.valueFuncs[name] = fn
+	ex.funcSetVersion = nextFuncSetVersion()
+	return ex
+}
+
+// RegisterValueFuncs registers template value functions on the executor.
+func (ex *Executor) RegisterValueFuncs(funcs map[string]any) *Executor {
+	for name, fn := range funcs {
+		ex.valueFuncs[name] = fn
+	}
+	ex.funcSetVersion = nextFuncSetVersion()
+	return ex
+}
+
+// templateFuncs builds the function map for the executor's templates:
+// built-ins, then global value functions, then evaluator functions (if
+// enabled), then the executor's own value functions.
+func (ex *Executor) templateFuncs() template.FuncMap {
+	fnMap := globalValueFuncs()
+	if ex.evaluatorFunctionsInTemplates {
+		for name, fn := range ex.evaluatorFunctions {
+			fnMap[name] = fn
+		}
+	}
+	for name, fn := range ex.valueFuncs {
+		fnMap[name] = fn
+	}
+	return fnMap
+}
+
+func globalValueFuncs() template.FuncMap {
+	fnMap := builtinValueFuncs()
+	for k, v := range registeredValueFuncs {
+		fnMap[k] = v
+	}
+	return fnMap
+}
+
+func parseTemplate(src string, funcs template.FuncMap, strict bool) (*template.Template, error) {
+	tmpl := template.New("tpl").Funcs(funcs)
+	if strict {
+		tmpl = tmpl.Option("missingkey=error").Funcs(template.FuncMap{"index": strictIndex})
+	}
+
+	return tmpl.Parse(src)
+}
+
+func executeTemplate(tmpl *template.Template, variables map[string]any) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, variables); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// EvalValue renders a templated field value. Like MaybeEvalValue, it renders
+// maps and lists recursively and supports typed `${{ expression }}` values,
+// which can use the executor's evaluator functions.
+//
+// In strict mode (see WithStrictTemplates) parse and execution errors are
+// returned as *TemplateError and missing keys are errors. Otherwise it behaves
+// like MaybeEvalValue and never fails.
+func (ex *Executor) EvalValue(field string, val any, variables map[string]any) (any, error) {
+	switch v := val.(type) {
+	case string:
+		return ex.evalString(field, v, variables)
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for k, item := range v {
+			rendered, err := ex.EvalValue(field+"."+k, item, variables)
+			if err != nil {
+				return nil, err
+			}
+			result[k] = rendered
+		}
+		return result, nil
+	case []any:
+		result := make([]any, 0, len(v))
+		for i, item := range v {
+			rendered, err := ex.EvalValue(fmt.Sprintf("%s[%d]", field, i), item, variables)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, rendered)
+		}
+		return result, nil
+	default:
+		return val, nil
+	}
+}
+
+func (ex *Executor) evalString(field, src string, variables map[string]any) (any, error) {
+	if code, ok := typedExpression(src); ok {
+		key := expressionKey{src: code, funcSet: ex.funcSetVersion, engine: experimentExpr}
+		compiled := ex.cache.expression(key, func() compiledExpression {
+			return compileExpr(code, ex.evaluatorFunctions.exprOptions())
+		})
+		result, err := runExpr(compiled, variables)
+		if err == nil {
+			return result, nil
+		}
+		if !ex.strictTemplates {
+			return src, nil
+		}
+		return nil, newTemplateError(field, src, err)
+	}
+
+	key := templateKey{
+		src:         src,
+		funcSet:     ex.funcSetVersion,
+		globalFuncs: valueFuncsGeneration.Load(),
+		strict:      ex.strictTemplates,
+	}
+	tmpl, err := ex.cache.template(key, ex.templateFuncs)
+	if err == nil {
+		var result string
+		result, err = executeTemplate(tmpl, variables)
+		if err == nil {
+			return result, nil
+		}
+	}
+
+	if !ex.strictTemplates {
+		return src, nil
+	}
+	return nil, newTemplateError(field, src, err)
+}
+
+// EvalValue renders a templated field value through the owning executor.
+// Commands that are not attached to an executor use lenient evaluation.
+func (r *BaseCommand) EvalValue(field string, val any, variables map[string]any) (any, error) {
+	if r.Ectx == nil || r.Ectx.Executor == nil {
+		return MaybeEvalValue(val, variables), nil
+	}
+
+	return r.Ectx.Executor.EvalValue(field, val, variables)
+}
+
+// EvalString is like EvalValue, but always returns a string.
+func (r *BaseCommand) EvalString(field, val string, variables map[string]any) (string, error) {
+	result, err := r.EvalValue(field, val, variables)
+	if err != nil {
+		return "", err
+	}
+
+	if s, ok := result.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(result), nil
+}
+
+// RenderTemplate renders the template file src, named name, with the
+// executor's template functions. Partials, keyed by name, can be used from it
+// with {{ template "name" . }}. Unlike EvalValue, errors are always returned.
+func (ex *Executor) RenderTemplate(name, src string, partials map[string]string, variables map[string]any) (string, error) {
+	return renderTemplate(name, src, partials, ex.templateFuncs(), ex.strictTemplates, variables)
+}
+
+// RenderTemplate renders a template file through the owning executor.
+// Commands that are not attached to an executor use the global template
+// functions.
+func (r *BaseCommand) RenderTemplate(name, src string, partials map[string]string, variables map[string]any) (string, error) {
+	if r.Ectx == nil || r.Ectx.Executor == nil {
+		return renderTemplate(name, src, partials, globalValueFuncs(), false, variables)
+	}
+
+	return r.Ectx.Executor.RenderTemplate(name, src, partials, variables)
+}
+
+func renderTemplate(name, src string, partials map[string]string, funcs template.FuncMap, strict bool, variables map[string]any) (string, error) {
+	tmpl, err := parseTemplate(src, funcs, strict)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse template %s", name)
+	}
+	for partial, partialSrc := range partials {
+		if _, err := tmpl.New(partial).Parse(partialSrc); err != nil {
+			return "", errors.Wrapf(err, "failed to parse partial %s", partial)
+		}
+	}
+
+	result, err := executeTemplate(tmpl, variables)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to render template %s", name)
+	}
+	return result, nil
+}
+
+func (r *BaseCommand) rawDescription() string {
+	return r.Description
+}
+
+// describer is implemented by every command embedding BaseCommand.
+type describer interface {
+	rawDescription() string
+}
+
+func (ex *Executor) describe(cmd Command, variables map[string]any) (string, error) {
+	dcmd, ok := cmd.(describer)
+	if !ex.strictTemplates || !ok {
+		return cmd.GetDescription(variables), nil
+	}
+
+	desc, err := ex.EvalValue("description", dcmd.rawDescription(), variables)
+	if err != nil {
+		return "", err
+	}
+	s, _ := desc.(string)
+	return s, nil
+}
+
+// strictIndex replaces the builtin `index` function in strict mode.
+// Unlike the builtin, it fails when a map has no entry for the given key.
+func strictIndex(item any, indexes ...any) (any, error) {
+	v := reflect.ValueOf(item)
+	for _, idx := range indexes {
+		for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) {
+			if v.IsNil() {
+				return nil, errors.New("index of nil value")
+			}
+			v = v.Elem()
+		}
+		if !v.IsValid() {
+			return nil, errors.New("index of untyped nil")
+		}
+
+		switch v.Kind() {
+		case reflect.Map:
+			key := reflect.ValueOf(idx)
+			if !key.IsValid() || !key.Type().AssignableTo(v.Type().Key()) {
+				return nil, errors.Errorf("cannot index map with key of type %T", idx)
+			}
+			next := v.MapIndex(key)
+			if !next.IsValid() {
+				return nil, errors.Errorf("map has no entry for key %q", fmt.Sprint(idx))
+			}
+			v = next
+		case reflect.Slice, reflect.Array, reflect.String:
+			i, ok := toIndex(idx)
+			if !ok {
+				return nil, errors.Errorf("cannot index %s with %T", v.Kind(), idx)
+			}
+			if i < 0 || i >= v.Len() {
+				return nil, errors.Errorf("index out of range: %d", i)
+			}
+			v = v.Index(i)
+		default:
+			return nil, errors.Errorf("can't index item of type %s", v.Type())
+		}
+	}
+
+	if !v.IsValid() {
+		return nil, nil
+	}
+	return v.Interface(), nil
+}
+
+func toIndex(idx any) (int, bool) {
+	v := reflect.ValueOf(idx)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(v.Uint()), true //nolint:gosec // indexes are bounds-checked by the caller
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,297 @@
+package godexer
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-extras/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// builtinValueFuncs returns the curated set of template functions that are
+// always available to MaybeEvalValue.
+//
+// Argument order follows the pipeline convention: the value being
+// transformed comes last, so `{{ .name | default "guest" | upper }}` works.
+func builtinValueFuncs() template.FuncMap {
+	return template.FuncMap{
+		"shell_escape": ShellEscape,
+
+		// defaults and emptiness
+		"default":  tplDefault,
+		"required": tplRequired,
+		"empty":    tplEmpty,
+		"coalesce": tplCoalesce,
+
+		// strings
+		"upper":        strings.ToUpper,
+		"lower":        strings.ToLower,
+		"trim":         strings.TrimSpace,
+		"replace":      tplReplace,
+		"regexReplace": tplRegexReplace,
+		"split":        tplSplit,
+		"join":         tplJoin,
+
+		// YAML helpers
+		"indent":  tplIndent,
+		"nindent": tplNindent,
+
+		// encoding
+		"toJson":   tplToJSON,
+		"fromJson": tplFromJSON,
+		"toYaml":   tplToYAML,
+		"fromYaml": tplFromYAML,
+		"b64enc":   tplB64Enc,
+		"b64dec":   tplB64Dec,
+
+		// hashing
+		"sha256sum": tplSha256Sum,
+
+		// time
+		"now":  time.Now,
+		"date": tplDate,
+
+		// environment
+		"env": os.Getenv,
+
+		// collections
+		"dict":   tplDict,
+		"list":   tplList,
+		"keys":   tplKeys,
+		"hasKey": tplHasKey,
+	}
+}
+
+// tplEmpty reports whether the value is nil or the zero value of its type.
+// Empty strings, slices and maps are considered empty as well.
+func tplEmpty(val any) bool {
+	if val == nil {
+		return true
+	}
+
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	default:
+		return rv.IsZero()
+	}
+}
+
+// tplDefault returns def if val is empty, otherwise val.
+func tplDefault(def, val any) any {
+	if tplEmpty(val) {
+		return def
+	}
+	return val
+}
+
+// tplRequired fails the template execution with msg if val is empty.
+func tplRequired(msg string, val any) (any, error) {
+	if tplEmpty(val) {
+		return nil, errors.New(msg)
+	}
+	return val, nil
+}
+
+// tplCoalesce returns the first non-empty argument or nil.
+func tplCoalesce(vals ...any) any {
+	for _, v := range vals {
+		if !tplEmpty(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+func tplReplace(old, replacement, s string) string {
+	return strings.ReplaceAll(s, old, replacement)
+}
+
+func tplRegexReplace(expr, replacement, s string) (string, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(s, replacement), nil
+}
+
+func tplSplit(sep, s string) []string {
+	return strings.Split(s, sep)
+}
+
+// tplJoin joins the elements of a list using sep. Non-string elements are
+// formatted with fmt.Sprint.
+func tplJoin(sep string, list any) (string, error) {
+	items, err := toAnySlice(list)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, 0, len(items))
+	for _, item := range items {
+		parts = append(parts, fmt.Sprint(item))
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// tplIndent prefixes every line of s with n spaces.
+func tplIndent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+// tplNindent is like tplIndent but prepends a newline, which is handy when
+// embedding a block into YAML right after a key.
+func tplNindent(n int, s string) string {
+	return "\n" + tplIndent(n, s)
+}
+
+func tplToJSON(val any) (string, error) {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func tplFromJSON(s string) (any, error) {
+	var result any
+	if err := json.Unmarshal([]byte(s), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func tplToYAML(val any) (string, error) {
+	data, err := yaml.Marshal(val)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+func tplFromYAML(s string) (any, error) {
+	var result any
+	if err := yaml.Unmarshal([]byte(s), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func tplB64Enc(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func tplB64Dec(s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func tplSha256Sum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// tplDate formats a time value using a Go reference layout. The value may be
+// a time.Time, a *time.Time or a unix timestamp.
+func tplDate(layout string, val any) (string, error) {
+	switch t := val.(type) {
+	case time.Time:
+		return t.Format(layout), nil
+	case *time.Time:
+		return t.Format(layout), nil
+	case int:
+		return time.Unix(int64(t), 0).Format(layout), nil
+	case int64:
+		return time.Unix(t, 0).Format(layout), nil
+	case float64:
+		return time.Unix(int64(t), 0).Format(layout), nil
+	default:
+		return "", errors.Errorf("date: unsupported value type %T", val)
+	}
+}
+
+// tplDict builds a map from a list of key/value pairs.
+func tplDict(pairs ...any) (map[string]any, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict: odd number of arguments")
+	}
+
+	result := make(map[string]any, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, errors.Errorf("dict: key at position %d must be a string, got %T", i, pairs[i])
+		}
+		result[key] = pairs[i+1]
+	}
+	return result, nil
+}
+
+func tplList(items ...any) []any {
+	result := make([]any, 0, len(items))
+	return append(result, items...)
+}
+
+// tplKeys returns the sorted keys of a map with string keys.
+func tplKeys(m any) ([]string, error) {
+	rv := reflect.ValueOf(m)
+	if rv.Kind() != reflect.Map {
+		return nil, errors.Errorf("keys: expected a map, got %T", m)
+	}
+
+	result := make([]string, 0, rv.Len())
+	for _, k := range rv.MapKeys() {
+		if k.Kind() != reflect.String {
+			return nil, errors.Errorf("keys: invalid map key type %q (expected string)", k.Kind())
+		}
+		result = append(result, k.String())
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func tplHasKey(key string, m any) (bool, error) {
+	rv := reflect.ValueOf(m)
+	if rv.Kind() != reflect.Map {
+		return false, errors.Errorf("hasKey: expected a map, got %T", m)
+	}
+	if rv.Type().Key().Kind() != reflect.String {
+		return false, errors.Errorf("hasKey: invalid map key type %q (expected string)", rv.Type().Key().Kind())
+	}
+	return rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key())).IsValid(), nil
+}
+
+// toAnySlice converts any slice or array value into []any.
+func toAnySlice(list any) ([]any, error) {
+	if list == nil {
+		return nil, nil
+	}
+
+	rv := reflect.ValueOf(list)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, errors.Errorf("expected a list, got %T", list)
+	}
+
+	result := make([]any, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		result = append(result, rv.Index(i).Interface())
+	}
+	return result, nil
+}
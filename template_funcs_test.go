@@ -0,0 +1,92 @@
+package godexer_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-extras/godexer"
+)
+
+func TestBuiltinValueFuncs(t *testing.T) {
+	t.Setenv("GODEXER_TEST_ENV", "from-env")
+
+	vars := map[string]any{
+		"name":  "World",
+		"empty": "",
+		"list":  []any{"a", "b", "c"},
+		"map":   map[string]any{"b": 2, "a": 1},
+		"json":  `{"k":"v"}`,
+		"yaml":  "k: v\n",
+		"when":  time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC),
+		"block": "a: 1\nb: 2",
+	}
+
+	testcases := []struct {
+		name string
+		tpl  string
+		want string
+	}{
+		{name: "default_empty", tpl: `{{ .empty | default "guest" }}`, want: "guest"},
+		{name: "default_set", tpl: `{{ .name | default "guest" }}`, want: "World"},
+		{name: "required", tpl: `{{ required "name is required" .name }}`, want: "World"},
+		{name: "empty", tpl: `{{ empty .empty }} {{ empty .name }}`, want: "true false"},
+		{name: "coalesce", tpl: `{{ coalesce .empty .missing .name }}`, want: "World"},
+		{name: "upper", tpl: `{{ upper .name }}`, want: "WORLD"},
+		{name: "lower", tpl: `{{ lower .name }}`, want: "world"},
+		{name: "trim", tpl: `{{ trim "  x  " }}`, want: "x"},
+		{name: "replace", tpl: `{{ .name | replace "o" "0" }}`, want: "W0rld"},
+		{name: "regexReplace", tpl: `{{ regexReplace "[aeiou]" "_" .name }}`, want: "W_rld"},
+		{name: "regexReplace_pipeline", tpl: `{{ .name | regexReplace "^(W)" "${1}w" }}`, want: "Wworld"},
+		{name: "split_join", tpl: `{{ split "," "x,y,z" | join "-" }}`, want: "x-y-z"},
+		{name: "join_any", tpl: `{{ join "," .list }}`, want: "a,b,c"},
+		{name: "indent", tpl: `{{ indent 2 .block }}`, want: "  a: 1\n  b: 2"},
+		{name: "nindent", tpl: `x:{{ nindent 2 .block }}`, want: "x:\n  a: 1\n  b: 2"},
+		{name: "toJson", tpl: `{{ toJson .map }}`, want: `{"a":1,"b":2}`},
+		{name: "fromJson", tpl: `{{ (fromJson .json).k }}`, want: "v"},
+		{name: "toYaml", tpl: `{{ toYaml .map }}`, want: "a: 1\nb: 2"},
+		{name: "fromYaml", tpl: `{{ (fromYaml .yaml).k }}`, want: "v"},
+		{name: "b64enc", tpl: `{{ b64enc .name }}`, want: "V29ybGQ="},
+		{name: "b64dec", tpl: `{{ b64dec "V29ybGQ=" }}`, want: "World"},
+		{name: "sha256sum", tpl: `{{ sha256sum "abc" }}`, want: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{name: "date", tpl: `{{ date "2006-01-02" .when }}`, want: "2024-03-05"},
+		{name: "now", tpl: `{{ if now }}ok{{ end }}`, want: "ok"},
+		{name: "env", tpl: `{{ env "GODEXER_TEST_ENV" }}`, want: "from-env"},
+		{name: "dict", tpl: `{{ $d := dict "x" 1 "y" 2 }}{{ $d.y }}`, want: "2"},
+		{name: "list", tpl: `{{ list 1 2 3 | join "+" }}`, want: "1+2+3"},
+		{name: "keys", tpl: `{{ keys .map | join "," }}`, want: "a,b"},
+		{name: "hasKey", tpl: `{{ hasKey "a" .map }} {{ hasKey "z" .map }}`, want: "true false"},
+		{name: "hasKey_pipeline", tpl: `{{ if .map | hasKey "b" }}yes{{ end }}`, want: "yes"},
+		{name: "shell_escape", tpl: `{{ shell_escape "a b" }}`, want: "'a b'"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			c.Assert(godexer.MaybeEvalValue(tc.tpl, vars), qt.Equals, tc.want)
+		})
+	}
+}
+
+func TestBuiltinValueFuncs_Errors(t *testing.T) {
+	testcases := []struct {
+		name string
+		tpl  string
+	}{
+		{name: "required_missing", tpl: `{{ required "name is required" .missing }}`},
+		{name: "fromJson_invalid", tpl: `{{ fromJson "{" }}`},
+		{name: "b64dec_invalid", tpl: `{{ b64dec "!!" }}`},
+		{name: "dict_odd", tpl: `{{ dict "x" }}`},
+		{name: "keys_not_map", tpl: `{{ keys "x" }}`},
+		{name: "regexReplace_invalid", tpl: `{{ regexReplace "(" "x" "y" }}`},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			// lenient evaluation returns the raw value on failure
+			c.Assert(godexer.MaybeEvalValue(tc.tpl, map[string]any{}), qt.Equals, tc.tpl)
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package godexer_test
+
+import (
+	"fmt"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-extras/godexer"
+)
+
+func TestStrictTemplates(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		c := qt.New(t)
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: variable
+    stepName: set
+    description: 'Setting {{ index . "name" }}'
+    variable: greeting
+    value: 'Hi, {{ .name }}'
+`, godexer.WithStrictTemplates())
+		c.Assert(err, qt.IsNil)
+
+		vars := map[string]any{"name": "John"}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["greeting"], qt.Equals, "Hi, John")
+	})
+
+	testcases := []struct {
+		name     string
+		scenario string
+		field    string
+		errMatch string
+	}{
+		{
+			name: "missing_key_via_index",
+			scenario: `commands:
+  - type: variable
+    stepName: set
+    variable: greeting
+    value: '{{ index . "nmae" }}'
+`,
+			field:    "value",
+			errMatch: `.*map has no entry for key "nmae".*`,
+		},
+		{
+			name: "missing_key_via_field",
+			scenario: `commands:
+  - type: variable
+    stepName: set
+    variable: greeting
+    value: '{{ .nmae }}'
+`,
+			field:    "value",
+			errMatch: `.*map has no entry for key "nmae".*`,
+		},
+		{
+			name: "parse_error",
+			scenario: `commands:
+  - type: variable
+    stepName: set
+    variable: greeting
+    value: '{{ .name }'
+`,
+			field:    "value",
+			errMatch: `.*unexpected "}" in operand.*`,
+		},
+		{
+			name: "description",
+			scenario: `commands:
+  - type: message
+    stepName: msg
+    description: '{{ .nmae }}'
+`,
+			field:    "description",
+			errMatch: `.*map has no entry for key "nmae".*`,
+		},
+		{
+			name: "exec_cmd",
+			scenario: `commands:
+  - type: exec
+    stepName: run
+    cmd: ["echo", "{{ .nmae }}"]
+`,
+			field:    "cmd[1]",
+			errMatch: `.*map has no entry for key "nmae".*`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			ex, err := godexer.NewWithScenario(tc.scenario, godexer.WithStrictTemplates())
+			c.Assert(err, qt.IsNil)
+
+			err = ex.Execute(map[string]any{"name": "John"})
+			c.Assert(err, qt.ErrorMatches, tc.errMatch)
+
+			var cmdErr *godexer.CommandAwareError
+			c.Assert(err, qt.ErrorAs, &cmdErr)
+
+			var tplErr *godexer.TemplateError
+			c.Assert(err, qt.ErrorAs, &tplErr)
+			c.Assert(tplErr.Field, qt.Equals, tc.field)
+			c.Assert(tplErr.Template, qt.Not(qt.Equals), "")
+		})
+	}
+
+	t.Run("meta", func(t *testing.T) {
+		c := qt.New(t)
+		ex, err := godexer.NewWithScenario(`meta:
+  strictTemplates: true
+commands:
+  - type: variable
+    stepName: set
+    variable: greeting
+    value: '{{ .nmae }}'
+`)
+		c.Assert(err, qt.IsNil)
+
+		err = ex.Execute(map[string]any{})
+		var tplErr *godexer.TemplateError
+		c.Assert(err, qt.ErrorAs, &tplErr)
+		c.Assert(tplErr.Field, qt.Equals, "value")
+	})
+
+	t.Run("lenient_by_default", func(t *testing.T) {
+		c := qt.New(t)
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: variable
+    stepName: set
+    variable: greeting
+    value: '{{ .name }'
+`)
+		c.Assert(err, qt.IsNil)
+
+		vars := map[string]any{}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["greeting"], qt.Equals, "{{ .name }")
+	})
+
+	t.Run("inherited_by_child_executors", func(t *testing.T) {
+		c := qt.New(t)
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: foreach
+    stepName: loop
+    iterable: [1, 2]
+    commands:
+      - type: variable
+        stepName: set
+        variable: x
+        value: '{{ .nmae }}'
+`, godexer.WithStrictTemplates())
+		c.Assert(err, qt.IsNil)
+
+		err = ex.Execute(map[string]any{})
+		var tplErr *godexer.TemplateError
+		c.Assert(err, qt.ErrorAs, &tplErr)
+	})
+}
+
+func TestValueFuncs(t *testing.T) {
+	const scenario = `commands:
+  - type: variable
+    stepName: set
+    variable: result
+    value: '{{ greet .name }}'
+`
+
+	t.Run("scoped_to_executor", func(t *testing.T) {
+		c := qt.New(t)
+		ex1, err := godexer.NewWithScenario(scenario, godexer.WithValueFunc("greet", func(s string) string {
+			return "Hello, " + s
+		}))
+		c.Assert(err, qt.IsNil)
+		ex2, err := godexer.NewWithScenario(scenario, godexer.WithValueFuncs(map[string]any{
+			"greet": func(s string) string { return "Bye, " + s },
+		}))
+		c.Assert(err, qt.IsNil)
+		ex3, err := godexer.NewWithScenario(scenario)
+		c.Assert(err, qt.IsNil)
+
+		vars1 := map[string]any{"name": "John"}
+		vars2 := map[string]any{"name": "John"}
+		vars3 := map[string]any{"name": "John"}
+		c.Assert(ex1.Execute(vars1), qt.IsNil)
+		c.Assert(ex2.Execute(vars2), qt.IsNil)
+		c.Assert(ex3.Execute(vars3), qt.IsNil)
+		c.Assert(vars1["result"], qt.Equals, "Hello, John")
+		c.Assert(vars2["result"], qt.Equals, "Bye, John")
+		c.Assert(vars3["result"], qt.Equals, "{{ greet .name }}")
+	})
+
+	t.Run("inherited_by_child_executors", func(t *testing.T) {
+		c := qt.New(t)
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: foreach
+    stepName: loop
+    iterable: ["a", "b"]
+    commands:
+      - type: variable
+        stepName: set
+        variable: result
+        value: '{{ greet .value }}'
+      - type: variable
+        stepName: store
+        variable: ignored
+        value: '{{ $_ := set .parent .value .result }}'
+`,
+			godexer.WithValueFunc("greet", func(s string) string { return "Hello, " + s }),
+			godexer.WithValueFunc("set", func(m map[string]any, k string, v any) string {
+				m[k] = v
+				return ""
+			}),
+		)
+		c.Assert(err, qt.IsNil)
+
+		vars := make(map[string]any)
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["a"], qt.Equals, "Hello, a")
+		c.Assert(vars["b"], qt.Equals, "Hello, b")
+	})
+
+	t.Run("evaluator_functions_bridge", func(t *testing.T) {
+		c := qt.New(t)
+		double := func(args ...any) (any, error) {
+			switch n := args[0].(type) {
+			case int:
+				return n * 2, nil
+			case float64:
+				return n * 2, nil
+			default:
+				return nil, fmt.Errorf("unexpected type %T", n)
+			}
+		}
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: variable
+    stepName: set
+    requires: 'double(n) == 4'
+    variable: result
+    value: '{{ double .n }}'
+`,
+			godexer.WithRegisteredEvaluatorFunction("double", double),
+			godexer.WithEvaluatorFunctionsInTemplates(),
+		)
+		c.Assert(err, qt.IsNil)
+
+		vars := map[string]any{"n": 2}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["result"], qt.Equals, "4")
+	})
+
+	t.Run("value_funcs_take_precedence", func(t *testing.T) {
+		c := qt.New(t)
+		ex, err := godexer.NewWithScenario(scenario,
+			godexer.WithRegisteredEvaluatorFunction("greet", func(args ...any) (any, error) {
+				return "evaluator", nil
+			}),
+			godexer.WithEvaluatorFunctionsInTemplates(),
+			godexer.WithValueFunc("greet", func(s string) string { return "value func" }),
+		)
+		c.Assert(err, qt.IsNil)
+
+		vars := map[string]any{"name": "John"}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["result"], qt.Equals, "value func")
+	})
+}
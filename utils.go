@@ -12,6 +12,9 @@ import (
 	"github.com/spf13/afero"
 )
 
+// TimeSleep is used by SystemClock.
+//
+// Deprecated: set a Clock with WithClock instead.
 var TimeSleep = time.Sleep
 
 func ShellEscape(cmd string) string {
@@ -28,7 +28,11 @@ func (s *VariableCommand) Execute(variables map[string]any) error {
 		return errors.New("variable: variable name cannot be empty")
 	}
 
-	variables[s.Variable] = MaybeEvalValue(s.Value, variables)
+	value, err := s.EvalValue("value", s.Value, variables)
+	if err != nil {
+		return err
+	}
+	variables[s.Variable] = value
 
 	return nil
 }
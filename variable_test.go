@@ -145,4 +145,86 @@ func TestVariable(t *testing.T) {
 		c.Assert(err, qt.IsNil)
 		c.Assert(m["result"], qt.Equals, "true")
 	})
+	t.Run("Execute_Structured", func(t *testing.T) {
+		c := qt.New(t)
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: variable
+    stepName: set
+    variable: config
+    value:
+      name: '{{ .name }}'
+      ports: ['{{ .port }}', 443]
+      nested:
+        greeting: 'Hi, {{ .name }}'
+`)
+		c.Assert(err, qt.IsNil)
+
+		vars := map[string]any{"name": "web", "port": 80}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["config"], qt.DeepEquals, map[string]any{
+			"name":   "web",
+			"ports":  []any{"80", float64(443)},
+			"nested": map[string]any{"greeting": "Hi, web"},
+		})
+	})
+
+	t.Run("Execute_Typed", func(t *testing.T) {
+		testcases := []struct {
+			name  string
+			value string
+			want  any
+		}{
+			{name: "int", value: "${{ 3 }}", want: 3},
+			{name: "arithmetic", value: "${{ port + 1 }}", want: 81},
+			{name: "bool", value: "${{ port > 1024 }}", want: false},
+			{name: "list", value: "${{ [name, port] }}", want: []any{"web", 80}},
+			{name: "nested_access", value: "${{ server.host }}", want: "example.com"},
+			{name: "evaluator_function", value: "${{ double(port) }}", want: 160},
+			{name: "template_is_still_string", value: "{{ 3 }}", want: "3"},
+		}
+
+		for _, tc := range testcases {
+			t.Run(tc.name, func(t *testing.T) {
+				c := qt.New(t)
+				ex, err := godexer.NewWithScenario(`commands:
+  - type: variable
+    stepName: set
+    variable: result
+    value: '`+tc.value+`'
+`, godexer.WithRegisteredEvaluatorFunction("double", func(args ...any) (any, error) {
+					return args[0].(int) * 2, nil
+				}))
+				c.Assert(err, qt.IsNil)
+
+				vars := map[string]any{
+					"name":   "web",
+					"port":   80,
+					"server": map[string]any{"host": "example.com"},
+				}
+				c.Assert(ex.Execute(vars), qt.IsNil)
+				c.Assert(vars["result"], qt.DeepEquals, tc.want)
+			})
+		}
+	})
+
+	t.Run("Execute_TypedInvalid", func(t *testing.T) {
+		c := qt.New(t)
+		scenario := `commands:
+  - type: variable
+    stepName: set
+    variable: result
+    value: '${{ 1 + }}'
+`
+		ex, err := godexer.NewWithScenario(scenario)
+		c.Assert(err, qt.IsNil)
+		vars := make(map[string]any)
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["result"], qt.Equals, "${{ 1 + }}")
+
+		ex, err = godexer.NewWithScenario(scenario, godexer.WithStrictTemplates())
+		c.Assert(err, qt.IsNil)
+		var tplErr *godexer.TemplateError
+		c.Assert(ex.Execute(make(map[string]any)), qt.ErrorAs, &tplErr)
+		c.Assert(tplErr.Field, qt.Equals, "value")
+	})
 }
@@ -1,6 +1,8 @@
 package version
 
 import (
+	"fmt"
+
 	"github.com/go-extras/errors"
 	"github.com/hashicorp/go-version"
 
@@ -8,8 +10,9 @@ import (
 )
 
 var (
-	ErrArgMustBeString = errors.New("argument must be string")
-	ErrInvalidVersion  = errors.New("invalid version")
+	ErrArgMustBeString   = errors.New("argument must be string")
+	ErrInvalidVersion    = errors.New("invalid version")
+	ErrInvalidConstraint = errors.New("invalid version constraint")
 )
 
 func parseArgs(args []any) (v1, v2 *version.Version, err error) {
@@ -35,7 +38,8 @@ func parseArgs(args []any) (v1, v2 *version.Version, err error) {
 	return v1, v2, nil
 }
 
-// WithVersionFuncs registers value evaluator functions.
+// WithVersionFuncs registers value evaluator functions. The same functions are
+// registered as template functions on the executor.
 //
 // The following functions are available:
 // - `version_lt(version1, version2 string) (bool, error)`
@@ -43,8 +47,16 @@ func parseArgs(args []any) (v1, v2 *version.Version, err error) {
 // - `version_gt(version1, version2 string) (bool, error)`
 // - `version_gte(version1, version2 string) (bool, error)`
 // - `version_eq(version1, version2 string) (bool, error)`
+// - `version_satisfies(version, constraints string) (bool, error)` - e.g. `version_satisfies(v, ">= 1.2, < 2.0")`
+// - `version_major(version string) (number, error)`
+// - `version_minor(version string) (number, error)`
+// - `version_patch(version string) (number, error)`
+// - `version_prerelease(version string) (string, error)` - e.g. "rc.1" for "1.2.0-rc.1"
+// - `version_max(versions list) (string, error)` - returns the highest version as written in the list
 func WithVersionFuncs() func(*godexer.Executor) {
 	return func(ex *godexer.Executor) {
+		ex.RegisterValueFuncs(templateFuncs())
+
 		ex.RegisterEvaluatorFunction("version_lt", func(args ...any) (any, error) {
 			v1, v2, err := parseArgs(args)
 			if err != nil {
@@ -84,5 +96,166 @@ func WithVersionFuncs() func(*godexer.Executor) {
 			}
 			return v1.Equal(v2), nil
 		})
+
+		ex.RegisterEvaluatorFunction("version_satisfies", func(args ...any) (any, error) {
+			if len(args) != 2 {
+				return false, errors.New("invalid number of arguments")
+			}
+			return satisfies(args[0], args[1])
+		})
+
+		ex.RegisterEvaluatorFunction("version_major", func(args ...any) (any, error) {
+			return segmentArg(args, 0)
+		})
+
+		ex.RegisterEvaluatorFunction("version_minor", func(args ...any) (any, error) {
+			return segmentArg(args, 1)
+		})
+
+		ex.RegisterEvaluatorFunction("version_patch", func(args ...any) (any, error) {
+			return segmentArg(args, 2)
+		})
+
+		ex.RegisterEvaluatorFunction("version_prerelease", func(args ...any) (any, error) {
+			if len(args) != 1 {
+				return "", errors.New("invalid number of arguments")
+			}
+			return prerelease(args[0])
+		})
+
+		ex.RegisterEvaluatorFunction("version_max", func(args ...any) (any, error) {
+			// a single argument may be a list of versions
+			if len(args) == 1 {
+				if _, ok := args[0].(string); !ok {
+					return maxVersion(args[0])
+				}
+			}
+			return maxVersion(args)
+		})
+	}
+}
+
+// templateFuncs returns the template counterparts of the evaluator functions.
+// Numbers are returned as int rather than float64.
+func templateFuncs() map[string]any {
+	compare := func(cmp func(v1, v2 *version.Version) bool) func(a, b any) (bool, error) {
+		return func(a, b any) (bool, error) {
+			v1, v2, err := parseArgs([]any{a, b})
+			if err != nil {
+				return false, err
+			}
+			return cmp(v1, v2), nil
+		}
+	}
+	segment := func(idx int) func(v any) (int, error) {
+		return func(v any) (int, error) {
+			parsed, err := parseVersion(v)
+			if err != nil {
+				return 0, err
+			}
+			return parsed.Segments()[idx], nil
+		}
+	}
+
+	return map[string]any{
+		"version_lt":         compare((*version.Version).LessThan),
+		"version_lte":        compare((*version.Version).LessThanOrEqual),
+		"version_gt":         compare((*version.Version).GreaterThan),
+		"version_gte":        compare((*version.Version).GreaterThanOrEqual),
+		"version_eq":         compare((*version.Version).Equal),
+		"version_satisfies":  satisfies,
+		"version_major":      segment(0),
+		"version_minor":      segment(1),
+		"version_patch":      segment(2),
+		"version_prerelease": prerelease,
+		"version_max":        maxVersion,
+	}
+}
+
+func parseVersion(arg any) (*version.Version, error) {
+	s, ok := arg.(string)
+	if !ok {
+		return nil, ErrArgMustBeString
+	}
+	v, err := version.NewVersion(s)
+	if err != nil {
+		return nil, errors.Wrap(errors.WithEquivalents(err, ErrInvalidVersion), "argument must be a valid version")
+	}
+	return v, nil
+}
+
+func satisfies(v, constraints any) (bool, error) {
+	parsed, err := parseVersion(v)
+	if err != nil {
+		return false, err
+	}
+	s, ok := constraints.(string)
+	if !ok {
+		return false, errors.Wrap(ErrArgMustBeString, "argument 2 must be string")
+	}
+	c, err := version.NewConstraint(s)
+	if err != nil {
+		return false, errors.Wrap(errors.WithEquivalents(err, ErrInvalidConstraint), "argument 2 must be a valid constraint")
+	}
+	return c.Check(parsed), nil
+}
+
+// segmentArg returns a version segment as float64, the number type govaluate uses.
+func segmentArg(args []any, idx int) (any, error) {
+	if len(args) != 1 {
+		return float64(0), errors.New("invalid number of arguments")
+	}
+	v, err := parseVersion(args[0])
+	if err != nil {
+		return float64(0), err
+	}
+	return float64(v.Segments()[idx]), nil
+}
+
+func prerelease(v any) (string, error) {
+	parsed, err := parseVersion(v)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Prerelease(), nil
+}
+
+// maxVersion returns the highest version of a list, as written in the list.
+// Empty entries are ignored, so captured command output split into lines can be
+// passed as is. An empty list yields an empty string.
+func maxVersion(list any) (string, error) {
+	var items []any
+	switch l := list.(type) {
+	case []any:
+		items = l
+	case []string:
+		items = make([]any, len(l))
+		for i, s := range l {
+			items[i] = s
+		}
+	default:
+		return "", errors.New("argument must be a list")
+	}
+
+	var (
+		best    *version.Version
+		bestRaw string
+	)
+	for i, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return "", errors.Wrap(ErrArgMustBeString, fmt.Sprintf("item %d must be string", i))
+		}
+		if s == "" {
+			continue
+		}
+		v, err := version.NewVersion(s)
+		if err != nil {
+			return "", errors.Wrap(errors.WithEquivalents(err, ErrInvalidVersion), fmt.Sprintf("item %d must be a valid version", i))
+		}
+		if best == nil || v.GreaterThan(best) {
+			best, bestRaw = v, s
+		}
 	}
+	return bestRaw, nil
 }
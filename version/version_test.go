@@ -226,3 +226,89 @@ func TestWithDefaultEvaluatorFunctions(t *testing.T) {
 		}
 	})
 }
+
+func TestVersionUtilities(t *testing.T) {
+	t.Run("requires", func(t *testing.T) {
+		testcases := []struct {
+			requires string
+			want     bool
+		}{
+			{requires: `version_satisfies(v, ">= 1.2, < 2.0")`, want: true},
+			{requires: `version_satisfies(v, "~> 1.5")`, want: false},
+			{requires: `version_major(v) == 1 && version_minor(v) == 4 && version_patch(v) == 2`, want: true},
+			{requires: `version_prerelease(v) == ""`, want: true},
+			{requires: `version_prerelease("2.0.0-rc.1") == "rc.1"`, want: true},
+			{requires: `version_max(versions) == "v1.10.0"`, want: true},
+			{requires: `version_max(single) == "1.2.0"`, want: true},
+			{requires: `version_max(empty) == ""`, want: true},
+		}
+
+		for _, tc := range testcases {
+			t.Run(tc.requires, func(t *testing.T) {
+				c := qt.New(t)
+				ex, err := godexer.NewWithScenario(`commands:
+  - type: message
+    stepName: check
+    requires: '`+tc.requires+`'
+`, version.WithVersionFuncs())
+				c.Assert(err, qt.IsNil)
+
+				vars := map[string]any{
+					"v":        "1.4.2",
+					"versions": []any{"1.9.3", "v1.10.0", "1.10.0-rc.1", ""},
+					"single":   []any{"1.2.0"},
+					"empty":    []any{},
+				}
+				c.Assert(ex.Execute(vars), qt.IsNil)
+				c.Assert(vars["__step:check:skipped"], qt.Equals, !tc.want)
+			})
+		}
+	})
+
+	t.Run("templates", func(t *testing.T) {
+		c := qt.New(t)
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: variable
+    stepName: latest
+    variable: latest
+    value: '{{ version_max .versions }}'
+  - type: variable
+    stepName: summary
+    variable: summary
+    value: '{{ version_major .latest }}.{{ version_minor .latest }}.{{ add1 (version_patch .latest) }} {{ version_satisfies .latest ">= 2.0" }} {{ version_gt .latest "1.9" }}'
+`, version.WithVersionFuncs(), godexer.WithValueFunc("add1", func(i int) int { return i + 1 }))
+		c.Assert(err, qt.IsNil)
+
+		vars := map[string]any{"versions": []string{"1.2.3", "2.1.0", "2.0.9"}}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		c.Assert(vars["latest"], qt.Equals, "2.1.0")
+		c.Assert(vars["summary"], qt.Equals, "2.1.1 true true")
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		testcases := []struct {
+			requires string
+			err      error
+		}{
+			{requires: `version_satisfies("x", ">= 1.0")`, err: version.ErrInvalidVersion},
+			{requires: `version_satisfies("1.0", "~~ 1")`, err: version.ErrInvalidConstraint},
+			{requires: `version_major("x") == 1`, err: version.ErrInvalidVersion},
+			{requires: `version_max(versions) == "1"`, err: version.ErrInvalidVersion},
+		}
+
+		for _, tc := range testcases {
+			t.Run(tc.requires, func(t *testing.T) {
+				c := qt.New(t)
+				ex, err := godexer.NewWithScenario(`commands:
+  - type: message
+    stepName: check
+    requires: '`+tc.requires+`'
+`, version.WithVersionFuncs())
+				c.Assert(err, qt.IsNil)
+
+				err = ex.Execute(map[string]any{"versions": []any{"1.0", "latest"}})
+				c.Assert(err, qt.ErrorIs, tc.err)
+			})
+		}
+	})
+}
@@ -0,0 +1,278 @@
+package godexer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-extras/errors"
+)
+
+// ErrValidationFailed is returned when the validate command of a writefile
+// step rejects the new contents.
+var ErrValidationFailed = errors.New("validation failed")
+
+// permissionBits are the mode bits set by permissions.
+const permissionBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// ParsePermissions parses an octal file mode such as 0644 or 4755, mapping
+// the setuid, setgid and sticky bits to their os.FileMode flags.
+func ParsePermissions(s string) (os.FileMode, error) {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v > 0o7777 {
+		return 0, errors.Errorf("invalid permissions %q, must be an octal mode such as 0644", s)
+	}
+	mode := os.FileMode(v) & os.ModePerm
+	if v&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if v&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if v&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
+
+// FormatPermissions formats mode as an octal mode for chmod, the reverse of
+// ParsePermissions.
+func FormatPermissions(mode os.FileMode) string {
+	v := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		v |= 0o4000
+	}
+	if mode&os.ModeSetgid != 0 {
+		v |= 0o2000
+	}
+	if mode&os.ModeSticky != 0 {
+		v |= 0o1000
+	}
+	return fmt.Sprintf("%04o", v)
+}
+
+// VariableContents returns the contents held by the variable name, which must
+// be a string, a byte slice or a fmt.Stringer.
+func VariableContents(variables map[string]any, name string) ([]byte, error) {
+	switch v := variables[name].(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	case fmt.Stringer:
+		return []byte(v.String()), nil
+	case nil:
+		return nil, errors.Errorf("contents variable %q is not set", name)
+	default:
+		return nil, errors.Errorf("contents variable %q must be a string, got %T", name, v)
+	}
+}
+
+// WriteOptions holds the fields shared by the writefile commands. New
+// contents are staged next to the file and renamed over it, and files that
+// already have them are left alone.
+type WriteOptions struct {
+	// Owner and Group are set on the file, by name or numeric ID.
+	Owner string
+	Group string
+	// Mkdirs creates missing parent directories with DirPermissions
+	// (default 0755).
+	Mkdirs         bool
+	DirPermissions string
+	// Backup keeps a timestamped copy of the file being replaced.
+	Backup bool
+	// Validate is a command checking the staged contents before they replace
+	// the file, e.g. ["nginx", "-t", "-c", "%s"]; %s stands for the staged
+	// file's path.
+	Validate []string
+
+	changed bool
+}
+
+// Changed reports whether the last run changed the file.
+func (w *WriteOptions) Changed() bool {
+	return w.changed
+}
+
+// Reset forgets the outcome of the last run, for runs ending without an
+// install.
+func (w *WriteOptions) Reset() {
+	w.changed = false
+}
+
+// Ownership returns the rendered owner and group.
+func (w *WriteOptions) Ownership(r *BaseCommand, variables map[string]any) (owner, group string, err error) {
+	if owner, err = r.EvalString("owner", w.Owner, variables); err != nil {
+		return "", "", err
+	}
+	if group, err = r.EvalString("group", w.Group, variables); err != nil {
+		return "", "", err
+	}
+	return owner, group, nil
+}
+
+// DirMode returns the mode of the parent directories to create, or 0 if
+// Mkdirs is off.
+func (w *WriteOptions) DirMode() (os.FileMode, error) {
+	if !w.Mkdirs {
+		return 0, nil
+	}
+	if w.DirPermissions == "" {
+		return 0o755, nil
+	}
+	return ParsePermissions(w.DirPermissions)
+}
+
+// ValidateArgv returns the rendered validate command for the file staged at
+// staged, or nil if there is none.
+func (w *WriteOptions) ValidateArgv(r *BaseCommand, variables map[string]any, staged string) ([]string, error) {
+	if len(w.Validate) == 0 {
+		return nil, nil
+	}
+	argv := make([]string, 0, len(w.Validate))
+	for i, v := range w.Validate {
+		arg, err := r.EvalString(fmt.Sprintf("validate[%d]", i), v, variables)
+		if err != nil {
+			return nil, err
+		}
+		argv = append(argv, strings.ReplaceAll(arg, "%s", staged))
+	}
+	return argv, nil
+}
+
+// BackupPath returns where the file is backed up to at now, or an empty
+// string if backups are off.
+func (w *WriteOptions) BackupPath(file string, now time.Time) string {
+	if !w.Backup {
+		return ""
+	}
+	return file + "." + now.Format("20060102T150405") + ".bak"
+}
+
+// Install returns the shell install of the new contents in source, or "-"
+// for stdin, to file with the octal mode, empty to keep the file's. Pass its
+// output to Finish.
+func (w *WriteOptions) Install(r *BaseCommand, variables map[string]any, source, file, mode string) (*FileInstall, error) {
+	w.changed = false
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, errors.Wrap(err, "can't generate a temp file name")
+	}
+	staged := path.Join(path.Dir(file), "."+path.Base(file)+".godexer-"+hex.EncodeToString(suffix))
+
+	owner, group, err := w.Ownership(r, variables)
+	if err != nil {
+		return nil, err
+	}
+	dirMode, err := w.DirMode()
+	if err != nil {
+		return nil, err
+	}
+	validate, err := w.ValidateArgv(r, variables, staged)
+	if err != nil {
+		return nil, err
+	}
+	install := &FileInstall{
+		Source:   source,
+		File:     file,
+		Mode:     mode,
+		Owner:    owner,
+		Group:    group,
+		Staged:   staged,
+		Backup:   w.BackupPath(file, r.Ectx.Now()),
+		Validate: validate,
+	}
+	if dirMode != 0 {
+		install.DirMode = FormatPermissions(dirMode)
+	}
+	return install, nil
+}
+
+// Finish records the outcome of the install from the output of its command.
+func (w *WriteOptions) Finish(install *FileInstall, output string) error {
+	changed, err := install.Result(output)
+	w.changed = changed
+	return err
+}
+
+// installScript stages the new contents, compares them with the file and, if
+// they differ, validates them, backs the file up and renames them over it.
+// It prints whether the file changed, or "invalid" if validation failed.
+const installScript = `src=$1 f=$2 tmp=$3 mode=$4 backup=$5 owner=$6 group=$7 dirmode=$8
+shift 8
+trap 'rm -f "$tmp"; [ "$src" = - ] || rm -f "$src"' EXIT
+d=$(dirname "$f")
+if [ -n "$dirmode" ] && [ ! -d "$d" ]; then
+	(umask "$(printf %o $((0777 & ~0$dirmode)))" && mkdir -p "$d") || exit
+fi
+umask 077
+if [ -z "$mode" ] && [ -f "$f" ]; then cp -p "$f" "$tmp" || exit; else mode=${mode:-0644}; fi
+if [ "$src" = - ]; then cat > "$tmp"; else cat "$src" > "$tmp"; fi || exit
+[ -z "$owner" ] || chown "$owner" "$tmp" || exit
+[ -z "$group" ] || chgrp "$group" "$tmp" || exit
+[ -z "$mode" ] || chmod "$mode" "$tmp" || exit
+if [ -f "$f" ] && cmp -s "$tmp" "$f"; then
+	if [ -n "$(find "$f" -prune ${mode:+-perm "$mode"} ${owner:+-user "$owner"} ${group:+-group "$group"})" ]; then
+		echo unchanged
+		exit 0
+	fi
+	[ -z "$owner" ] || chown "$owner" "$f" || exit
+	[ -z "$group" ] || chgrp "$group" "$f" || exit
+	[ -z "$mode" ] || chmod "$mode" "$f" || exit
+	echo changed
+	exit 0
+fi
+if [ $# -gt 0 ] && ! "$@" >&2; then echo invalid; exit 0; fi
+if [ -n "$backup" ] && [ -f "$f" ]; then cp -p "$f" "$backup" || exit; fi
+mv -f "$tmp" "$f" || exit
+echo changed`
+
+// FileInstall replaces a file through a POSIX shell, for writes that can't go
+// through the executor's Fs, such as escalated and remote ones.
+type FileInstall struct {
+	// Source is the file holding the new contents, which is removed, or "-"
+	// to read them from stdin.
+	Source string
+	File   string
+	// Mode is the octal file mode, e.g. 0644. If empty, an existing file
+	// keeps its mode and a new one gets 0644.
+	Mode string
+	// Owner and Group are set on File unless empty.
+	Owner string
+	Group string
+	// DirMode is the octal mode of the parent directories to create, empty
+	// to leave missing ones missing.
+	DirMode string
+	// Staged is where the new contents are kept next to File until renamed.
+	Staged string
+	// Backup is where the replaced file is copied to, empty for none.
+	Backup string
+	// Validate is the validate command, run against Staged.
+	Validate []string
+}
+
+// Argv returns the command performing the install.
+func (i *FileInstall) Argv() []string {
+	return append([]string{"sh", "-c", installScript, "sh", i.Source, i.File, i.Staged, i.Mode, i.Backup, i.Owner, i.Group, i.DirMode}, i.Validate...)
+}
+
+// Result interprets the output of the install command: whether the file
+// changed, or an ErrValidationFailed error.
+func (i *FileInstall) Result(output string) (bool, error) {
+	lines := strings.Split(strings.TrimSpace(strings.ReplaceAll(output, "\r", "")), "\n")
+	switch last := lines[len(lines)-1]; last {
+	case "changed":
+		return true, nil
+	case "unchanged":
+		return false, nil
+	case "invalid":
+		return false, errors.Wrapf(ErrValidationFailed, "new contents of %s", i.File)
+	default:
+		return false, errors.Errorf("unexpected output of the install of %s: %q", i.File, last)
+	}
+}
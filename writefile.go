@@ -1,8 +1,13 @@
 package godexer
 
 import (
+	"bytes"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/go-extras/errors"
 	"github.com/spf13/afero"
@@ -21,42 +26,371 @@ func NewWriterFileCommand(ectx *ExecutorContext) Command {
 	}
 }
 
+// NewWriterFileCommandWithStorage returns a writefile command reading
+// templates, partials and contentsFromFile from storage, relative to
+// basepath, such as the storage of include commands. Without it, they are
+// read from the executor's Fs.
+func NewWriterFileCommandWithStorage(storage fs.ReadFileFS, basepath string) func(ectx *ExecutorContext) Command {
+	return func(ectx *ExecutorContext) Command {
+		return &WriteFileCommand{
+			BaseCommand: BaseCommand{
+				Ectx: ectx,
+			},
+			storage:  storage,
+			basepath: basepath,
+		}
+	}
+}
+
 type WriteFileCommand struct {
 	BaseCommand
-	Contents    string
-	File        string
+	BecomeOptions
+	WriteOptions
+	Contents string
+	// Template is a template file rendered into the contents. Partials is a
+	// directory of templates it can use by file name without extension, as
+	// in {{ template "upstream" . }}.
+	Template string
+	Partials string
+	// ContentsFromFile is a file holding the contents as is.
+	ContentsFromFile string
+	// ContentsFromVariable names the variable holding the contents.
+	ContentsFromVariable string
+	File                 string
+	// Permissions is the file mode to set; by default existing files keep
+	// theirs and new ones get 0644.
 	Permissions string
+
+	storage  fs.ReadFileFS
+	basepath string
 }
 
 func (r *WriteFileCommand) Execute(variables map[string]any) error {
+	r.changed = false
 	if len(r.File) == 0 {
 		return errors.Errorf("filename in %q is empty", r.StepName)
 	}
 
-	contents, ok := MaybeEvalValue(r.Contents, variables).(string)
-	if !ok {
-		contents = r.Contents
+	contents, err := r.contents(variables)
+	if err != nil {
+		return err
 	}
-	fileName, ok := MaybeEvalValue(r.File, variables).(string)
-	if !ok {
-		fileName = r.File
+	fileName, err := r.EvalString("file", r.File, variables)
+	if err != nil {
+		return err
 	}
 
-	var mode os.FileMode = 0644
-
-	// convert string permissions to octal mode by parsing from oct string
+	// 0 keeps the mode of an existing file
+	var mode os.FileMode
 	if r.Permissions != "" {
-		v, err := strconv.ParseUint(r.Permissions, 8, 32)
-		if err == nil {
-			mode = os.FileMode(v)
+		if mode, err = ParsePermissions(r.Permissions); err != nil {
+			return err
+		}
+	}
+
+	escalation, err := r.Escalation(&r.BaseCommand, variables)
+	if err != nil {
+		return err
+	}
+	if escalation != nil {
+		return r.writeAs(escalation, fileName, contents, mode, variables)
+	}
+
+	return r.write(&r.BaseCommand, fileName, contents, mode, variables)
+}
+
+func (r *WriteFileCommand) contents(variables map[string]any) ([]byte, error) {
+	switch {
+	case r.ContentsFromVariable != "":
+		variable, err := r.EvalString("contentsFromVariable", r.ContentsFromVariable, variables)
+		if err != nil {
+			return nil, err
+		}
+		return VariableContents(variables, variable)
+	case r.ContentsFromFile != "":
+		fileName, err := r.EvalString("contentsFromFile", r.ContentsFromFile, variables)
+		if err != nil {
+			return nil, err
+		}
+		contents, err := r.readFile(fileName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read %q in %q", fileName, r.StepName)
+		}
+		return contents, nil
+	case r.Template != "":
+		return r.renderTemplate(variables)
+	default:
+		contents, err := r.EvalString("contents", r.Contents, variables)
+		return []byte(contents), err
+	}
+}
+
+func (r *WriteFileCommand) renderTemplate(variables map[string]any) ([]byte, error) {
+	name, err := r.EvalString("template", r.Template, variables)
+	if err != nil {
+		return nil, err
+	}
+	src, err := r.readFile(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load template %q in %q", name, r.StepName)
+	}
+
+	partials := map[string]string{}
+	if r.Partials != "" {
+		dir, err := r.EvalString("partials", r.Partials, variables)
+		if err != nil {
+			return nil, err
+		}
+		files, err := r.readDir(dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to load partials %q in %q", dir, r.StepName)
+		}
+		for _, file := range files {
+			partial, err := r.readFile(path.Join(dir, file))
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to load partial %q in %q", file, r.StepName)
+			}
+			partials[strings.TrimSuffix(file, path.Ext(file))] = string(partial)
+		}
+	}
+
+	contents, err := r.RenderTemplate(name, string(src), partials, variables)
+	return []byte(contents), err
+}
+
+// resolve returns the storage or Fs path of name.
+func (r *WriteFileCommand) resolve(name string) string {
+	if r.basepath != "" && !path.IsAbs(name) {
+		name = strings.TrimRight(r.basepath, "/") + "/" + name
+	}
+	if r.storage != nil {
+		name = strings.TrimPrefix(name, "/")
+		if name == "" {
+			name = "."
+		}
+	}
+	return name
+}
+
+func (r *WriteFileCommand) readFile(name string) ([]byte, error) {
+	if r.storage != nil {
+		return r.storage.ReadFile(r.resolve(name))
+	}
+	return afero.ReadFile(r.Ectx.Fs, r.resolve(name))
+}
+
+// readDir returns the names of the files in the directory name.
+func (r *WriteFileCommand) readDir(name string) ([]string, error) {
+	var entries []fs.FileInfo
+	if r.storage != nil {
+		dirEntries, err := fs.ReadDir(r.storage, r.resolve(name))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range dirEntries {
+			info, err := e.Info()
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, info)
+		}
+	} else {
+		var err error
+		if entries, err = afero.ReadDir(r.Ectx.Fs, r.resolve(name)); err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// write replaces the file on the executor's Fs with contents staged in a
+// temp file next to it, unless it already has them. A mode of 0 keeps the
+// mode of an existing file and is 0644 for a new one; without owner and group
+// the file keeps its ownership.
+func (w *WriteOptions) write(r *BaseCommand, fileName string, contents []byte, mode os.FileMode, variables map[string]any) error {
+	owner, group, err := w.Ownership(r, variables)
+	if err != nil {
+		return err
+	}
+	dirMode, err := w.DirMode()
+	if err != nil {
+		return err
+	}
+
+	fs := r.Ectx.Fs
+	if dirMode != 0 {
+		if err := fs.MkdirAll(filepath.Dir(fileName), dirMode); err != nil {
+			return errors.Wrapf(err, "can't create the parent directories of %s", fileName)
+		}
+	}
+	info, err := fs.Stat(fileName)
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if mode == 0 && exists {
+		mode = info.Mode() & permissionBits
+	} else if mode == 0 {
+		mode = 0o644
+	}
+	if exists {
+		old, err := afero.ReadFile(fs, fileName)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(old, contents) {
+			// chown clears the setuid and setgid bits, so it goes first
+			chowned, err := r.Ectx.Chown(fileName, owner, group)
+			if err != nil {
+				return err
+			}
+			w.changed = chowned
+			if info.Mode()&permissionBits != mode {
+				r.Ectx.Logger.Debugf("Changing the mode of %s", fileName)
+				w.changed = true
+			}
+			if w.changed {
+				if err := fs.Chmod(fileName, mode); err != nil {
+					return err
+				}
+			}
+			if !w.changed {
+				r.Ectx.Logger.Debugf("%s is up to date", fileName)
+			}
+			return nil
 		}
 	}
 
 	r.Ectx.Logger.Debugf("Writing to %s", fileName)
-	err := afero.WriteFile(r.Ectx.Fs, fileName, []byte(contents), mode)
+	staged, err := afero.TempFile(fs, filepath.Dir(fileName), "."+filepath.Base(fileName)+".godexer-*")
 	if err != nil {
+		return errors.Wrap(err, "can't create a temp file")
+	}
+	stagedName := staged.Name()
+	renamed := false
+	defer func() {
+		if !renamed {
+			_ = fs.Remove(stagedName)
+		}
+	}()
+
+	_, err = staged.Write(contents)
+	if closeErr := staged.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "can't write %s", stagedName)
+	}
+	if _, err := r.Ectx.Chown(stagedName, owner, group); err != nil {
+		return err
+	}
+	if exists && owner == "" && group == "" {
+		w.keepOwnership(r, stagedName, info)
+	}
+	if err := fs.Chmod(stagedName, mode); err != nil {
+		return errors.Wrapf(err, "can't write %s", stagedName)
+	}
+
+	if err := w.validate(r, fileName, stagedName, variables); err != nil {
 		return err
 	}
 
+	if backup := w.BackupPath(fileName, r.Ectx.Now()); backup != "" && exists {
+		if err := copyFile(fs, fileName, backup, info.Mode()); err != nil {
+			return errors.Wrapf(err, "can't back up %s", fileName)
+		}
+	}
+
+	if err := fs.Rename(stagedName, fileName); err != nil {
+		return err
+	}
+	renamed = true
+	w.changed = true
 	return nil
 }
+
+// validate runs the validate command against the staged contents of fileName.
+func (w *WriteOptions) validate(r *BaseCommand, fileName, staged string, variables map[string]any) error {
+	argv, err := w.ValidateArgv(r, variables, staged)
+	if err != nil || argv == nil {
+		return err
+	}
+
+	process, err := r.Ectx.StartProcess(&ProcessSpec{
+		Path:       argv[0],
+		Args:       argv[1:],
+		InheritEnv: true,
+		Stdout:     r.Ectx.Stdout,
+		Stderr:     r.Ectx.Stderr,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to run the validate command")
+	}
+	err = process.Wait()
+	if _, ok := exitCode(err); ok {
+		return errors.Wrapf(ErrValidationFailed, "new contents of %s", fileName)
+	}
+	return err
+}
+
+func copyFile(fs afero.Fs, src, dst string, mode os.FileMode) error {
+	data, err := afero.ReadFile(fs, src)
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(fs, dst, data, mode)
+}
+
+// keepOwnership gives the staged file the ownership of the file it replaces.
+// Only root can give files away, so failing that is not an error.
+func (w *WriteOptions) keepOwnership(r *BaseCommand, stagedName string, info os.FileInfo) {
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return
+	}
+	if _, err := r.Ectx.Chown(stagedName, strconv.Itoa(uid), strconv.Itoa(gid)); err != nil {
+		r.Ectx.Logger.Warnf("Can't keep the ownership of %s: %v", info.Name(), err)
+	}
+}
+
+// writeAs writes the file through a shell run with the escalation, as the
+// executor's Fs can't change users.
+func (r *WriteFileCommand) writeAs(escalation *Escalation, fileName string, contents []byte, mode os.FileMode, variables map[string]any) error {
+	modeArg := ""
+	if mode != 0 {
+		modeArg = FormatPermissions(mode)
+	}
+	install, err := r.Install(&r.BaseCommand, variables, "-", fileName, modeArg)
+	if err != nil {
+		return err
+	}
+	argv, err := escalation.Wrap(install.Argv(), true)
+	if err != nil {
+		return err
+	}
+
+	r.Ectx.Logger.Debugf("Writing to %s as %s", fileName, escalation.User)
+	var output bytes.Buffer
+	process, err := r.Ectx.StartProcess(&ProcessSpec{
+		Path:       argv[0],
+		Args:       argv[1:],
+		InheritEnv: true,
+		Stdin:      escalation.PasswordStdin(bytes.NewReader(contents)),
+		Stdout:     &output,
+		Stderr:     r.Ectx.Stderr,
+	})
+	if err == nil {
+		err = process.Wait()
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to write %s as %s", fileName, escalation.User)
+	}
+	return r.Finish(install, output.String())
+}
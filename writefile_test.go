@@ -2,9 +2,16 @@ package godexer_test
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/spf13/afero"
@@ -74,6 +81,35 @@ func TestWriteFile(t *testing.T) {
 		c.Assert(info.Mode().Perm(), qt.Equals, os.FileMode(0755))
 	})
 
+	t.Run("Execute_SpecialPermissions", func(t *testing.T) {
+		c := qt.New(t)
+		fs := afero.NewMemMapFs()
+
+		cmd := godexer.NewWriterFileCommand(&godexer.ExecutorContext{
+			Fs:     fs,
+			Stdout: &bytes.Buffer{},
+			Stderr: &bytes.Buffer{},
+		})
+		ex := cmd.(*godexer.WriteFileCommand)
+		ex.File = "dummy"
+		ex.Contents = "test content"
+		ex.Permissions = "4755"
+		ex.Ectx.Logger = &logger.Logger{}
+
+		c.Assert(ex.Execute(map[string]any{}), qt.IsNil)
+		info, err := fs.Stat("dummy")
+		c.Assert(err, qt.IsNil)
+		c.Assert(info.Mode(), qt.Equals, os.ModeSetuid|0o755)
+
+		// losing the setuid bit is a change
+		c.Assert(fs.Chmod("dummy", 0o755), qt.IsNil)
+		c.Assert(ex.Execute(map[string]any{}), qt.IsNil)
+		c.Assert(ex.Changed(), qt.IsTrue)
+		info, err = fs.Stat("dummy")
+		c.Assert(err, qt.IsNil)
+		c.Assert(info.Mode(), qt.Equals, os.ModeSetuid|0o755)
+	})
+
 	t.Run("Execute_InvalidPermissions", func(t *testing.T) {
 		c := qt.New(t)
 		fs := afero.NewMemMapFs()
@@ -91,12 +127,10 @@ func TestWriteFile(t *testing.T) {
 
 		m := make(map[string]any)
 		err := ex.Execute(m)
-		c.Assert(err, qt.IsNil)
+		c.Assert(err, qt.ErrorMatches, `invalid permissions "invalid", must be an octal mode such as 0644`)
 
-		// Verify it falls back to default permissions (0644) on invalid input
-		info, err := fs.Stat("dummy")
-		c.Assert(err, qt.IsNil)
-		c.Assert(info.Mode().Perm(), qt.Equals, os.FileMode(0644))
+		_, err = fs.Stat("dummy")
+		c.Assert(err, qt.ErrorIs, os.ErrNotExist)
 	})
 
 	t.Run("Execute_EvaluatedFilename", func(t *testing.T) {
@@ -211,3 +245,463 @@ func TestWriteFile(t *testing.T) {
 		c.Assert(err, qt.ErrorMatches, "filename in \"step\" is empty")
 	})
 }
+
+// validateRunner passes the validation of contents that don't contain
+// "invalid", reading the staged file from fs.
+type validateRunner struct {
+	fs    afero.Fs
+	specs []godexer.ProcessSpec
+}
+
+func (r *validateRunner) Start(spec *godexer.ProcessSpec) (godexer.Process, error) {
+	r.specs = append(r.specs, *spec)
+	data, err := afero.ReadFile(r.fs, strings.TrimPrefix(spec.Args[len(spec.Args)-1], "--config="))
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(string(data), "invalid") {
+		return fakeProcess{err: fakeExitError(1)}, nil
+	}
+	return fakeProcess{}, nil
+}
+
+func TestWriteFile_Update(t *testing.T) {
+	const scenario = `commands:
+  - type: writefile
+    stepName: config
+    file: /etc/app/app.conf
+    contents: '{{ .contents }}'
+    permissions: "0600"
+    backup: true
+    validate: ["app", "--check", "--config=%s"]
+`
+	setup := func(c *qt.C) (afero.Fs, *validateRunner, func(contents string) (map[string]any, error)) {
+		fs := afero.NewMemMapFs()
+		c.Assert(afero.WriteFile(fs, "/etc/app/app.conf", []byte("old"), 0o644), qt.IsNil)
+		runner := &validateRunner{fs: fs}
+		clock := &fakeClock{now: time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC)}
+		ex, err := godexer.NewWithScenario(scenario, godexer.WithFS(fs), godexer.WithProcessRunner(runner),
+			godexer.WithClock(clock), godexer.WithLogger(&logger.Logger{}))
+		c.Assert(err, qt.IsNil)
+		return fs, runner, func(contents string) (map[string]any, error) {
+			vars := map[string]any{"contents": contents}
+			return vars, ex.Execute(vars)
+		}
+	}
+
+	files := func(c *qt.C, fs afero.Fs) map[string]string {
+		result := map[string]string{}
+		c.Assert(afero.Walk(fs, "/etc/app", func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			data, err := afero.ReadFile(fs, path)
+			result[path] = fmt.Sprintf("%s %o", data, info.Mode().Perm())
+			return err
+		}), qt.IsNil)
+		return result
+	}
+
+	t.Run("changes", func(t *testing.T) {
+		c := qt.New(t)
+		fs, runner, run := setup(c)
+
+		vars, err := run("new")
+		c.Assert(err, qt.IsNil)
+		c.Assert(vars["__step:config:changed"], qt.IsTrue)
+		c.Assert(files(c, fs), qt.DeepEquals, map[string]string{
+			"/etc/app/app.conf":                     "new 600",
+			"/etc/app/app.conf.20250301T123000.bak": "old 644",
+		})
+		c.Assert(runner.specs, qt.HasLen, 1)
+		c.Assert(runner.specs[0].Path, qt.Equals, "app")
+		c.Assert(runner.specs[0].Args[1], qt.Matches, `--config=/etc/app/\.app\.conf\.godexer-\d+`)
+
+		// identical contents are left alone
+		vars, err = run("new")
+		c.Assert(err, qt.IsNil)
+		c.Assert(vars["__step:config:changed"], qt.IsFalse)
+		c.Assert(runner.specs, qt.HasLen, 1)
+
+		// a different mode only changes the mode
+		c.Assert(fs.Chmod("/etc/app/app.conf", 0o644), qt.IsNil)
+		vars, err = run("new")
+		c.Assert(err, qt.IsNil)
+		c.Assert(vars["__step:config:changed"], qt.IsTrue)
+		c.Assert(files(c, fs)["/etc/app/app.conf"], qt.Equals, "new 600")
+		c.Assert(runner.specs, qt.HasLen, 1)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		c := qt.New(t)
+		fs, _, run := setup(c)
+
+		vars, err := run("invalid")
+		c.Assert(err, qt.ErrorIs, godexer.ErrValidationFailed)
+		c.Assert(err, qt.ErrorMatches, `.*new contents of /etc/app/app.conf: validation failed`)
+		c.Assert(vars["__step:config:changed"], qt.IsNil)
+		c.Assert(files(c, fs), qt.DeepEquals, map[string]string{"/etc/app/app.conf": "old 644"})
+	})
+}
+
+func TestWriteFile_KeepsMode(t *testing.T) {
+	const scenario = `commands:
+  - type: writefile
+    stepName: secrets
+    file: '{{ .file }}'
+    contents: '{{ .contents }}'
+`
+	run := func(c *qt.C, ex *godexer.Executor, file, contents string) bool {
+		vars := map[string]any{"file": file, "contents": contents}
+		c.Assert(ex.Execute(vars), qt.IsNil)
+		return vars["__step:secrets:changed"].(bool)
+	}
+
+	t.Run("mode", func(t *testing.T) {
+		c := qt.New(t)
+		fs := afero.NewMemMapFs()
+		c.Assert(afero.WriteFile(fs, "/etc/app/secrets.conf", []byte("token=old\n"), 0o600), qt.IsNil)
+		ex, err := godexer.NewWithScenario(scenario, godexer.WithFS(fs), godexer.WithLogger(&logger.Logger{}))
+		c.Assert(err, qt.IsNil)
+
+		// without permissions, an existing file keeps its mode, whether the
+		// contents change or not
+		c.Assert(run(c, ex, "/etc/app/secrets.conf", "token=new\n"), qt.IsTrue)
+		c.Assert(run(c, ex, "/etc/app/secrets.conf", "token=new\n"), qt.IsFalse)
+		info, err := fs.Stat("/etc/app/secrets.conf")
+		c.Assert(err, qt.IsNil)
+		c.Assert(info.Mode().Perm(), qt.Equals, os.FileMode(0o600))
+
+		// and new files get 0644
+		c.Assert(run(c, ex, "/etc/app/app.conf", "listen 80\n"), qt.IsTrue)
+		info, err = fs.Stat("/etc/app/app.conf")
+		c.Assert(err, qt.IsNil)
+		c.Assert(info.Mode().Perm(), qt.Equals, os.FileMode(0o644))
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		if os.Geteuid() != 0 {
+			t.Skip("giving files away needs root")
+		}
+		c := qt.New(t)
+		file := filepath.Join(t.TempDir(), "secrets.conf")
+		c.Assert(os.WriteFile(file, []byte("token=old\n"), 0o600), qt.IsNil)
+		c.Assert(os.Chown(file, 65534, -1), qt.IsNil)
+		ex, err := godexer.NewWithScenario(scenario, godexer.WithLogger(&logger.Logger{}))
+		c.Assert(err, qt.IsNil)
+
+		c.Assert(run(c, ex, file, "token=new\n"), qt.IsTrue)
+		stat, err := exec.Command("stat", "-c", "%a %U", file).Output()
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(stat), qt.Equals, "600 nobody\n")
+	})
+}
+
+func TestParsePermissions(t *testing.T) {
+	tests := []struct {
+		s    string
+		mode os.FileMode
+	}{
+		{"0644", 0o644},
+		{"755", 0o755},
+		{"4755", os.ModeSetuid | 0o755},
+		{"2750", os.ModeSetgid | 0o750},
+		{"1777", os.ModeSticky | 0o777},
+		{"7777", os.ModeSetuid | os.ModeSetgid | os.ModeSticky | 0o777},
+	}
+	for _, tc := range tests {
+		t.Run(tc.s, func(t *testing.T) {
+			c := qt.New(t)
+			mode, err := godexer.ParsePermissions(tc.s)
+			c.Assert(err, qt.IsNil)
+			c.Assert(mode, qt.Equals, tc.mode)
+			c.Assert(godexer.FormatPermissions(mode), qt.Equals, fmt.Sprintf("%04s", tc.s))
+		})
+	}
+
+	for _, s := range []string{"10000", "0o644", "-1", "rw", ""} {
+		_, err := godexer.ParsePermissions(s)
+		qt.Check(t, err, qt.ErrorMatches, `invalid permissions ".*", must be an octal mode such as 0644`)
+	}
+}
+
+func TestFileInstall(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "conf.d")
+	file := filepath.Join(dir, "app.conf")
+
+	install := func(c *qt.C, contents, mode string, validate ...string) (bool, error) {
+		w := &godexer.WriteOptions{
+			Owner:          fmt.Sprint(os.Getuid()),
+			Group:          fmt.Sprint(os.Getgid()),
+			Mkdirs:         true,
+			DirPermissions: "0750",
+			Backup:         true,
+			Validate:       validate,
+		}
+		r := &godexer.BaseCommand{Ectx: &godexer.ExecutorContext{
+			Clock: &fakeClock{now: time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC)},
+		}}
+		i, err := w.Install(r, map[string]any{}, "-", file, mode)
+		c.Assert(err, qt.IsNil)
+
+		argv := i.Argv()
+		//nolint:gosec // runs the install script under test
+		cmd := exec.Command(argv[0], argv[1:]...)
+		cmd.Stdin = strings.NewReader(contents)
+		output, err := cmd.Output()
+		c.Assert(err, qt.IsNil)
+		err = w.Finish(i, string(output))
+		return w.Changed(), err
+	}
+
+	files := func(c *qt.C) map[string]string {
+		entries, err := os.ReadDir(dir)
+		c.Assert(err, qt.IsNil)
+		result := map[string]string{}
+		for _, e := range entries {
+			data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			c.Assert(err, qt.IsNil)
+			info, err := e.Info()
+			c.Assert(err, qt.IsNil)
+			result[e.Name()] = fmt.Sprintf("%s %o", data, info.Mode().Perm())
+		}
+		return result
+	}
+
+	c := qt.New(t)
+	changed, err := install(c, "one", "0640")
+	c.Assert(err, qt.IsNil)
+	c.Assert(changed, qt.IsTrue)
+	c.Assert(files(c), qt.DeepEquals, map[string]string{"app.conf": "one 640"})
+	info, err := os.Stat(dir)
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.Mode().Perm(), qt.Equals, os.FileMode(0o750))
+
+	changed, err = install(c, "one", "0640")
+	c.Assert(err, qt.IsNil)
+	c.Assert(changed, qt.IsFalse)
+
+	changed, err = install(c, "one", "0600")
+	c.Assert(err, qt.IsNil)
+	c.Assert(changed, qt.IsTrue)
+	c.Assert(files(c), qt.DeepEquals, map[string]string{"app.conf": "one 600"})
+
+	changed, err = install(c, "two", "0600", "grep", "-q", "two", "%s")
+	c.Assert(err, qt.IsNil)
+	c.Assert(changed, qt.IsTrue)
+	c.Assert(files(c), qt.DeepEquals, map[string]string{
+		"app.conf":                     "two 600",
+		"app.conf.20250301T123000.bak": "one 600",
+	})
+
+	changed, err = install(c, "three", "0600", "grep", "-q", "two", "%s")
+	c.Assert(err, qt.ErrorIs, godexer.ErrValidationFailed)
+	c.Assert(changed, qt.IsFalse)
+	c.Assert(files(c)["app.conf"], qt.Equals, "two 600")
+	c.Assert(files(c), qt.HasLen, 2)
+
+	// the setgid bit is set, and compared when checking for changes
+	for _, want := range []bool{true, false} {
+		changed, err = install(c, "two", "2750")
+		c.Assert(err, qt.IsNil)
+		c.Assert(changed, qt.Equals, want)
+		info, err = os.Stat(file)
+		c.Assert(err, qt.IsNil)
+		c.Assert(info.Mode(), qt.Equals, os.ModeSetgid|0o750)
+	}
+}
+
+// fakeChowner records chowns and reports them as changes unless the file
+// already has the ownership.
+type fakeChowner struct {
+	owners map[string]string
+}
+
+func (f *fakeChowner) Chown(_ afero.Fs, name, owner, group string) (bool, error) {
+	if owner == "nobody" {
+		return false, errors.New("unknown owner")
+	}
+	ownership := owner + ":" + group
+	if f.owners[name] == ownership {
+		return false, nil
+	}
+	f.owners[name] = ownership
+	return true, nil
+}
+
+func TestWriteFile_Ownership(t *testing.T) {
+	c := qt.New(t)
+	fs := afero.NewMemMapFs()
+	chowner := &fakeChowner{owners: map[string]string{}}
+	ex, err := godexer.NewWithScenario(`commands:
+  - type: writefile
+    stepName: config
+    file: /etc/app/conf.d/app.conf
+    contents: "listen 80"
+    owner: '{{ .owner }}'
+    group: app
+    mkdirs: true
+    dirPermissions: "0750"
+`, godexer.WithFS(fs), godexer.WithChowner(chowner), godexer.WithLogger(&logger.Logger{}))
+	c.Assert(err, qt.IsNil)
+
+	vars := map[string]any{"owner": "www"}
+	c.Assert(ex.Execute(vars), qt.IsNil)
+	c.Assert(vars["__step:config:changed"], qt.IsTrue)
+	c.Assert(chowner.owners, qt.HasLen, 1)
+	for name, ownership := range chowner.owners {
+		c.Assert(name, qt.Matches, `/etc/app/conf\.d/\.app\.conf\.godexer-.*`)
+		c.Assert(ownership, qt.Equals, "www:app")
+	}
+	info, err := fs.Stat("/etc/app/conf.d")
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.Mode().Perm(), qt.Equals, os.FileMode(0o750))
+
+	// the staged file was renamed, so the installed one is chowned anew
+	c.Assert(ex.Execute(vars), qt.IsNil)
+	c.Assert(vars["__step:config:changed"], qt.IsTrue)
+	c.Assert(chowner.owners["/etc/app/conf.d/app.conf"], qt.Equals, "www:app")
+
+	c.Assert(ex.Execute(vars), qt.IsNil)
+	c.Assert(vars["__step:config:changed"], qt.IsFalse)
+
+	vars["owner"] = "root"
+	c.Assert(ex.Execute(vars), qt.IsNil)
+	c.Assert(vars["__step:config:changed"], qt.IsTrue)
+	c.Assert(chowner.owners["/etc/app/conf.d/app.conf"], qt.Equals, "root:app")
+
+	vars["owner"] = "nobody"
+	err = ex.Execute(vars)
+	c.Assert(err, qt.ErrorMatches, `(?s).*can't change the ownership of /etc/app/conf\.d/app\.conf: unknown owner`)
+}
+
+func TestOSChowner(t *testing.T) {
+	c := qt.New(t)
+	file := filepath.Join(t.TempDir(), "app.conf")
+	c.Assert(os.WriteFile(file, nil, 0o600), qt.IsNil)
+
+	fs := afero.NewOsFs()
+	uid, gid := fmt.Sprint(os.Getuid()), fmt.Sprint(os.Getgid())
+	changed, err := godexer.OSChowner{}.Chown(fs, file, uid, gid)
+	c.Assert(err, qt.IsNil)
+	c.Assert(changed, qt.IsFalse)
+
+	_, err = godexer.OSChowner{}.Chown(fs, file, "godexer-no-such-user", "")
+	c.Assert(err, qt.ErrorMatches, `unknown owner "godexer-no-such-user": .*`)
+
+	// ownership can't be read on other filesystems, so chowns aren't changes
+	mem := afero.NewMemMapFs()
+	c.Assert(afero.WriteFile(mem, "/app.conf", nil, 0o600), qt.IsNil)
+	changed, err = godexer.OSChowner{}.Chown(mem, "/app.conf", "0", "0")
+	c.Assert(err, qt.IsNil)
+	c.Assert(changed, qt.IsFalse)
+}
+
+func TestWriteFile_Template(t *testing.T) {
+	const scenario = `commands:
+  - type: writefile
+    file: /etc/nginx/nginx.conf
+    template: '{{ .template }}'
+    partials: templates/partials
+`
+	files := map[string]string{
+		"templates/nginx.conf.tmpl":            "{{ range .backends }}{{ template \"upstream\" . }}{{ end }}listen {{ .port }};\n",
+		"templates/partials/upstream.tmpl":     "server {{ . | upper }};\n",
+		"templates/partials/nested/other.tmpl": "ignored",
+	}
+	vars := func() map[string]any {
+		return map[string]any{"template": "templates/nginx.conf.tmpl", "backends": []any{"a", "b"}, "port": 80}
+	}
+	const want = "server A;\nserver B;\nlisten 80;\n"
+
+	t.Run("fs", func(t *testing.T) {
+		c := qt.New(t)
+		fs := afero.NewMemMapFs()
+		for name, contents := range files {
+			c.Assert(afero.WriteFile(fs, name, []byte(contents), 0o644), qt.IsNil)
+		}
+		ex, err := godexer.NewWithScenario(scenario, godexer.WithFS(fs), godexer.WithLogger(&logger.Logger{}))
+		c.Assert(err, qt.IsNil)
+		c.Assert(ex.Execute(vars()), qt.IsNil)
+
+		data, err := afero.ReadFile(fs, "/etc/nginx/nginx.conf")
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(data), qt.Equals, want)
+	})
+
+	t.Run("storage", func(t *testing.T) {
+		c := qt.New(t)
+		storage := fstest.MapFS{}
+		for name, contents := range files {
+			storage["project/"+name] = &fstest.MapFile{Data: []byte(contents)}
+		}
+		fs := afero.NewMemMapFs()
+		cmds := godexer.GetRegisteredCommands()
+		cmds["writefile"] = godexer.NewWriterFileCommandWithStorage(storage, "/project")
+		ex, err := godexer.NewWithScenario(scenario, godexer.WithFS(fs), godexer.WithCommandTypes(cmds),
+			godexer.WithLogger(&logger.Logger{}))
+		c.Assert(err, qt.IsNil)
+		c.Assert(ex.Execute(vars()), qt.IsNil)
+
+		data, err := afero.ReadFile(fs, "/etc/nginx/nginx.conf")
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(data), qt.Equals, want)
+
+		v := vars()
+		v["template"] = "templates/missing.tmpl"
+		err = ex.Execute(v)
+		c.Assert(err, qt.ErrorMatches, `(?s).*unable to load template "templates/missing.tmpl" in "": .*`)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		c := qt.New(t)
+		fs := afero.NewMemMapFs()
+		c.Assert(afero.WriteFile(fs, "bad.tmpl", []byte("{{ .port"), 0o644), qt.IsNil)
+		c.Assert(afero.WriteFile(fs, "strict.tmpl", []byte("{{ .missing }}"), 0o644), qt.IsNil)
+
+		ex, err := godexer.NewWithScenario(`commands:
+  - type: writefile
+    file: out
+    template: bad.tmpl
+`, godexer.WithFS(fs), godexer.WithLogger(&logger.Logger{}))
+		c.Assert(err, qt.IsNil)
+		c.Assert(ex.Execute(map[string]any{}), qt.ErrorMatches, `(?s).*failed to parse template bad\.tmpl: .*`)
+
+		ex, err = godexer.NewWithScenario(`commands:
+  - type: writefile
+    file: out
+    template: strict.tmpl
+`, godexer.WithFS(fs), godexer.WithLogger(&logger.Logger{}), godexer.WithStrictTemplates())
+		c.Assert(err, qt.IsNil)
+		c.Assert(ex.Execute(map[string]any{}), qt.ErrorMatches, `(?s).*failed to render template strict\.tmpl: .*map has no entry for key "missing".*`)
+	})
+}
+
+func TestWriteFile_ContentsFrom(t *testing.T) {
+	c := qt.New(t)
+	fs := afero.NewMemMapFs()
+	c.Assert(afero.WriteFile(fs, "/src/motd", []byte("{{ not rendered }}"), 0o644), qt.IsNil)
+
+	ex, err := godexer.NewWithScenario(`commands:
+  - type: writefile
+    file: /etc/motd
+    contentsFromFile: /src/motd
+  - type: writefile
+    file: /etc/banner
+    contentsFromVariable: '{{ .var }}'
+`, godexer.WithFS(fs), godexer.WithLogger(&logger.Logger{}))
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(ex.Execute(map[string]any{"var": "banner", "banner": []byte("welcome")}), qt.IsNil)
+	data, err := afero.ReadFile(fs, "/etc/motd")
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, "{{ not rendered }}")
+	data, err = afero.ReadFile(fs, "/etc/banner")
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, "welcome")
+
+	err = ex.Execute(map[string]any{"var": "banner", "banner": 42})
+	c.Assert(err, qt.ErrorMatches, `.*contents variable "banner" must be a string, got int`)
+	err = ex.Execute(map[string]any{"var": "missing"})
+	c.Assert(err, qt.ErrorMatches, `.*contents variable "missing" is not set`)
+}